@@ -1,33 +1,373 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"crud-in-go-lang/internal/controller"
+	"crud-in-go-lang/internal/replication"
 	"crud-in-go-lang/internal/repository"
 	"crud-in-go-lang/internal/router"
 	"crud-in-go-lang/internal/service"
+
+	// database/sql drivers that sql+<driver>:// DSNs can name. lib/pq
+	// registers "postgres"; add a blank import here to link in another.
+	_ "github.com/lib/pq"
+)
+
+
+// defaultDSN is used when BOOKS_DSN is not set. See repository.Open for the
+// available schemes. The file is watched so hand edits show up live.
+const defaultDSN = "file://data/books.json?watch=2s"
+
+
+const defaultSnapshotDir = "data/snapshots"
+
+
+const defaultHistoryFile = "data/history.jsonl"
+
+
+const (
+	defaultAuthorsFile    = "data/authors.json"
+	defaultPublishersFile = "data/publishers.json"
+	defaultStockLedger    = "data/stock.jsonl"
+	defaultReservations   = "data/reservations.json"
+	defaultLocationsFile  = "data/locations.json"
 )
 
+
+// reservationReapInterval is how often expired reservations are cleared
+// out. They stop holding copies at expiry whether or not they have been.
+const reservationReapInterval = 30 * time.Second
+
+
 func main() {
 
-	repo, err := repository.NewFileRepository("data/books.json")
-	if err != nil {
-		log.Fatalf("Failed to initialize repository: %v", err)
+	// Any arguments select a command instead of starting the server.
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 
-	svc := service.NewBookService(repo)
+	repo, replicationCtrl := replicate(cache(openRepository()))
+	authors, publishers := openAuthors(), openPublishers()
+	ledger := openStockLedger(repo)
+	svc := newService(repo, append(checkReferences(authors, publishers), service.WithStockLedger(ledger))...)
+
+
+	// Stock lives on the leader alone; a follower leaves it out of book
+	// reads and sends its endpoints to the leader.
+	locations := openLocations()
+	stock := service.NewStockService(ledger, svc, backorderPolicy(), append(reservations(repo), service.WithLocations(locations))...)
+	var bookOpts []controller.BookOption
+	if !following() {
+		stock.StartReaper(reservationReapInterval)
+		defer stock.StopReaper()
+		bookOpts = append(bookOpts, controller.WithStockLevels(stock))
+	}
 
 
-	ctrl := controller.NewBookController(svc)
+	ctrl := controller.NewBookController(svc, bookOpts...)
+	admin := controller.NewAdminController(svc)
+	authorCtrl := controller.NewAuthorController(service.NewAuthorService(authors, svc, deletePolicy()))
+	publisherCtrl := controller.NewPublisherController(service.NewPublisherService(publishers, svc, deletePolicy()))
+	stockCtrl := controller.NewStockController(stock)
+	locationCtrl := controller.NewLocationController(service.NewLocationService(locations, stock))
 
 
-	r := router.SetupRouter(ctrl)
+	r := router.SetupRouter(ctrl, admin, replicationCtrl, authorCtrl, publisherCtrl, stockCtrl, locationCtrl)
 
 
-	port := "8080"
+	port := getenv("PORT", "8080")
 	log.Printf("Server starting on port %s...", port)
 	log.Fatal(http.ListenAndServe(":"+port, r))
-}
\ No newline at end of file
+}
+
+
+func openRepository() repository.BookRepository {
+	repo, err := repository.Open(getenv("BOOKS_DSN", defaultDSN))
+	if err != nil {
+		log.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	return repo
+}
+
+
+func openAuthors() repository.AuthorRepository {
+	authors, err := repository.NewFileAuthorRepository(getenv("BOOKS_AUTHORS_FILE", defaultAuthorsFile))
+	if err != nil {
+		log.Fatalf("Failed to open authors: %v", err)
+	}
+
+	return authors
+}
+
+
+func openPublishers() repository.PublisherRepository {
+	publishers, err := repository.NewFilePublisherRepository(getenv("BOOKS_PUBLISHERS_FILE", defaultPublishersFile))
+	if err != nil {
+		log.Fatalf("Failed to open publishers: %v", err)
+	}
+
+	return publishers
+}
+
+
+func openLocations() repository.LocationRepository {
+	locations, err := repository.NewFileLocationRepository(getenv("BOOKS_LOCATIONS_FILE", defaultLocationsFile))
+	if err != nil {
+		log.Fatalf("Failed to open locations: %v", err)
+	}
+
+	return locations
+}
+
+
+// openStockLedger keeps the ledger in BOOKS_STOCK_LEDGER, encrypted like
+// the catalog of repo.
+func openStockLedger(repo repository.BookRepository) repository.StockLedger {
+	ledger, err := repository.NewFileStockLedger(getenv("BOOKS_STOCK_LEDGER", defaultStockLedger), encryptLike(repo))
+	if err != nil {
+		log.Fatalf("Failed to open stock ledger: %v", err)
+	}
+
+	return ledger
+}
+
+
+// reservations keeps reservations in BOOKS_RESERVATIONS_FILE, encrypted
+// like the catalog of repo, holding copies for BOOKS_RESERVATION_TTL.
+func reservations(repo repository.BookRepository) []service.StockOption {
+	held, err := repository.NewFileReservationRepository(getenv("BOOKS_RESERVATIONS_FILE", defaultReservations), encryptLike(repo))
+	if err != nil {
+		log.Fatalf("Failed to open reservations: %v", err)
+	}
+	opts := []service.StockOption{service.WithReservations(held)}
+
+	if value := os.Getenv("BOOKS_RESERVATION_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			log.Fatalf("Invalid BOOKS_RESERVATION_TTL: %q", value)
+		}
+		opts = append(opts, service.WithReservationTTL(ttl))
+	}
+
+	return opts
+}
+
+
+// checkReferences makes book writes check that their author and publisher
+// exist unless BOOKS_CHECK_REFERENCES is false.
+func checkReferences(authors repository.AuthorRepository, publishers repository.PublisherRepository) []service.Option {
+	enabled, err := strconv.ParseBool(getenv("BOOKS_CHECK_REFERENCES", "true"))
+	if err != nil {
+		log.Fatalf("Invalid BOOKS_CHECK_REFERENCES: %v", err)
+	}
+	if !enabled {
+		return nil
+	}
+
+	return []service.Option{service.WithAuthors(authors), service.WithPublishers(publishers)}
+}
+
+
+// deletePolicy reads what deleting an author or publisher does to their
+// books from BOOKS_DELETE_POLICY.
+func deletePolicy() service.DeletePolicy {
+	policy, err := service.ParseDeletePolicy(os.Getenv("BOOKS_DELETE_POLICY"))
+	if err != nil {
+		log.Fatalf("Invalid BOOKS_DELETE_POLICY: %v", err)
+	}
+
+	return policy
+}
+
+
+// backorderPolicy reads from BOOKS_BACKORDERS whether sales may take stock
+// below zero.
+func backorderPolicy() service.BackorderPolicy {
+	policy, err := service.ParseBackorderPolicy(os.Getenv("BOOKS_BACKORDERS"))
+	if err != nil {
+		log.Fatalf("Invalid BOOKS_BACKORDERS: %v", err)
+	}
+
+	return policy
+}
+
+
+// cache puts a CachedRepository in front of repo when BOOKS_CACHE_TTL is
+// set. BOOKS_CACHE_ENTRIES bounds its size.
+func cache(repo repository.BookRepository) repository.BookRepository {
+	value := os.Getenv("BOOKS_CACHE_TTL")
+	if value == "" {
+		return repo
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid BOOKS_CACHE_TTL: %v", err)
+	}
+
+	entries, err := strconv.Atoi(getenv("BOOKS_CACHE_ENTRIES", "0"))
+	if err != nil {
+		log.Fatalf("Invalid BOOKS_CACHE_ENTRIES: %v", err)
+	}
+
+	return repository.NewCachedRepository(repo, entries, ttl)
+}
+
+
+// following reports whether BOOKS_LEADER_URL makes the server a follower.
+func following() bool {
+	return os.Getenv("BOOKS_LEADER_URL") != ""
+}
+
+
+// replicate makes the server a follower of BOOKS_LEADER_URL if it is set,
+// and otherwise a leader that other servers can follow.
+func replicate(repo repository.BookRepository) (repository.BookRepository, *controller.ReplicationController) {
+	leader := os.Getenv("BOOKS_LEADER_URL")
+	if !following() {
+		changes := repository.NewChangeLog(repo, 0)
+		return changes, controller.NewReplicationController(changes, nil)
+	}
+
+	interval, err := time.ParseDuration(getenv("BOOKS_REPLICATION_INTERVAL", replication.DefaultInterval.String()))
+	if err != nil {
+		log.Fatalf("Invalid BOOKS_REPLICATION_INTERVAL: %v", err)
+	}
+
+	follower := replication.NewFollower(leader, repo, interval)
+	follower.Start()
+	log.Printf("Following %s", leader)
+
+	return repo, controller.NewReplicationController(nil, follower)
+}
+
+
+// encryptLike encrypts the stock ledger, reservations and revision history
+// with the keyring of the catalog, so none of them is less protected.
+func encryptLike(repo repository.BookRepository) repository.Option {
+	return repository.WithKeyring(repository.EncryptionKeyring(repo))
+}
+
+
+// newService wires the book service around repo from the environment.
+// Revision history is kept in BOOKS_HISTORY_FILE.
+func newService(repo repository.BookRepository, opts ...service.Option) *service.BookService {
+	revisions, err := repository.NewFileRevisionRepository(getenv("BOOKS_HISTORY_FILE", defaultHistoryFile), encryptLike(repo))
+	if err != nil {
+		log.Fatalf("Failed to open revision history: %v", err)
+	}
+
+
+	keep, err := strconv.Atoi(getenv("BOOKS_SNAPSHOT_KEEP", "0"))
+	if err != nil {
+		log.Fatalf("Invalid BOOKS_SNAPSHOT_KEEP: %v", err)
+	}
+
+	snapshots, err := repository.NewSnapshotManager(repo, getenv("BOOKS_SNAPSHOT_DIR", defaultSnapshotDir), keep)
+	if err != nil {
+		log.Fatalf("Failed to initialize snapshots: %v", err)
+	}
+
+
+	opts = append(opts,
+		service.WithRevisions(revisions),
+		service.WithSnapshots(snapshots),
+	)
+	return service.NewBookService(repo, opts...)
+}
+
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+
+const usage = `usage:
+  api                            start the HTTP server
+  api snapshot create            take a snapshot of the catalog
+  api snapshot list              list snapshots, newest first
+  api snapshot restore <id>      replace the catalog with a snapshot
+  api migrate [-dry-run] [-key-file <keys>] <file>
+                                 upgrade a books file to the current format`
+
+
+// runCommand runs a command-line subcommand and prints its result as JSON.
+func runCommand(args []string) error {
+	var result interface{}
+	var err error
+
+	switch {
+	case args[0] == "migrate":
+		result, err = migrateCommand(args[1:])
+	case args[0] == "snapshot" && len(args) > 1:
+		result, err = snapshotCommand(args[1:])
+	default:
+		return errors.New(usage)
+	}
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(result)
+}
+
+
+// migrateCommand upgrades a books file without opening a repository, which
+// would migrate it as a side effect and defeat -dry-run.
+func migrateCommand(args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would change without writing")
+	keyFile := flags.String("key-file", "", "keyring for an encrypted books file")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != 1 {
+		return nil, errors.New(usage)
+	}
+
+	var opts []repository.Option
+	if *keyFile != "" {
+		keyring, err := repository.LoadKeyringFile(*keyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, repository.WithKeyring(keyring))
+	}
+
+	return repository.MigrateFile(flags.Arg(0), *dryRun, opts...)
+}
+
+
+func snapshotCommand(args []string) (interface{}, error) {
+	svc := newService(openRepository())
+
+	switch {
+	case args[0] == "create" && len(args) == 1:
+		return svc.CreateSnapshot()
+	case args[0] == "list" && len(args) == 1:
+		return svc.ListSnapshots()
+	case args[0] == "restore" && len(args) == 2:
+		return svc.RestoreSnapshot(args[1], "cli")
+	default:
+		return nil, errors.New(usage)
+	}
+}
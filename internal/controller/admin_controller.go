@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/service"
+	"crud-in-go-lang/pkg/utils"
+
+	"github.com/gorilla/mux"
+)
+
+
+// AdminController serves operational endpoints under /admin.
+type AdminController struct {
+	service *service.BookService
+}
+
+
+func NewAdminController(service *service.BookService) *AdminController {
+	return &AdminController{
+		service: service,
+	}
+}
+
+
+func (c *AdminController) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/snapshots", c.ListSnapshots).Methods("GET")
+	router.HandleFunc("/admin/snapshots", c.CreateSnapshot).Methods("POST")
+	router.HandleFunc("/admin/snapshots/{id}/restore", c.RestoreSnapshot).Methods("POST")
+	router.HandleFunc("/admin/cache", c.CacheStats).Methods("GET")
+}
+
+
+func (c *AdminController) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := c.service.ListSnapshots()
+	if err != nil {
+		utils.RespondWithError(w, snapshotStatus(err), fmt.Sprintf("Error listing snapshots: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"snapshots": snapshots,
+	})
+}
+
+
+func (c *AdminController) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	manifest, err := c.service.CreateSnapshot()
+	if err != nil {
+		utils.RespondWithError(w, snapshotStatus(err), fmt.Sprintf("Error creating snapshot: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, manifest)
+}
+
+
+func (c *AdminController) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	manifest, err := c.service.RestoreSnapshot(vars["id"], actor(r))
+	if err != nil {
+		utils.RespondWithError(w, snapshotStatus(err), fmt.Sprintf("Error restoring snapshot: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, manifest)
+}
+
+
+func (c *AdminController) CacheStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := c.service.CacheStats()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotImplemented, err.Error())
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, stats)
+}
+
+
+func snapshotStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrSnapshotsDisabled):
+		return http.StatusNotImplemented
+	case errors.Is(err, repository.ErrSnapshotNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrSnapshotCorrupt):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
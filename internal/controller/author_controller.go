@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"net/http"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+
+type AuthorController struct {
+	recordHandlers[models.Author]
+	service *service.AuthorService
+}
+
+
+func NewAuthorController(service *service.AuthorService) *AuthorController {
+	return &AuthorController{
+		recordHandlers: recordHandlers[models.Author]{kind: "author", plural: "authors", service: service},
+		service:        service,
+	}
+}
+
+
+func (c *AuthorController) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/authors", c.GetAll).Methods("GET")
+	router.HandleFunc("/authors", c.Create).Methods("POST")
+	router.HandleFunc("/authors/{id}/books", booksOf("authorId", c.service.GetBooks)).Methods("GET")
+	router.HandleFunc("/authors/{id}", c.GetByID).Methods("GET")
+	router.HandleFunc("/authors/{id}", c.Update).Methods("PUT")
+	router.HandleFunc("/authors/{id}", c.Delete).Methods("DELETE")
+}
+
+
+func (c *AuthorController) Delete(w http.ResponseWriter, r *http.Request) {
+	_, err := c.service.Delete(mux.Vars(r)["id"], actor(r))
+	c.respondDeleted(w, err)
+}
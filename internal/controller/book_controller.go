@@ -2,11 +2,15 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
 	"crud-in-go-lang/internal/service"
 	"crud-in-go-lang/pkg/utils"
 
@@ -16,20 +20,49 @@ import (
 
 type BookController struct {
 	service *service.BookService
+	stock   *service.StockService
 }
 
 
-func NewBookController(service *service.BookService) *BookController {
-	return &BookController{
+// BookOption configures optional collaborators of a BookController.
+type BookOption func(*BookController)
+
+
+// WithStockLevels adds on-hand, reserved and available counts to
+// GET /books/{id} and lets GET /books filter on locationId.
+func WithStockLevels(stock *service.StockService) BookOption {
+	return func(c *BookController) {
+		c.stock = stock
+	}
+}
+
+
+func NewBookController(service *service.BookService, opts ...BookOption) *BookController {
+	c := &BookController{
 		service: service,
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
 
 func (c *BookController) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/books", c.GetAll).Methods("GET")
 	router.HandleFunc("/books", c.Create).Methods("POST")
+	router.HandleFunc("/books/batch", c.Batch).Methods("POST")
 	router.HandleFunc("/books/search", c.Search).Methods("GET")
+	router.HandleFunc("/books/isbn/{isbn}", c.GetByISBN).Methods("GET")
+	router.HandleFunc("/books/trash", c.GetTrash).Methods("GET")
+	router.HandleFunc("/books/trash", c.PurgeTrash).Methods("DELETE")
+	router.HandleFunc("/books/trash/{id}", c.Purge).Methods("DELETE")
+	router.HandleFunc("/books/{id}/restore", c.Restore).Methods("POST")
+	router.HandleFunc("/books/{id}/history", c.GetHistory).Methods("GET")
+	router.HandleFunc("/books/{id}/history/{rev}", c.GetRevision).Methods("GET")
+	router.HandleFunc("/books/{id}/diff", c.Diff).Methods("GET")
 	router.HandleFunc("/books/{id}", c.GetByID).Methods("GET")
 	router.HandleFunc("/books/{id}", c.Update).Methods("PUT")
 	router.HandleFunc("/books/{id}", c.Delete).Methods("DELETE")
@@ -38,33 +71,66 @@ func (c *BookController) RegisterRoutes(router *mux.Router) {
 
 func (c *BookController) GetAll(w http.ResponseWriter, r *http.Request) {
 
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
+	limit, offset := parsePagination(r)
 
-	limit := 10 
-	offset := 0 
+	filter := models.BookFilter{
+		AuthorID:      r.URL.Query().Get("authorId"),
+		PublisherID:   r.URL.Query().Get("publisherId"),
+		Genre:         r.URL.Query().Get("genre"),
+		ContributorID: r.URL.Query().Get("contributorId"),
+		Role:          r.URL.Query().Get("role"),
+	}
 
-	if limitStr != "" {
-		parsedLimit, err := strconv.Atoi(limitStr)
-		if err == nil && parsedLimit > 0 {
-			limit = parsedLimit
-		}
+	asOf, historical, err := parseAsOf(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
+	if locationID := r.URL.Query().Get("locationId"); locationID != "" {
+		if c.stock == nil || historical {
+			utils.RespondWithError(w, http.StatusBadRequest, "locationId needs stock tracking and cannot be combined with asOf")
+			return
+		}
 
-	if offsetStr != "" {
-		parsedOffset, err := strconv.Atoi(offsetStr)
-		if err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
+		books, count, err := c.stock.BooksAt(locationID, limit, offset, filter)
+		if err != nil {
+			utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error retrieving books: %v", err))
+			return
 		}
+
+		utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"books":       books,
+			"total_count": count,
+			"limit":       limit,
+			"offset":      offset,
+			"locationId":  locationID,
+		})
+		return
 	}
+	if historical {
+		books, count, err := c.service.GetAllAsOf(limit, offset, filter, asOf)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error retrieving books")
+			return
+		}
 
-	books, err := c.service.GetAll(limit, offset)
+		utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"books":       books,
+			"total_count": count,
+			"limit":       limit,
+			"offset":      offset,
+			"asOf":        asOf,
+		})
+		return
+	}
+
+	books, err := c.service.GetAll(limit, offset, filter)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Error retrieving books")
 		return
 	}
 
-	count, err := c.service.Count()
+	count, err := c.service.CountMatching(filter)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Error counting books")
 		return
@@ -85,12 +151,58 @@ func (c *BookController) GetByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	asOf, historical, err := parseAsOf(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if historical {
+		book, err := c.service.GetByIDAsOf(id, asOf)
+		if err != nil {
+			utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Book not found: %v", err))
+			return
+		}
+
+		// No ETag: a past version cannot be used as an If-Match precondition.
+		utils.RespondWithJSON(w, http.StatusOK, book)
+		return
+	}
+
 	book, err := c.service.GetByID(id)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Book not found: %v", err))
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Book not found: %v", err))
 		return
 	}
 
+	setETag(w, book.Version)
+	if c.stock == nil {
+		utils.RespondWithJSON(w, http.StatusOK, book)
+		return
+	}
+
+	levels, err := c.stock.Levels(id)
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error retrieving stock levels: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, struct {
+		*models.Book
+		Stock *models.StockLevels `json:"stock"`
+	}{book, levels})
+}
+
+
+func (c *BookController) GetByISBN(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	book, err := c.service.GetByISBN(vars["isbn"])
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Book not found: %v", err))
+		return
+	}
+
+	setETag(w, book.Version)
 	utils.RespondWithJSON(w, http.StatusOK, book)
 }
 
@@ -104,16 +216,47 @@ func (c *BookController) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	createdBook, err := c.service.Create(book)
+	createdBook, err := c.service.Create(book, actor(r))
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error creating book: %v", err))
+		respondWithError(w, err, http.StatusInternalServerError, fmt.Sprintf("Error creating book: %v", err))
 		return
 	}
 
+	setETag(w, createdBook.Version)
 	utils.RespondWithJSON(w, http.StatusCreated, createdBook)
 }
 
 
+// Batch applies {"operations": [...]} atomically: if any operation fails,
+// none of them is applied.
+func (c *BookController) Batch(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Operations []models.BatchOp `json:"operations"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if len(request.Operations) == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Batch has no operations")
+		return
+	}
+
+	books, err := c.service.ApplyBatch(request.Operations, actor(r))
+	if err != nil {
+		respondWithError(w, err, http.StatusInternalServerError, fmt.Sprintf("Error applying batch: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"books": books,
+	})
+}
+
+
 func (c *BookController) Update(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -126,12 +269,23 @@ func (c *BookController) Update(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	updatedBook, err := c.service.Update(id, book)
+
+	// The version is server-managed; only If-Match can make the write
+	// conditional.
+	expectedVersion, err := parseIfMatch(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	book.Version = expectedVersion
+
+	updatedBook, err := c.service.Update(id, book, actor(r))
 	if err != nil {
-		utils.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Error updating book: %v", err))
+		respondWithError(w, err, http.StatusNotFound, fmt.Sprintf("Error updating book: %v", err))
 		return
 	}
 
+	setETag(w, updatedBook.Version)
 	utils.RespondWithJSON(w, http.StatusOK, updatedBook)
 }
 
@@ -140,8 +294,14 @@ func (c *BookController) Delete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := c.service.Delete(id); err != nil {
-		utils.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Error deleting book: %v", err))
+	expectedVersion, err := parseIfMatch(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+
+	if err := c.service.Delete(id, expectedVersion, actor(r)); err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusNotFound), fmt.Sprintf("Error deleting book: %v", err))
 		return
 	}
 
@@ -162,4 +322,258 @@ func (c *BookController) Search(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Search-Time-Ms", fmt.Sprintf("%d", result.SearchTime))
 	
 	utils.RespondWithJSON(w, http.StatusOK, result)
-}
\ No newline at end of file
+}
+
+
+
+func (c *BookController) GetTrash(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	books, err := c.service.GetTrash(limit, offset)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error retrieving trash")
+		return
+	}
+
+	response := map[string]interface{}{
+		"books":  books,
+		"limit":  limit,
+		"offset": offset,
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+
+func (c *BookController) Restore(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	book, err := c.service.Restore(id, actor(r))
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error restoring book: %v", err))
+		return
+	}
+
+	setETag(w, book.Version)
+	utils.RespondWithJSON(w, http.StatusOK, book)
+}
+
+
+func (c *BookController) Purge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := c.service.Purge(id, actor(r)); err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error purging book: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+
+// PurgeTrash empties the trash, or with ?olderThan=720h only removes books
+// deleted longer ago than the given retention.
+func (c *BookController) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	var retention time.Duration
+	if olderThan := r.URL.Query().Get("olderThan"); olderThan != "" {
+		parsed, err := time.ParseDuration(olderThan)
+		if err != nil || parsed < 0 {
+			utils.RespondWithError(w, http.StatusBadRequest, "olderThan must be a duration such as 720h")
+			return
+		}
+		retention = parsed
+	}
+
+	purged, err := c.service.PurgeTrash(retention, actor(r))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error purging trash: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+func (c *BookController) GetHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	revisions, err := c.service.GetHistory(id)
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error retrieving history: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"bookId":    id,
+		"revisions": revisions,
+	})
+}
+
+
+func (c *BookController) GetRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	rev, err := strconv.Atoi(vars["rev"])
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Revision must be a number")
+		return
+	}
+
+	revision, err := c.service.GetRevision(id, rev)
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error retrieving revision: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, revision)
+}
+
+
+// Diff compares two revisions of a book given as ?from=1&to=3.
+func (c *BookController) Diff(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	from, fromErr := strconv.Atoi(r.URL.Query().Get("from"))
+	to, toErr := strconv.Atoi(r.URL.Query().Get("to"))
+	if fromErr != nil || toErr != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "from and to must be revision numbers")
+		return
+	}
+
+	diff, err := c.service.Diff(id, from, to)
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error comparing revisions: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, diff)
+}
+
+
+// parseAsOf reads the optional ?asOf= RFC 3339 timestamp of a point-in-time
+// read. The boolean reports whether one was given.
+func parseAsOf(r *http.Request) (time.Time, bool, error) {
+	value := r.URL.Query().Get("asOf")
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+
+	asOf, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, errors.New("asOf must be an RFC 3339 timestamp such as 2024-01-31T23:59:59Z")
+	}
+
+	return asOf, true, nil
+}
+
+
+// actor identifies who made a change, as reported by the X-Actor header.
+func actor(r *http.Request) string {
+	if name := strings.TrimSpace(r.Header.Get("X-Actor")); name != "" {
+		return name
+	}
+	return "anonymous"
+}
+
+
+// parsePagination reads limit and offset from the query string, falling
+// back to the first ten results for missing or invalid values.
+func parsePagination(r *http.Request) (int, int) {
+	limitStr := r.URL.Query().Get("limit")
+	offsetStr := r.URL.Query().Get("offset")
+
+	limit := 10
+	offset := 0
+
+	if limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	if offsetStr != "" {
+		parsedOffset, err := strconv.Atoi(offsetStr)
+		if err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	return limit, offset
+}
+
+
+// setETag exposes a book version as a strong entity tag.
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+
+// parseIfMatch returns the version named by the If-Match header, or zero
+// when the header is absent or "*" and the write is unconditional.
+func parseIfMatch(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	tag := strings.TrimPrefix(header, "W/")
+	unquoted, err := strconv.Unquote(tag)
+	if err != nil {
+		return 0, fmt.Errorf("If-Match must be a single entity tag, got %s", header)
+	}
+
+	version, err := strconv.Atoi(unquoted)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("If-Match %s does not name a book version", header)
+	}
+
+	return version, nil
+}
+
+
+// respondWithError reports err with the status statusForError picks. A
+// unique constraint violation also names the book already holding the key.
+func respondWithError(w http.ResponseWriter, err error, fallback int, message string) {
+	var conflict *repository.ConflictError
+	if errors.As(err, &conflict) {
+		utils.RespondWithJSON(w, http.StatusConflict, map[string]string{
+			"error":             message,
+			"constraint":        conflict.Constraint,
+			"conflictingBookId": conflict.BookID,
+		})
+		return
+	}
+
+	utils.RespondWithError(w, statusForError(err, fallback), message)
+}
+
+
+// statusForError maps repository errors to HTTP status codes, falling back
+// to the given status for anything it does not recognize.
+func statusForError(err error, fallback int) int {
+	switch {
+	case errors.Is(err, repository.ErrVersionConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, repository.ErrNotFound), errors.Is(err, repository.ErrRevisionNotFound),
+		errors.Is(err, repository.ErrAuthorNotFound), errors.Is(err, repository.ErrPublisherNotFound),
+		errors.Is(err, repository.ErrLocationNotFound), errors.Is(err, repository.ErrReservationNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrReservationExpired):
+		return http.StatusGone
+	case errors.Is(err, repository.ErrInvalidBatch), errors.Is(err, repository.ErrInvalidRecord):
+		return http.StatusBadRequest
+	case errors.Is(err, repository.ErrInvalidReference):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, repository.ErrStillReferenced), errors.Is(err, repository.ErrInsufficientStock):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrLockTimeout):
+		return http.StatusServiceUnavailable
+	default:
+		return fallback
+	}
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"net/http"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+
+type LocationController struct {
+	recordHandlers[models.Location]
+	service *service.LocationService
+}
+
+
+func NewLocationController(service *service.LocationService) *LocationController {
+	return &LocationController{
+		recordHandlers: recordHandlers[models.Location]{kind: "location", plural: "locations", service: service},
+		service:        service,
+	}
+}
+
+
+func (c *LocationController) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/locations", c.GetAll).Methods("GET")
+	router.HandleFunc("/locations", c.Create).Methods("POST")
+	router.HandleFunc("/locations/{id}", c.GetByID).Methods("GET")
+	router.HandleFunc("/locations/{id}", c.Update).Methods("PUT")
+	router.HandleFunc("/locations/{id}", c.Delete).Methods("DELETE")
+}
+
+
+func (c *LocationController) Delete(w http.ResponseWriter, r *http.Request) {
+	err := c.service.Delete(mux.Vars(r)["id"])
+	c.respondDeleted(w, err)
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"net/http"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+
+type PublisherController struct {
+	recordHandlers[models.Publisher]
+	service *service.PublisherService
+}
+
+
+func NewPublisherController(service *service.PublisherService) *PublisherController {
+	return &PublisherController{
+		recordHandlers: recordHandlers[models.Publisher]{kind: "publisher", plural: "publishers", service: service},
+		service:        service,
+	}
+}
+
+
+func (c *PublisherController) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/publishers", c.GetAll).Methods("GET")
+	router.HandleFunc("/publishers", c.Create).Methods("POST")
+	router.HandleFunc("/publishers/{id}/books", booksOf("publisherId", c.service.GetBooks)).Methods("GET")
+	router.HandleFunc("/publishers/{id}", c.GetByID).Methods("GET")
+	router.HandleFunc("/publishers/{id}", c.Update).Methods("PUT")
+	router.HandleFunc("/publishers/{id}", c.Delete).Methods("DELETE")
+}
+
+
+func (c *PublisherController) Delete(w http.ResponseWriter, r *http.Request) {
+	_, err := c.service.Delete(mux.Vars(r)["id"], actor(r))
+	c.respondDeleted(w, err)
+}
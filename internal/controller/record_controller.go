@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/pkg/utils"
+
+	"github.com/gorilla/mux"
+)
+
+
+// recordService is what recordHandlers need from the services of small
+// entities such as authors.
+type recordService[T any] interface {
+	GetAll(limit, offset int) ([]T, error)
+	GetByID(id string) (*T, error)
+	Count() (int, error)
+	Create(record T) (*T, error)
+	Update(id string, record T) (*T, error)
+}
+
+
+// recordHandlers serve the listing, lookup and write endpoints shared by
+// the controllers of small entities. kind names one record, as in "author",
+// and plural both the records and the key they are listed under.
+type recordHandlers[T any] struct {
+	kind    string
+	plural  string
+	service recordService[T]
+}
+
+
+func (h recordHandlers[T]) GetAll(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	records, err := h.service.GetAll(limit, offset)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error retrieving "+h.plural)
+		return
+	}
+
+	count, err := h.service.Count()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error counting "+h.plural)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		h.plural:      records,
+		"total_count": count,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+
+func (h recordHandlers[T]) GetByID(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	record, err := h.service.GetByID(vars["id"])
+	if err != nil {
+		title := strings.ToUpper(h.kind[:1]) + h.kind[1:]
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("%s not found: %v", title, err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, record)
+}
+
+
+func (h recordHandlers[T]) Create(w http.ResponseWriter, r *http.Request) {
+	var record T
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&record); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	created, err := h.service.Create(record)
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error creating %s: %v", h.kind, err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, created)
+}
+
+
+func (h recordHandlers[T]) Update(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var record T
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&record); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	updated, err := h.service.Update(vars["id"], record)
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error updating %s: %v", h.kind, err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, updated)
+}
+
+
+// respondDeleted answers a delete that ended with err.
+func (h recordHandlers[T]) respondDeleted(w http.ResponseWriter, err error) {
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error deleting %s: %v", h.kind, err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+
+// booksOf returns a handler listing a page of the books of the record
+// named in the path, as found by getBooks, under idKey.
+func booksOf(idKey string, getBooks func(id string, limit, offset int) ([]models.Book, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		limit, offset := parsePagination(r)
+
+		books, count, err := getBooks(vars["id"], limit, offset)
+		if err != nil {
+			utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error retrieving books: %v", err))
+			return
+		}
+
+		utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+			idKey:         vars["id"],
+			"books":       books,
+			"total_count": count,
+			"limit":       limit,
+			"offset":      offset,
+		})
+	}
+}
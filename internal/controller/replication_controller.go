@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"crud-in-go-lang/internal/replication"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/pkg/utils"
+
+	"github.com/gorilla/mux"
+)
+
+
+// ReplicationController serves the change log of a leader, redirects
+// writes and leader-only reads sent to a follower, and reports either on
+// /health. A server with neither is standalone.
+type ReplicationController struct {
+	changes  *repository.ChangeLog
+	follower *replication.Follower
+}
+
+
+func NewReplicationController(changes *repository.ChangeLog, follower *replication.Follower) *ReplicationController {
+	return &ReplicationController{
+		changes:  changes,
+		follower: follower,
+	}
+}
+
+
+func (c *ReplicationController) RegisterRoutes(router *mux.Router) {
+	if c.changes != nil {
+		router.HandleFunc("/replication/changes", c.GetChanges).Methods("GET")
+		router.HandleFunc("/replication/snapshot", c.GetSnapshot).Methods("GET")
+	}
+	router.HandleFunc("/health", c.Health).Methods("GET")
+}
+
+
+func (c *ReplicationController) GetChanges(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if err != nil || since < 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "since must be a non-negative sequence number")
+		return
+	}
+
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			utils.RespondWithError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+	}
+
+	feed, err := c.changes.Changes(since, limit)
+	if errors.Is(err, repository.ErrChangesTruncated) {
+		utils.RespondWithError(w, http.StatusGone, err.Error())
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error reading changes: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, feed)
+}
+
+
+func (c *ReplicationController) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	state, err := c.changes.State()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error reading catalog: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, state)
+}
+
+
+// Health reports the server as up, or degraded while a follower cannot
+// sync, along with its replication status.
+func (c *ReplicationController) Health(w http.ResponseWriter, r *http.Request) {
+	health := map[string]interface{}{
+		"status": "ok",
+	}
+
+	switch {
+	case c.follower != nil:
+		status := c.follower.Status()
+		if status.LastError != "" {
+			health["status"] = "degraded"
+		}
+		health["replication"] = status
+	case c.changes != nil:
+		health["replication"] = replication.LeaderStatus(c.changes)
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, health)
+}
+
+
+// leaderPaths are kept by the leader alone, so followers send every
+// request under them to the leader as well.
+var leaderPaths = []string{"/authors", "/publishers", "/locations", "/reservations", "/admin/snapshots"}
+
+
+// RedirectWrites sends every request that could change the catalog on a
+// follower to the same path on the leader, along with reads of data only
+// the leader has. 307 keeps the method and body.
+func (c *ReplicationController) RedirectWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.follower == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if !leaderOnly(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.Redirect(w, r, c.follower.Leader()+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	})
+}
+
+
+// leaderOnly reports whether r reads something followers do not replicate:
+// authors, publishers, stock, reservations, locations, revision history
+// and snapshots.
+func leaderOnly(r *http.Request) bool {
+	path := r.URL.Path
+	for _, prefix := range leaderPaths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+
+	query := r.URL.Query()
+	if query.Get("asOf") != "" {
+		return true
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 3 && parts[0] == "books" {
+		switch parts[2] {
+		case "stock", "reservations", "history", "diff":
+			return true
+		}
+	}
+
+	return path == "/books" && query.Get("locationId") != ""
+}
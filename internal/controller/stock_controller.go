@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/service"
+	"crud-in-go-lang/pkg/utils"
+
+	"github.com/gorilla/mux"
+)
+
+
+type StockController struct {
+	service *service.StockService
+}
+
+
+func NewStockController(service *service.StockService) *StockController {
+	return &StockController{
+		service: service,
+	}
+}
+
+
+func (c *StockController) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/books/{id}/stock/movements", c.GetMovements).Methods("GET")
+	router.HandleFunc("/books/{id}/stock/movements", c.RecordMovement).Methods("POST")
+	router.HandleFunc("/books/{id}/stock/transfers", c.Transfer).Methods("POST")
+	router.HandleFunc("/books/{id}/reservations", c.GetReservations).Methods("GET")
+	router.HandleFunc("/books/{id}/reservations", c.Reserve).Methods("POST")
+	router.HandleFunc("/reservations/{id}", c.GetReservation).Methods("GET")
+	router.HandleFunc("/reservations/{id}/confirm", c.Confirm).Methods("POST")
+	router.HandleFunc("/reservations/{id}/release", c.Release).Methods("POST")
+}
+
+
+func (c *StockController) GetMovements(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	limit, offset := parsePagination(r)
+
+	movements, count, err := c.service.Movements(vars["id"], limit, offset)
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error retrieving stock movements: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"bookId":      vars["id"],
+		"movements":   movements,
+		"total_count": count,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+
+// RecordMovement takes {"type": "sale", "quantity": 2, "reason": ...,
+// "reference": ...} and answers with the stored movement and the book at
+// its new quantity.
+func (c *StockController) RecordMovement(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var movement models.StockMovement
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&movement); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	recorded, book, err := c.service.Record(vars["id"], movement, actor(r))
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error recording stock movement: %v", err))
+		return
+	}
+
+	setETag(w, book.Version)
+	utils.RespondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"movement": recorded,
+		"book":     book,
+	})
+}
+
+
+// Transfer takes {"from": ..., "to": ..., "quantity": 3, "reference": ...}
+// and answers with the paired transfer-out and transfer-in movements.
+func (c *StockController) Transfer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var transfer models.StockTransfer
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&transfer); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	movements, book, err := c.service.Transfer(vars["id"], transfer, actor(r))
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error transferring stock: %v", err))
+		return
+	}
+
+	setETag(w, book.Version)
+	utils.RespondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"movements": movements,
+		"book":      book,
+	})
+}
+
+
+func (c *StockController) GetReservations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	reservations, err := c.service.Reservations(vars["id"])
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error retrieving reservations: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"bookId":       vars["id"],
+		"reservations": reservations,
+	})
+}
+
+
+// Reserve takes {"quantity": 2, "locationId": ..., "reference": ...} and
+// holds that many copies available at the location until the reservation
+// expires.
+func (c *StockController) Reserve(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var hold models.Reservation
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&hold); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	reservation, err := c.service.Reserve(vars["id"], hold, actor(r))
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error reserving stock: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, reservation)
+}
+
+
+func (c *StockController) GetReservation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	reservation, err := c.service.GetReservation(vars["id"])
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Reservation not found: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, reservation)
+}
+
+
+// Confirm sells the copies a reservation holds and answers with the
+// reservation, the sale and the book at its new quantity.
+func (c *StockController) Confirm(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	reservation, movement, book, err := c.service.Confirm(vars["id"], actor(r))
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error confirming reservation: %v", err))
+		return
+	}
+
+	setETag(w, book.Version)
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"reservation": reservation,
+		"movement":    movement,
+		"book":        book,
+	})
+}
+
+
+func (c *StockController) Release(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	reservation, err := c.service.Release(vars["id"])
+	if err != nil {
+		utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), fmt.Sprintf("Error releasing reservation: %v", err))
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, reservation)
+}
@@ -0,0 +1,13 @@
+package models
+
+
+// Author is referenced by Book.AuthorID. Dates use the same YYYY-MM-DD form
+// as Book.PublicationDate; DeathDate is empty for living authors.
+type Author struct {
+	AuthorID    string `json:"authorId"`
+	Name        string `json:"name"`
+	Bio         string `json:"bio"`
+	BirthDate   string `json:"birthDate"`
+	DeathDate   string `json:"deathDate,omitempty"`
+	Nationality string `json:"nationality"`
+}
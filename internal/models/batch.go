@@ -0,0 +1,12 @@
+package models
+
+
+// BatchOp is one write in a batch that is applied atomically. Action is
+// ActionCreate, ActionUpdate or ActionDelete; a non-zero ExpectedVersion
+// makes an update or delete conditional, the same way If-Match does.
+type BatchOp struct {
+	Action          string `json:"action"`
+	BookID          string `json:"bookId,omitempty"`
+	Book            Book   `json:"book"`
+	ExpectedVersion int    `json:"expectedVersion,omitempty"`
+}
@@ -1,22 +1,49 @@
 package models
 
+import "time"
+
+// Book is an entry in the catalog. AuthorID is the primary author, while
+// Contributors credits everyone who worked on the book, the primary author
+// included or not, in order. DeletedAt is set while the book sits in the
+// trash.
 type Book struct {
-	BookID          string  `json:"bookId"`
-	AuthorID        string  `json:"authorId"`
-	PublisherID     string  `json:"publisherId"`
-	Title           string  `json:"title"`
-	PublicationDate string  `json:"publicationDate"`
-	ISBN            string  `json:"isbn"`
-	Pages           int     `json:"pages"`
-	Genre           string  `json:"genre"`
-	Description     string  `json:"description"`
-	Price           float64 `json:"price"`
-	Quantity        int     `json:"quantity"`
+	BookID          string        `json:"bookId"`
+	AuthorID        string        `json:"authorId"`
+	Contributors    []Contributor `json:"contributors,omitempty"`
+	PublisherID     string        `json:"publisherId"`
+	Title           string        `json:"title"`
+	PublicationDate string        `json:"publicationDate"`
+	ISBN            string        `json:"isbn"`
+	Pages           int           `json:"pages"`
+	Genre           string        `json:"genre"`
+	Description     string        `json:"description"`
+	Price           float64       `json:"price"`
+	Quantity        int           `json:"quantity"`
+	Version         int           `json:"version"`
+	DeletedAt       *time.Time    `json:"deletedAt,omitempty"`
 }
 
 type PaginationParams struct {
 	Limit  int
 	Offset int
+	Filter BookFilter
+}
+
+
+// BookFilter narrows GetAll to books matching every non-empty field.
+// ContributorID and Role match a single contributor together; the primary
+// author counts as a contributor in the author role.
+type BookFilter struct {
+	AuthorID      string
+	PublisherID   string
+	Genre         string
+	ContributorID string
+	Role          string
+}
+
+
+func (f BookFilter) IsEmpty() bool {
+	return f == BookFilter{}
 }
 
 type SearchResult struct {
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+
+// Change is one entry of the replication log: the state a write left a
+// book in. Book is nil once the book has been purged.
+type Change struct {
+	Seq       int64     `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	BookID    string    `json:"bookId"`
+	Book      *Book     `json:"book,omitempty"`
+}
+
+
+// ChangeFeed is a page of the replication log. Epoch changes whenever the
+// log starts over, which makes every sequence number from before invalid.
+type ChangeFeed struct {
+	Epoch   string   `json:"epoch"`
+	LastSeq int64    `json:"lastSeq"`
+	Changes []Change `json:"changes"`
+}
+
+
+// CatalogState is the whole catalog as of Seq, used to seed a replica.
+type CatalogState struct {
+	Epoch string `json:"epoch"`
+	Seq   int64  `json:"seq"`
+	Books []Book `json:"books"`
+}
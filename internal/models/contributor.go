@@ -0,0 +1,22 @@
+package models
+
+
+const (
+	RoleAuthor      = "author"
+	RoleCoAuthor    = "co-author"
+	RoleEditor      = "editor"
+	RoleTranslator  = "translator"
+	RoleIllustrator = "illustrator"
+)
+
+
+// ContributorRoles lists every role a contributor can have.
+var ContributorRoles = []string{RoleAuthor, RoleCoAuthor, RoleEditor, RoleTranslator, RoleIllustrator}
+
+
+// Contributor credits someone, usually an author record, with a role in
+// making a book.
+type Contributor struct {
+	ContributorID string `json:"contributorId"`
+	Role          string `json:"role"`
+}
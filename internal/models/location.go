@@ -0,0 +1,21 @@
+package models
+
+
+const (
+	LocationWarehouse = "warehouse"
+	LocationBranch    = "branch"
+)
+
+
+// LocationTypes lists the kinds of location stock can be held at.
+var LocationTypes = []string{LocationWarehouse, LocationBranch}
+
+
+// Location is a warehouse or shop holding stock. Stock movements name it
+// by LocationID.
+type Location struct {
+	LocationID string `json:"locationId"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Address    string `json:"address,omitempty"`
+}
@@ -0,0 +1,27 @@
+package models
+
+
+// Publisher is referenced by Book.PublisherID.
+type Publisher struct {
+	PublisherID string    `json:"publisherId"`
+	Name        string    `json:"name"`
+	Country     string    `json:"country"`
+	Contact     Contact   `json:"contact"`
+	Imprints    []Imprint `json:"imprints"`
+}
+
+
+type Contact struct {
+	Email   string `json:"email,omitempty"`
+	Phone   string `json:"phone,omitempty"`
+	Website string `json:"website,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+
+// Imprint is a brand a publisher releases books under. ImprintID is unique
+// within its publisher.
+type Imprint struct {
+	ImprintID string `json:"imprintId"`
+	Name      string `json:"name"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+
+const (
+	ReservationActive    = "active"
+	ReservationConfirmed = "confirmed"
+	ReservationReleased  = "released"
+)
+
+
+// Reservation holds copies of a book at a location for a customer until it
+// is confirmed into a sale, released, or expires at ExpiresAt. An empty
+// LocationID holds unassigned stock.
+type Reservation struct {
+	ReservationID string    `json:"reservationId"`
+	BookID        string    `json:"bookId"`
+	Quantity      int       `json:"quantity"`
+	LocationID    string    `json:"locationId,omitempty"`
+	Status        string    `json:"status"`
+	Reference     string    `json:"reference,omitempty"`
+	Actor         string    `json:"actor"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
+
+
+// StockLevels splits the copies of a book on hand into those held by
+// reservations and those still available, and lists where they are.
+type StockLevels struct {
+	OnHand    int             `json:"onHand"`
+	Reserved  int             `json:"reserved"`
+	Available int             `json:"available"`
+	Locations []LocationStock `json:"locations,omitempty"`
+}
+
+
+// LocationStock is the number of copies of a book on hand at a location.
+// An empty LocationID stands for stock not assigned to any location.
+type LocationStock struct {
+	LocationID string `json:"locationId,omitempty"`
+	OnHand     int    `json:"onHand"`
+}
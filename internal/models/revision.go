@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+
+	// ActionStock is recorded when a stock movement changes Quantity.
+	ActionStock = "stock"
+
+	// ActionRestoreSnapshot is recorded for every book changed by restoring
+	// a catalog snapshot.
+	ActionRestoreSnapshot = "restore-snapshot"
+)
+
+
+// Revision is an immutable record of one change to a book. Before is nil
+// for a creation and After is nil once the book has been purged.
+type Revision struct {
+	BookID    string    `json:"bookId"`
+	Rev       int       `json:"rev"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Before    *Book     `json:"before,omitempty"`
+	After     *Book     `json:"after,omitempty"`
+}
+
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+
+// RevisionDiff lists the fields that differ between the book as of two
+// revisions.
+type RevisionDiff struct {
+	BookID  string        `json:"bookId"`
+	FromRev int           `json:"fromRev"`
+	ToRev   int           `json:"toRev"`
+	Changes []FieldChange `json:"changes"`
+}
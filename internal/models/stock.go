@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+
+const (
+	MovementReceipt    = "receipt"
+	MovementSale       = "sale"
+	MovementAdjustment = "adjustment"
+	MovementDamage     = "damage"
+	MovementReturn     = "return"
+
+	// Transfers are recorded as a transfer-out at the source location and
+	// a transfer-in at the destination sharing a TransferID.
+	MovementTransferOut = "transfer-out"
+	MovementTransferIn  = "transfer-in"
+)
+
+
+// StockMovement is one entry of a book's stock ledger. Quantity is the
+// number of copies moved: receipts and returns add them, sales and damage
+// take them away, and an adjustment adds a signed amount. Balance is the
+// stock on hand across all locations after the movement. Movements
+// without a LocationID move unassigned stock.
+type StockMovement struct {
+	BookID     string    `json:"bookId"`
+	Seq        int       `json:"seq"`
+	Timestamp  time.Time `json:"timestamp"`
+	Actor      string    `json:"actor"`
+	Type       string    `json:"type"`
+	Quantity   int       `json:"quantity"`
+	Balance    int       `json:"balance"`
+	LocationID string    `json:"locationId,omitempty"`
+	TransferID string    `json:"transferId,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Reference  string    `json:"reference,omitempty"`
+}
+
+
+// Delta is the signed change the movement makes to the stock on hand at
+// its location.
+func (m StockMovement) Delta() int {
+	switch m.Type {
+	case MovementSale, MovementDamage, MovementTransferOut:
+		return -m.Quantity
+	default:
+		return m.Quantity
+	}
+}
+
+
+// StockTransfer moves copies of a book from one location to another. An
+// empty From or To stands for unassigned stock.
+type StockTransfer struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Quantity  int    `json:"quantity"`
+	Reference string `json:"reference,omitempty"`
+}
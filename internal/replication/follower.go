@@ -0,0 +1,268 @@
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+)
+
+
+// DefaultInterval is how often a Follower polls its leader.
+const DefaultInterval = time.Second
+
+
+var errResync = errors.New("replica must be copied again")
+
+
+// Status describes where a server stands in replication, as reported on
+// /health. LagSeconds is how old the follower's view of the leader is:
+// the time since it last saw the leader with nothing left to apply.
+type Status struct {
+	Role       string  `json:"role"`
+	Leader     string  `json:"leader,omitempty"`
+	Epoch      string  `json:"epoch,omitempty"`
+	AppliedSeq int64   `json:"appliedSeq"`
+	LeaderSeq  int64   `json:"leaderSeq"`
+	Behind     int64   `json:"behind"`
+	LagSeconds float64 `json:"lagSeconds"`
+	LastError  string  `json:"lastError,omitempty"`
+}
+
+
+// Follower keeps a local repository in step with a leader by tailing the
+// leader's change log over HTTP. Reads can be served from the local
+// repository; writes belong on the leader.
+type Follower struct {
+	leader   string
+	local    repository.BookRepository
+	interval time.Duration
+	client   *http.Client
+
+	// syncMutex keeps polls from overlapping; mutex guards the status.
+	syncMutex  sync.Mutex
+	mutex      sync.Mutex
+	epoch      string
+	applied    int64
+	leaderSeq  int64
+	caughtUpAt time.Time
+	lastError  error
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+
+// NewFollower replicates the leader at leaderURL, such as
+// http://books-leader:8080, into local. Nothing happens until Start or
+// Sync is called.
+func NewFollower(leaderURL string, local repository.BookRepository, interval time.Duration) *Follower {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	return &Follower{
+		leader:   strings.TrimRight(leaderURL, "/"),
+		local:    local,
+		interval: interval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		done:     make(chan struct{}),
+	}
+}
+
+
+func (f *Follower) Leader() string {
+	return f.leader
+}
+
+
+// Start polls the leader in the background until Stop is called.
+func (f *Follower) Start() {
+	go func() {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := f.Sync(); err != nil {
+				log.Printf("replication: failed to sync from %s: %v", f.leader, err)
+			}
+
+			select {
+			case <-f.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+
+func (f *Follower) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.done)
+	})
+}
+
+
+// Sync applies every change the leader has made since the last sync. The
+// first sync, and any after the leader restarted or dropped changes this
+// follower still needed, copies the whole catalog instead.
+func (f *Follower) Sync() error {
+	f.syncMutex.Lock()
+	defer f.syncMutex.Unlock()
+
+	err := f.sync()
+
+	f.mutex.Lock()
+	f.lastError = err
+	f.mutex.Unlock()
+
+	return err
+}
+
+
+func (f *Follower) sync() error {
+	f.mutex.Lock()
+	epoch, applied := f.epoch, f.applied
+	f.mutex.Unlock()
+
+	for {
+		started := time.Now()
+
+		var feed models.ChangeFeed
+		err := f.get(fmt.Sprintf("/replication/changes?since=%d", applied), &feed)
+		if epoch == "" || errors.Is(err, errResync) || (err == nil && feed.Epoch != epoch) {
+			if err := f.resync(); err != nil {
+				return err
+			}
+			f.mutex.Lock()
+			epoch, applied = f.epoch, f.applied
+			f.mutex.Unlock()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(feed.Changes) > 0 {
+			if err := f.apply(feed.Changes); err != nil {
+				return err
+			}
+			applied = feed.Changes[len(feed.Changes)-1].Seq
+		}
+
+		f.mutex.Lock()
+		f.applied = applied
+		f.leaderSeq = feed.LastSeq
+		if applied >= feed.LastSeq {
+			f.caughtUpAt = started
+		}
+		f.mutex.Unlock()
+
+		if applied >= feed.LastSeq {
+			return nil
+		}
+	}
+}
+
+
+// resync replaces the local catalog with a copy of the leader's.
+func (f *Follower) resync() error {
+	started := time.Now()
+
+	var state models.CatalogState
+	if err := f.get("/replication/snapshot", &state); err != nil {
+		return err
+	}
+
+	if err := f.local.ReplaceAll(state.Books); err != nil {
+		return fmt.Errorf("failed to copy catalog from leader: %w", err)
+	}
+	log.Printf("replication: copied %d books from %s at %d", len(state.Books), f.leader, state.Seq)
+
+	f.mutex.Lock()
+	f.epoch = state.Epoch
+	f.applied = state.Seq
+	f.leaderSeq = state.Seq
+	f.caughtUpAt = started
+	f.mutex.Unlock()
+
+	return nil
+}
+
+
+// apply writes the state of every changed book in one write, touching
+// only those books where the repository allows.
+func (f *Follower) apply(changes []models.Change) error {
+	if err := repository.ApplyChanges(f.local, changes); err != nil {
+		return fmt.Errorf("failed to apply changes from leader: %w", err)
+	}
+
+	return nil
+}
+
+
+func (f *Follower) get(path string, v interface{}) error {
+	resp, err := f.client.Get(f.leader + path)
+	if err != nil {
+		return fmt.Errorf("failed to reach leader: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return errResync
+	default:
+		return fmt.Errorf("leader answered %s for %s", resp.Status, path)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode leader response: %w", err)
+	}
+
+	return nil
+}
+
+
+func (f *Follower) Status() Status {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	status := Status{
+		Role:       "follower",
+		Leader:     f.leader,
+		Epoch:      f.epoch,
+		AppliedSeq: f.applied,
+		LeaderSeq:  f.leaderSeq,
+		Behind:     f.leaderSeq - f.applied,
+	}
+	if !f.caughtUpAt.IsZero() {
+		status.LagSeconds = time.Since(f.caughtUpAt).Seconds()
+	}
+	if f.lastError != nil {
+		status.LastError = f.lastError.Error()
+	}
+
+	return status
+}
+
+
+// LeaderStatus reports the position of a leader's change log.
+func LeaderStatus(changes *repository.ChangeLog) Status {
+	seq := changes.LastSeq()
+
+	return Status{
+		Role:       "leader",
+		Epoch:      changes.Epoch(),
+		AppliedSeq: seq,
+		LeaderSeq:  seq,
+	}
+}
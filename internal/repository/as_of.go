@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+// AsOfView reads the catalog as it stood at a past moment. Books with
+// recorded history are rebuilt from their revisions; books without any are
+// assumed to have looked then the way they look now.
+type AsOfView struct {
+	books     BookRepository
+	revisions RevisionRepository
+}
+
+
+func NewAsOfView(books BookRepository, revisions RevisionRepository) *AsOfView {
+	return &AsOfView{
+		books:     books,
+		revisions: revisions,
+	}
+}
+
+
+// GetAll returns one page of the live books at t that match params.Filter,
+// together with the number of matching books.
+func (v *AsOfView) GetAll(params models.PaginationParams, t time.Time) ([]models.Book, int, error) {
+	books, err := v.catalogAt(t)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	books = filterBooks(books, params.Filter)
+	return paginate(books, params), len(books), nil
+}
+
+
+func (v *AsOfView) GetByID(id string, t time.Time) (*models.Book, error) {
+	books, err := v.catalogAt(t)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, book := range books {
+		if book.BookID == id {
+			return &book, nil
+		}
+	}
+
+	return nil, errBookNotFound(id)
+}
+
+
+// catalogAt lists the books that were live at t. Current books keep their
+// catalog order, live ones before trashed ones; books that have since been
+// purged follow in the order they were first recorded.
+func (v *AsOfView) catalogAt(t time.Time) ([]models.Book, error) {
+	live, err := v.books.GetAll(models.PaginationParams{Limit: -1})
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := v.books.GetDeleted(models.PaginationParams{Limit: -1})
+	if err != nil {
+		return nil, err
+	}
+	states, err := v.revisions.StatesAt(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var books []models.Book
+	seen := make(map[string]bool, len(live)+len(trashed))
+	for _, book := range append(live, trashed...) {
+		seen[book.BookID] = true
+
+		state, recorded := states[book.BookID]
+		if !recorded {
+			state = &book
+		}
+		if liveAt(state, t) {
+			books = append(books, *state)
+		}
+	}
+
+	purged, err := v.purgedAt(states, seen, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(books, purged...), nil
+}
+
+
+func (v *AsOfView) purgedAt(states map[string]*models.Book, seen map[string]bool, t time.Time) ([]models.Book, error) {
+	var books []models.Book
+	firstSeen := make(map[string]time.Time)
+
+	for bookID, state := range states {
+		if seen[bookID] || !liveAt(state, t) {
+			continue
+		}
+
+		revisions, err := v.revisions.List(bookID)
+		if err != nil {
+			return nil, err
+		}
+		firstSeen[bookID] = revisions[0].Timestamp
+		books = append(books, *state)
+	}
+
+	sort.SliceStable(books, func(i, j int) bool {
+		return firstSeen[books[i].BookID].Before(firstSeen[books[j].BookID])
+	})
+
+	return books, nil
+}
+
+
+func liveAt(book *models.Book, t time.Time) bool {
+	return book != nil && (book.DeletedAt == nil || book.DeletedAt.After(t))
+}
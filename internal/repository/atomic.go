@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+
+const (
+	tempFileInfix = ".tmp-"
+	backupSuffix  = ".bak"
+	corruptInfix  = ".corrupt-"
+)
+
+
+// writeFileAtomic replaces filename with data without ever exposing a
+// partially written file. The data goes to a temp file in the same directory,
+// is fsynced and then renamed over the original. When keepBackup is set the
+// previous generation is preserved as filename.bak before the rename.
+func writeFileAtomic(filename string, data []byte, keepBackup bool) error {
+	dir := filepath.Dir(filename)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+tempFileInfix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+
+	if keepBackup {
+		if err := backupFile(filename); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpName, filename); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filename, err)
+	}
+	committed = true
+
+	syncDir(dir)
+	return nil
+}
+
+
+// backupFile keeps the current contents of filename as filename.bak. A hard
+// link is used when possible so the backup costs no extra I/O.
+func backupFile(filename string) error {
+	backup := filename + backupSuffix
+
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old backup: %w", err)
+	}
+
+	if err := os.Link(filename, backup); err == nil {
+		return nil
+	}
+
+	return copyFile(filename, backup)
+}
+
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to sync %s: %w", dst, err)
+	}
+
+	return out.Close()
+}
+
+
+// syncDir flushes directory metadata so a rename survives a power loss.
+// Not every platform supports fsync on directories, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+
+// leftoverTempFiles lists temp files left behind by an interrupted
+// writeFileAtomic call for filename.
+func leftoverTempFiles(filename string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(filename) + tempFileInfix
+
+	var leftovers []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			leftovers = append(leftovers, filepath.Join(filepath.Dir(filename), entry.Name()))
+		}
+	}
+
+	return leftovers, nil
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"crud-in-go-lang/internal/models"
+)
+
+
+// AuthorRepository stores the authors books refer to by AuthorID.
+type AuthorRepository = RecordRepository[models.Author]
+
+
+// FileAuthorRepository keeps authors in memory and, unless it was
+// created with NewMemoryAuthorRepository, in a JSON file.
+type FileAuthorRepository struct {
+	*recordRepository[models.Author]
+}
+
+
+// NewFileAuthorRepository loads the authors stored in filename.
+func NewFileAuthorRepository(filename string) (*FileAuthorRepository, error) {
+	records, err := openRecordRepository("author", filename, func(author *models.Author) *string {
+		return &author.AuthorID
+	}, errAuthorNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuthorRepository{records}, nil
+}
+
+
+// NewMemoryAuthorRepository returns an author repository that is not
+// persisted.
+func NewMemoryAuthorRepository() *FileAuthorRepository {
+	repo, _ := NewFileAuthorRepository("")
+	return repo
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+// validateBatch rejects a batch containing an operation no repository could
+// apply, before any of it is attempted.
+func validateBatch(ops []models.BatchOp) error {
+	for i, op := range ops {
+		switch op.Action {
+		case models.ActionCreate:
+		case models.ActionUpdate, models.ActionDelete:
+			if op.BookID == "" {
+				return batchError(i, op, fmt.Errorf("%w: bookId is required", ErrInvalidBatch))
+			}
+		default:
+			return batchError(i, op, fmt.Errorf("%w: unknown action %q", ErrInvalidBatch, op.Action))
+		}
+	}
+
+	return nil
+}
+
+
+// batchError identifies the operation that made a batch fail.
+func batchError(i int, op models.BatchOp, err error) error {
+	return fmt.Errorf("batch operation %d (%s %s): %w", i+1, op.Action, op.BookID, err)
+}
+
+
+// apply runs every operation of a batch against the catalog and returns the
+// resulting books in order. It stops at the first failure; the caller is
+// expected to roll back whatever was applied before it.
+func (c *catalog) apply(ops []models.BatchOp, now time.Time) ([]models.Book, error) {
+	if err := validateBatch(ops); err != nil {
+		return nil, err
+	}
+
+	results := make([]models.Book, 0, len(ops))
+	for i, op := range ops {
+		var book models.Book
+		var err error
+
+		switch op.Action {
+		case models.ActionCreate:
+			book, err = c.create(op.Book)
+		case models.ActionUpdate:
+			op.Book.Version = op.ExpectedVersion
+			book, err = c.update(op.BookID, op.Book)
+		case models.ActionDelete:
+			book, err = c.delete(op.BookID, op.ExpectedVersion, now)
+		}
+		if err != nil {
+			return nil, batchError(i, op, err)
+		}
+
+		results = append(results, book)
+	}
+
+	return results, nil
+}
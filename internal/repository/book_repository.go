@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"crud-in-go-lang/internal/models"
 )
 
@@ -11,19 +13,68 @@ type BookRepository interface {
 	
 
 	GetByID(id string) (*models.Book, error)
+
+
+	// GetByISBN finds a live book by ISBN, accepting either the ISBN-10 or
+	// the ISBN-13 form.
+	GetByISBN(isbn string) (*models.Book, error)
 	
 
 	Create(book models.Book) (*models.Book, error)
 	
 
+	// Update replaces a book and bumps its version. A non-zero book.Version
+	// makes the update conditional on the stored version still matching;
+	// otherwise it fails with ErrVersionConflict.
 	Update(id string, book models.Book) (*models.Book, error)
 	
 
-	Delete(id string) error
+	// Delete moves a book to the trash, hiding it from GetAll, GetByID,
+	// Search and Count, and returns the trashed book. A non-zero
+	// expectedVersion makes the delete conditional in the same way as Update.
+	Delete(id string, expectedVersion int) (*models.Book, error)
 	
 
 	Search(query string) ([]models.Book, error)
 	
 
 	Count() (int, error)
+
+
+	// CountMatching counts the live books matching filter without loading
+	// them. An empty filter counts as much as Count.
+	CountMatching(filter models.BookFilter) (int, error)
+
+
+	// GetDeleted lists the books in the trash.
+	GetDeleted(params models.PaginationParams) ([]models.Book, error)
+
+
+	// Restore takes a book back out of the trash.
+	Restore(id string) (*models.Book, error)
+
+
+	// Purge permanently removes a book from the trash.
+	Purge(id string) error
+
+
+	// PurgeDeletedBefore permanently removes every book deleted before
+	// cutoff and reports how many were removed.
+	PurgeDeletedBefore(cutoff time.Time) (int, error)
+
+
+	// Apply performs a batch of creates, updates and deletes as one unit:
+	// either every operation takes effect or none does. It returns the
+	// resulting book of each operation, in order.
+	Apply(ops []models.BatchOp) ([]models.Book, error)
+
+
+	// Snapshot returns every book, trashed ones included, as they all stood
+	// at a single moment.
+	Snapshot() ([]models.Book, error)
+
+
+	// ReplaceAll swaps the whole catalog, trash included, for books in one
+	// atomic step.
+	ReplaceAll(books []models.Book) error
 }
\ No newline at end of file
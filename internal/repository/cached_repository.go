@@ -0,0 +1,438 @@
+package repository
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+const (
+	// DefaultCacheEntries is how many books, and separately how many pages,
+	// a CachedRepository keeps.
+	DefaultCacheEntries = 1000
+
+	// DefaultCacheTTL is how long a cached result is served.
+	DefaultCacheTTL = 30 * time.Second
+)
+
+
+// CacheStats counts how often a CachedRepository could answer from memory.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+
+// changeSource is implemented by repositories that announce changes made
+// behind the repository's back, such as a watched FileRepository.
+type changeSource interface {
+	Subscribe(fn func(ChangeEvent)) func()
+}
+
+
+// decorator is implemented by repositories wrapping another one.
+type decorator interface {
+	unwrap() BookRepository
+}
+
+
+// findChangeSource returns the repository behind repo, through any
+// decorators, that announces its changes, or nil if there is none.
+func findChangeSource(repo BookRepository) changeSource {
+	source, _ := find[changeSource](repo)
+	return source
+}
+
+
+// FindCache returns the CachedRepository behind repo, through any
+// decorators, or nil if there is none.
+func FindCache(repo BookRepository) *CachedRepository {
+	cache, _ := find[*CachedRepository](repo)
+	return cache
+}
+
+
+// find returns the first repository of type T found unwrapping repo.
+func find[T any](repo BookRepository) (T, bool) {
+	for repo != nil {
+		if found, ok := repo.(T); ok {
+			return found, true
+		}
+		wrapper, ok := repo.(decorator)
+		if !ok {
+			break
+		}
+		repo = wrapper.unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
+
+
+// CachedRepository answers GetByID, GetAll and the counts from memory when
+// it can and passes everything else through. Every write empties the
+// cached pages and counts and drops the books it touched, so the cache is
+// only ever stale for changes made without going through it, and then for
+// at most the TTL.
+type CachedRepository struct {
+	BookRepository
+
+	mutex      sync.Mutex
+	books      *lruCache[string, models.Book]
+	pages      *lruCache[models.PaginationParams, []models.Book]
+	counts     *lruCache[models.BookFilter, int]
+	generation uint64
+	stats      CacheStats
+}
+
+
+// NewCachedRepository caches up to maxEntries books and maxEntries pages of
+// repo for ttl. Zero or less selects the defaults.
+func NewCachedRepository(repo BookRepository, maxEntries int, ttl time.Duration) *CachedRepository {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	c := &CachedRepository{
+		BookRepository: repo,
+		books:          newLRUCache[string, models.Book](maxEntries, ttl),
+		pages:          newLRUCache[models.PaginationParams, []models.Book](maxEntries, ttl),
+		counts:         newLRUCache[models.BookFilter, int](maxEntries, ttl),
+	}
+
+	if source := findChangeSource(repo); source != nil {
+		source.Subscribe(func(event ChangeEvent) {
+			c.invalidate(event.BookID)
+		})
+	}
+
+	return c
+}
+
+
+// Stats returns the hits, misses and evictions so far and how many
+// results are cached now.
+func (c *CachedRepository) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.books.len() + c.pages.len() + c.counts.len()
+	return stats
+}
+
+
+func (c *CachedRepository) GetAll(params models.PaginationParams) ([]models.Book, error) {
+	if books, ok := lookup(c, c.pages, params); ok {
+		return cloneBooks(books), nil
+	}
+	generation := c.currentGeneration()
+
+	books, err := c.BookRepository.GetAll(params)
+	if err != nil {
+		return nil, err
+	}
+
+	store(c, c.pages, params, cloneBooks(books), generation)
+	return books, nil
+}
+
+
+func (c *CachedRepository) GetByID(id string) (*models.Book, error) {
+	if book, ok := lookup(c, c.books, id); ok {
+		return cloneBook(&book), nil
+	}
+	generation := c.currentGeneration()
+
+	book, err := c.BookRepository.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	store(c, c.books, id, *cloneBook(book), generation)
+	return book, nil
+}
+
+
+func (c *CachedRepository) Count() (int, error) {
+	return c.count(models.BookFilter{}, c.BookRepository.Count)
+}
+
+
+func (c *CachedRepository) CountMatching(filter models.BookFilter) (int, error) {
+	return c.count(filter, func() (int, error) {
+		return c.BookRepository.CountMatching(filter)
+	})
+}
+
+
+func (c *CachedRepository) count(filter models.BookFilter, read func() (int, error)) (int, error) {
+	if count, ok := lookup(c, c.counts, filter); ok {
+		return count, nil
+	}
+	generation := c.currentGeneration()
+
+	count, err := read()
+	if err != nil {
+		return 0, err
+	}
+
+	store(c, c.counts, filter, count, generation)
+	return count, nil
+}
+
+
+func (c *CachedRepository) Create(book models.Book) (*models.Book, error) {
+	defer c.invalidate()
+	return c.BookRepository.Create(book)
+}
+
+
+func (c *CachedRepository) Update(id string, book models.Book) (*models.Book, error) {
+	defer c.invalidate(id)
+	return c.BookRepository.Update(id, book)
+}
+
+
+func (c *CachedRepository) Delete(id string, expectedVersion int) (*models.Book, error) {
+	defer c.invalidate(id)
+	return c.BookRepository.Delete(id, expectedVersion)
+}
+
+
+func (c *CachedRepository) Restore(id string) (*models.Book, error) {
+	defer c.invalidate(id)
+	return c.BookRepository.Restore(id)
+}
+
+
+func (c *CachedRepository) Purge(id string) error {
+	defer c.invalidate(id)
+	return c.BookRepository.Purge(id)
+}
+
+
+// PurgeDeletedBefore only removes trashed books, which GetByID never
+// caches.
+func (c *CachedRepository) PurgeDeletedBefore(cutoff time.Time) (int, error) {
+	defer c.invalidate()
+	return c.BookRepository.PurgeDeletedBefore(cutoff)
+}
+
+
+func (c *CachedRepository) Apply(ops []models.BatchOp) ([]models.Book, error) {
+	ids := make([]string, 0, len(ops))
+	for _, op := range ops {
+		ids = append(ids, op.BookID)
+	}
+	defer c.invalidate(ids...)
+
+	return c.BookRepository.Apply(ops)
+}
+
+
+func (c *CachedRepository) ReplaceAll(books []models.Book) error {
+	defer c.invalidateAll()
+	return c.BookRepository.ReplaceAll(books)
+}
+
+
+func (c *CachedRepository) ApplyChanges(changes []models.Change) error {
+	ids := make([]string, 0, len(changes))
+	for _, change := range changes {
+		ids = append(ids, change.BookID)
+	}
+	defer c.invalidate(ids...)
+
+	return ApplyChanges(c.BookRepository, changes)
+}
+
+
+// unwrap returns the cached repository.
+func (c *CachedRepository) unwrap() BookRepository {
+	return c.BookRepository
+}
+
+
+// encryptionKeyring lets a SnapshotManager see through the cache to an
+// encrypted repository.
+func (c *CachedRepository) encryptionKeyring() *Keyring {
+	if source, ok := c.BookRepository.(encrypted); ok {
+		return source.encryptionKeyring()
+	}
+	return nil
+}
+
+
+// invalidate drops the cached pages and counts, which any write may change,
+// and the given books. Bumping the generation keeps reads that started
+// before the write from caching what they found.
+func (c *CachedRepository) invalidate(ids ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.pages.clear()
+	c.counts.clear()
+	for _, id := range ids {
+		c.books.remove(id)
+	}
+}
+
+
+func (c *CachedRepository) invalidateAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.pages.clear()
+	c.counts.clear()
+	c.books.clear()
+}
+
+
+func (c *CachedRepository) currentGeneration() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.generation
+}
+
+
+// cloneBooks copies books with cloneBook, keeping a nil slice nil.
+func cloneBooks(books []models.Book) []models.Book {
+	if books == nil {
+		return nil
+	}
+
+	clones := make([]models.Book, len(books))
+	for i := range books {
+		clones[i] = *cloneBook(&books[i])
+	}
+	return clones
+}
+
+
+func lookup[K comparable, V any](c *CachedRepository, cache *lruCache[K, V], key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	value, ok := cache.get(key, time.Now())
+	if ok {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	return value, ok
+}
+
+
+// store caches value unless a write has happened since generation was read.
+func store[K comparable, V any](c *CachedRepository, cache *lruCache[K, V], key K, value V, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if cache.put(key, value, time.Now()) {
+		c.stats.Evictions++
+	}
+}
+
+
+// lruCache holds up to max entries for ttl each, evicting the least
+// recently used first. It is not safe for concurrent use.
+type lruCache[K comparable, V any] struct {
+	max   int
+	ttl   time.Duration
+	items map[K]*list.Element
+	order *list.List
+}
+
+
+type lruEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+
+func newLRUCache[K comparable, V any](max int, ttl time.Duration) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		max:   max,
+		ttl:   ttl,
+		items: make(map[K]*list.Element),
+		order: list.New(),
+	}
+}
+
+
+func (l *lruCache[K, V]) get(key K, now time.Time) (V, bool) {
+	var zero V
+
+	element, ok := l.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := element.Value.(*lruEntry[K, V])
+	if now.After(entry.expires) {
+		l.remove(key)
+		return zero, false
+	}
+
+	l.order.MoveToFront(element)
+	return entry.value, true
+}
+
+
+// put stores value under key and reports whether another entry had to be
+// evicted to make room.
+func (l *lruCache[K, V]) put(key K, value V, now time.Time) bool {
+	entry := &lruEntry[K, V]{key: key, value: value, expires: now.Add(l.ttl)}
+
+	if element, ok := l.items[key]; ok {
+		element.Value = entry
+		l.order.MoveToFront(element)
+		return false
+	}
+
+	l.items[key] = l.order.PushFront(entry)
+	if l.order.Len() <= l.max {
+		return false
+	}
+
+	oldest := l.order.Back()
+	l.order.Remove(oldest)
+	delete(l.items, oldest.Value.(*lruEntry[K, V]).key)
+	return true
+}
+
+
+func (l *lruCache[K, V]) remove(key K) {
+	if element, ok := l.items[key]; ok {
+		l.order.Remove(element)
+		delete(l.items, key)
+	}
+}
+
+
+func (l *lruCache[K, V]) clear() {
+	l.items = make(map[K]*list.Element)
+	l.order.Init()
+}
+
+
+func (l *lruCache[K, V]) len() int {
+	return len(l.items)
+}
@@ -0,0 +1,515 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+
+	"github.com/google/uuid"
+)
+
+
+// catalog is an ordered set of books indexed by BookID. It holds the
+// mutation rules shared by every repository that materializes the whole
+// catalog in memory, so those rules only live in one place. Soft-deleted
+// books stay in the catalog, hidden from everything but the trash methods.
+type catalog struct {
+	books   []models.Book
+	index   map[string]int
+	deleted int
+	undo    map[string]*models.Book
+	unique  *uniqueIndex
+}
+
+
+func newCatalog(books []models.Book, constraints []UniqueConstraint) *catalog {
+	c := &catalog{
+		books:  books,
+		unique: newUniqueIndex(constraints, nil),
+	}
+	c.reindex()
+	return c
+}
+
+
+func (c *catalog) reindex() {
+	c.index = make(map[string]int, len(c.books))
+	c.unique = newUniqueIndex(c.unique.constraints, c.books)
+	c.deleted = 0
+	for i, book := range c.books {
+		c.index[book.BookID] = i
+		if book.DeletedAt != nil {
+			c.deleted++
+		}
+	}
+}
+
+
+// get looks a book up by ID whether or not it is in the trash.
+func (c *catalog) get(id string) (models.Book, bool) {
+	i, ok := c.index[id]
+	if !ok {
+		return models.Book{}, false
+	}
+	return c.books[i], true
+}
+
+
+// getLive looks up a book that has not been deleted.
+func (c *catalog) getLive(id string) (models.Book, bool) {
+	book, ok := c.get(id)
+	if !ok || book.DeletedAt != nil {
+		return models.Book{}, false
+	}
+	return book, true
+}
+
+
+// list returns every book, including those in the trash.
+func (c *catalog) list() []models.Book {
+	books := make([]models.Book, len(c.books))
+	copy(books, c.books)
+	return books
+}
+
+
+func (c *catalog) live() []models.Book {
+	books := make([]models.Book, 0, len(c.books)-c.deleted)
+	for _, book := range c.books {
+		if book.DeletedAt == nil {
+			books = append(books, book)
+		}
+	}
+	return books
+}
+
+
+func (c *catalog) trashed() []models.Book {
+	books := make([]models.Book, 0, c.deleted)
+	for _, book := range c.books {
+		if book.DeletedAt != nil {
+			books = append(books, book)
+		}
+	}
+	return books
+}
+
+
+// page returns the window of live books selected by params.
+func (c *catalog) page(params models.PaginationParams) []models.Book {
+	return paginate(filterBooks(c.live(), params.Filter), params)
+}
+
+
+func (c *catalog) search(query string) []models.Book {
+	return searchBooks(c.live(), query)
+}
+
+
+func (c *catalog) count() int {
+	return len(c.books) - c.deleted
+}
+
+
+func (c *catalog) countMatching(filter models.BookFilter) int {
+	if filter.IsEmpty() {
+		return c.count()
+	}
+	return len(filterBooks(c.live(), filter))
+}
+
+
+// getByISBN finds the live book with the given ISBN in either form. It is
+// answered from the unique index when ISBNs are constrained.
+func (c *catalog) getByISBN(isbn string) (models.Book, bool) {
+	key := NormalizeISBN(isbn)
+	if key == "" {
+		return models.Book{}, false
+	}
+
+	if owner, found, indexed := c.unique.lookup(UniqueISBN.Name, key); indexed {
+		if !found {
+			return models.Book{}, false
+		}
+		return c.getLive(owner)
+	}
+
+	for _, book := range c.books {
+		if book.DeletedAt == nil && NormalizeISBN(book.ISBN) == key {
+			return book, true
+		}
+	}
+	return models.Book{}, false
+}
+
+
+func (c *catalog) create(book models.Book) (models.Book, error) {
+	// Generate a UUID if not provided
+	if book.BookID == "" {
+		book.BookID = uuid.New().String()
+	}
+
+	if _, exists := c.index[book.BookID]; exists {
+		return models.Book{}, fmt.Errorf("book with ID %s already exists", book.BookID)
+	}
+	if err := c.unique.check(book, nil); err != nil {
+		return models.Book{}, err
+	}
+
+	book.Version = 1
+	book.DeletedAt = nil
+	c.put(book)
+	return book, nil
+}
+
+
+// update replaces the book with the given ID. A non-zero book.Version is
+// the version the caller last saw; the update is refused if the stored book
+// has moved on since.
+func (c *catalog) update(id string, book models.Book) (models.Book, error) {
+	existing, ok := c.getLive(id)
+	if !ok {
+		return models.Book{}, errBookNotFound(id)
+	}
+
+	if err := checkVersion(existing, book.Version); err != nil {
+		return models.Book{}, err
+	}
+
+	book.BookID = id
+	if err := c.unique.check(book, &existing); err != nil {
+		return models.Book{}, err
+	}
+
+	book.Version = existing.Version + 1
+	book.DeletedAt = nil
+	c.put(book)
+	return book, nil
+}
+
+
+// delete moves a book to the trash, stamping it with the deletion time.
+func (c *catalog) delete(id string, expectedVersion int, now time.Time) (models.Book, error) {
+	book, ok := c.getLive(id)
+	if !ok {
+		return models.Book{}, errBookNotFound(id)
+	}
+
+	if err := checkVersion(book, expectedVersion); err != nil {
+		return models.Book{}, err
+	}
+
+	deletedAt := now.UTC()
+	book.DeletedAt = &deletedAt
+	book.Version++
+	c.put(book)
+	return book, nil
+}
+
+
+// restore takes a book back out of the trash.
+func (c *catalog) restore(id string) (models.Book, error) {
+	book, ok := c.get(id)
+	if !ok || book.DeletedAt == nil {
+		return models.Book{}, errTrashedBookNotFound(id)
+	}
+
+	book.DeletedAt = nil
+	book.Version++
+	c.put(book)
+	return book, nil
+}
+
+
+// purge permanently removes a book from the trash.
+func (c *catalog) purge(id string) error {
+	book, ok := c.get(id)
+	if !ok || book.DeletedAt == nil {
+		return errTrashedBookNotFound(id)
+	}
+
+	c.remove(id)
+	return nil
+}
+
+
+// purgeBefore permanently removes every book deleted before cutoff and
+// returns their IDs.
+func (c *catalog) purgeBefore(cutoff time.Time) []string {
+	var purged []string
+	kept := c.books[:0]
+	for _, book := range c.books {
+		if book.DeletedAt != nil && book.DeletedAt.Before(cutoff) {
+			c.touch(book.BookID)
+			purged = append(purged, book.BookID)
+			continue
+		}
+		kept = append(kept, book)
+	}
+
+	if len(purged) > 0 {
+		c.books = kept
+		c.reindex()
+	}
+
+	return purged
+}
+
+
+// replace swaps every book for books, in their order.
+func (c *catalog) replace(books []models.Book) {
+	for _, book := range c.books {
+		c.touch(book.BookID)
+	}
+	for _, book := range books {
+		c.touch(book.BookID)
+	}
+
+	c.books = append([]models.Book(nil), books...)
+	c.reindex()
+}
+
+
+// begin starts recording the original state of every book touched, so a
+// change that cannot be persisted can be rolled back.
+func (c *catalog) begin() {
+	c.undo = make(map[string]*models.Book)
+}
+
+
+// commit ends the change started by begin and returns the original state of
+// each touched book, nil for books that did not exist before.
+func (c *catalog) commit() map[string]*models.Book {
+	touched := c.undo
+	c.undo = nil
+	return touched
+}
+
+
+// rollback reverts every book touched since begin.
+func (c *catalog) rollback() {
+	touched := c.commit()
+	for id, original := range touched {
+		if original == nil {
+			c.remove(id)
+		} else {
+			c.put(*original)
+		}
+	}
+}
+
+
+func (c *catalog) touch(id string) {
+	if c.undo == nil {
+		return
+	}
+	if _, seen := c.undo[id]; seen {
+		return
+	}
+
+	if book, ok := c.get(id); ok {
+		c.undo[id] = &book
+	} else {
+		c.undo[id] = nil
+	}
+}
+
+
+// put stores book as-is, replacing any book with the same ID in place.
+func (c *catalog) put(book models.Book) {
+	c.touch(book.BookID)
+
+	if book.DeletedAt != nil {
+		c.deleted++
+	}
+
+	if i, ok := c.index[book.BookID]; ok {
+		if c.books[i].DeletedAt != nil {
+			c.deleted--
+		}
+		c.unique.remove(c.books[i])
+		c.unique.add(book)
+		c.books[i] = book
+		return
+	}
+
+	c.index[book.BookID] = len(c.books)
+	c.unique.add(book)
+	c.books = append(c.books, book)
+}
+
+
+// applyChanges stores the book state of every change, in order, and
+// removes the books of changes without one.
+func (c *catalog) applyChanges(changes []models.Change) {
+	for _, change := range changes {
+		if change.Book == nil {
+			c.remove(change.BookID)
+		} else {
+			c.put(*change.Book)
+		}
+	}
+}
+
+
+// remove drops the book with the given ID, if present.
+func (c *catalog) remove(id string) {
+	i, ok := c.index[id]
+	if !ok {
+		return
+	}
+	c.touch(id)
+
+	c.books = append(c.books[:i], c.books[i+1:]...)
+	c.reindex()
+}
+
+
+// checkVersion enforces an optimistic concurrency precondition. Zero means
+// the caller did not ask for one.
+func checkVersion(existing models.Book, expected int) error {
+	if expected != 0 && expected != existing.Version {
+		return errVersionConflict(existing.BookID, expected, existing.Version)
+	}
+	return nil
+}
+
+
+// paginate returns the window of books selected by params. A negative
+// Limit selects everything from Offset on.
+func paginate(books []models.Book, params models.PaginationParams) []models.Book {
+	start := params.Offset
+	if start < 0 {
+		start = 0
+	}
+
+	if start >= len(books) {
+		return []models.Book{}
+	}
+
+	end := len(books)
+	if params.Limit >= 0 && params.Limit < end-start {
+		end = start + params.Limit
+	}
+
+	return books[start:end]
+}
+
+
+// filterBooks keeps the books matching filter. Genre is compared without
+// regard to case.
+func filterBooks(books []models.Book, filter models.BookFilter) []models.Book {
+	if filter.IsEmpty() {
+		return books
+	}
+
+	var matches []models.Book
+	for _, book := range books {
+		if MatchesFilter(book, filter) {
+			matches = append(matches, book)
+		}
+	}
+
+	return matches
+}
+
+
+// MatchesFilter reports whether book matches filter the way GetAll
+// filters, for callers holding books they did not list.
+func MatchesFilter(book models.Book, filter models.BookFilter) bool {
+	if filter.AuthorID != "" && book.AuthorID != filter.AuthorID {
+		return false
+	}
+	if filter.PublisherID != "" && book.PublisherID != filter.PublisherID {
+		return false
+	}
+	if filter.Genre != "" && !strings.EqualFold(book.Genre, filter.Genre) {
+		return false
+	}
+	if (filter.ContributorID != "" || filter.Role != "") && !hasContributor(book, filter.ContributorID, filter.Role) {
+		return false
+	}
+
+	return true
+}
+
+
+// hasContributor reports whether book credits id, in role if one is given.
+// An empty id matches anyone in role.
+func hasContributor(book models.Book, id, role string) bool {
+	matches := func(contributorID, contributorRole string) bool {
+		return contributorID != "" && (id == "" || contributorID == id) && (role == "" || strings.EqualFold(contributorRole, role))
+	}
+
+	if matches(book.AuthorID, models.RoleAuthor) {
+		return true
+	}
+	for _, contributor := range book.Contributors {
+		if matches(contributor.ContributorID, contributor.Role) {
+			return true
+		}
+	}
+	return false
+}
+
+
+// searchBooks matches query case-insensitively against titles and
+// descriptions, scanning both fields concurrently.
+func searchBooks(books []models.Book, query string) []models.Book {
+	if query == "" {
+		return books
+	}
+
+	query = strings.ToLower(query)
+
+
+	titleChan := make(chan []models.Book)
+	descChan := make(chan []models.Book)
+
+
+	go func() {
+		var results []models.Book
+		for _, book := range books {
+			if strings.Contains(strings.ToLower(book.Title), query) {
+				results = append(results, book)
+			}
+		}
+		titleChan <- results
+	}()
+
+
+	go func() {
+		var results []models.Book
+		for _, book := range books {
+			if strings.Contains(strings.ToLower(book.Description), query) {
+				results = append(results, book)
+			}
+		}
+		descChan <- results
+	}()
+
+
+	titleResults := <-titleChan
+	descResults := <-descChan
+
+
+	uniqueBooks := make(map[string]models.Book)
+
+	for _, book := range titleResults {
+		uniqueBooks[book.BookID] = book
+	}
+
+	for _, book := range descResults {
+		uniqueBooks[book.BookID] = book
+	}
+
+
+	var result []models.Book
+	for _, book := range uniqueBooks {
+		result = append(result, book)
+	}
+
+	return result
+}
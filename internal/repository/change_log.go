@@ -0,0 +1,378 @@
+package repository
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+
+	"github.com/google/uuid"
+)
+
+
+// DefaultChangeLogSize is the number of changes a ChangeLog keeps at least.
+const DefaultChangeLogSize = 10000
+
+
+// DefaultChangePage is the number of changes Changes returns by default.
+const DefaultChangePage = 500
+
+
+// ChangeLog wraps a repository and numbers every change written through it,
+// so replicas can follow the catalog by asking for the changes after the
+// last one they applied. The log lives in memory: it starts over under a
+// new epoch when the process restarts and only the newest changes are
+// kept, so a replica that falls too far behind has to copy the catalog
+// again.
+type ChangeLog struct {
+	BookRepository
+
+	epoch      string
+	size       int
+	mutex      sync.RWMutex
+	changes    []models.Change
+	seq        int64
+	subscribed bool
+}
+
+
+// NewChangeLog logs the changes written through it. When repo announces
+// its changes, as a watched FileRepository does, the log is fed from those
+// announcements instead, so edits made by hand or by another process are
+// replicated as well.
+func NewChangeLog(repo BookRepository, size int) *ChangeLog {
+	if size <= 0 {
+		size = DefaultChangeLogSize
+	}
+
+	l := &ChangeLog{
+		BookRepository: repo,
+		epoch:          uuid.New().String(),
+		size:           size,
+	}
+
+	if source := findChangeSource(repo); source != nil {
+		l.subscribed = true
+		source.Subscribe(func(event ChangeEvent) {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			l.append(event.BookID, event.After)
+		})
+	}
+
+	return l
+}
+
+
+// Changes returns up to limit changes made after since.
+func (l *ChangeLog) Changes(since int64, limit int) (*models.ChangeFeed, error) {
+	if limit <= 0 {
+		limit = DefaultChangePage
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	first := l.seq + 1
+	if len(l.changes) > 0 {
+		first = l.changes[0].Seq
+	}
+	if since < first-1 {
+		return nil, fmt.Errorf("%w: asked for changes after %d, oldest kept is %d", ErrChangesTruncated, since, first)
+	}
+
+	start := int(since - first + 1)
+	if start > len(l.changes) {
+		start = len(l.changes)
+	}
+	end := start + limit
+	if end > len(l.changes) {
+		end = len(l.changes)
+	}
+
+	return &models.ChangeFeed{
+		Epoch:   l.epoch,
+		LastSeq: l.seq,
+		Changes: append([]models.Change{}, l.changes[start:end]...),
+	}, nil
+}
+
+
+// State returns the whole catalog together with the sequence number of a
+// change it includes. The catalog may be newer than that change; since
+// changes carry whole books, replaying the ones after it is harmless.
+func (l *ChangeLog) State() (*models.CatalogState, error) {
+	seq := l.LastSeq()
+
+	books, err := l.BookRepository.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CatalogState{
+		Epoch: l.epoch,
+		Seq:   seq,
+		Books: books,
+	}, nil
+}
+
+
+func (l *ChangeLog) Epoch() string {
+	return l.epoch
+}
+
+
+func (l *ChangeLog) LastSeq() int64 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.seq
+}
+
+
+func (l *ChangeLog) Create(book models.Book) (*models.Book, error) {
+	defer l.lock()()
+
+	created, err := l.BookRepository.Create(book)
+	if err != nil {
+		return nil, err
+	}
+
+	l.record(created.BookID, created)
+	return created, nil
+}
+
+
+func (l *ChangeLog) Update(id string, book models.Book) (*models.Book, error) {
+	defer l.lock()()
+
+	updated, err := l.BookRepository.Update(id, book)
+	if err != nil {
+		return nil, err
+	}
+
+	l.record(updated.BookID, updated)
+	return updated, nil
+}
+
+
+func (l *ChangeLog) Delete(id string, expectedVersion int) (*models.Book, error) {
+	defer l.lock()()
+
+	deleted, err := l.BookRepository.Delete(id, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	l.record(deleted.BookID, deleted)
+	return deleted, nil
+}
+
+
+func (l *ChangeLog) Restore(id string) (*models.Book, error) {
+	defer l.lock()()
+
+	restored, err := l.BookRepository.Restore(id)
+	if err != nil {
+		return nil, err
+	}
+
+	l.record(restored.BookID, restored)
+	return restored, nil
+}
+
+
+func (l *ChangeLog) Purge(id string) error {
+	defer l.lock()()
+
+	if err := l.BookRepository.Purge(id); err != nil {
+		return err
+	}
+
+	l.record(id, nil)
+	return nil
+}
+
+
+func (l *ChangeLog) PurgeDeletedBefore(cutoff time.Time) (int, error) {
+	if l.subscribed {
+		return l.BookRepository.PurgeDeletedBefore(cutoff)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	trash, err := l.BookRepository.GetDeleted(models.PaginationParams{Limit: -1})
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := l.BookRepository.PurgeDeletedBefore(cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, book := range trash {
+		if book.DeletedAt.Before(cutoff) {
+			l.append(book.BookID, nil)
+		}
+	}
+
+	return purged, nil
+}
+
+
+func (l *ChangeLog) Apply(ops []models.BatchOp) ([]models.Book, error) {
+	defer l.lock()()
+
+	results, err := l.BookRepository.Apply(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		book := results[i]
+		l.record(book.BookID, &book)
+	}
+
+	return results, nil
+}
+
+
+// ReplaceAll logs one change for every book the replacement added, changed
+// or removed.
+func (l *ChangeLog) ReplaceAll(books []models.Book) error {
+	if l.subscribed {
+		return l.BookRepository.ReplaceAll(books)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	before, err := l.BookRepository.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	if err := l.BookRepository.ReplaceAll(books); err != nil {
+		return err
+	}
+
+	after, err := l.BookRepository.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	for _, event := range diffBooks(before, after) {
+		l.append(event.BookID, event.After)
+	}
+
+	return nil
+}
+
+
+// ApplyChanges logs one change for every book the replicated changes
+// touched.
+func (l *ChangeLog) ApplyChanges(changes []models.Change) error {
+	defer l.lock()()
+
+	if err := ApplyChanges(l.BookRepository, changes); err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		l.record(change.BookID, change.Book)
+	}
+	return nil
+}
+
+
+// encryptionKeyring lets a SnapshotManager see through the log to an
+// encrypted repository.
+func (l *ChangeLog) encryptionKeyring() *Keyring {
+	if source, ok := l.BookRepository.(encrypted); ok {
+		return source.encryptionKeyring()
+	}
+	return nil
+}
+
+
+// unwrap returns the repository the log writes to.
+func (l *ChangeLog) unwrap() BookRepository {
+	return l.BookRepository
+}
+
+
+// lock takes the write lock around a write the log records itself and
+// returns a function releasing it. A subscribed log takes no lock, since
+// the repository announces the write before returning.
+func (l *ChangeLog) lock() func() {
+	if l.subscribed {
+		return func() {}
+	}
+
+	l.mutex.Lock()
+	return l.mutex.Unlock
+}
+
+
+// record appends a change made through the log, unless the repository
+// announces it anyway.
+func (l *ChangeLog) record(bookID string, book *models.Book) {
+	if !l.subscribed {
+		l.append(bookID, book)
+	}
+}
+
+
+// append records a change; the caller holds the write lock. Once twice
+// size changes have piled up the oldest are dropped, so trimming costs
+// one copy per size writes.
+func (l *ChangeLog) append(bookID string, book *models.Book) {
+	l.seq++
+
+	var state *models.Book
+	if book != nil {
+		copied := *book
+		state = &copied
+	}
+
+	l.changes = append(l.changes, models.Change{
+		Seq:       l.seq,
+		Timestamp: time.Now().UTC(),
+		BookID:    bookID,
+		Book:      state,
+	})
+
+	if len(l.changes) > 2*l.size {
+		l.changes = append([]models.Change(nil), l.changes[len(l.changes)-l.size:]...)
+	}
+}
+
+
+// changeApplier is implemented by repositories that can store replicated
+// changes as they are, without the version bumps and timestamps of their
+// own writes.
+type changeApplier interface {
+	ApplyChanges(changes []models.Change) error
+}
+
+
+// ApplyChanges brings repo to the book states of changes, in order, as one
+// write. A change without a book removes it. Repositories without a way of
+// their own have their whole catalog replaced.
+func ApplyChanges(repo BookRepository, changes []models.Change) error {
+	if applier, ok := repo.(changeApplier); ok {
+		return applier.ApplyChanges(changes)
+	}
+
+	books, err := repo.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	c := newCatalog(books, nil)
+	c.applyChanges(changes)
+	return repo.ReplaceAll(c.list())
+}
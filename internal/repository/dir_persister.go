@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+const dirIndexFile = "index.json"
+
+
+// DirPersister stores each book as its own JSON file under dir/books, with
+// dir/index.json recording the catalog order. Save only rewrites the files of
+// books that actually changed.
+type DirPersister struct {
+	dir   string
+	saved map[string][]byte
+	order []string
+}
+
+
+func NewDirPersister(dir string) (*DirPersister, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "books"), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	return &DirPersister{
+		dir:   dir,
+		saved: make(map[string][]byte),
+	}, nil
+}
+
+
+func (p *DirPersister) Load() ([]models.Book, error) {
+	var order []string
+	data, err := os.ReadFile(filepath.Join(p.dir, dirIndexFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading book index: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &order); err != nil {
+			return nil, fmt.Errorf("error parsing book index: %w", err)
+		}
+	}
+
+	books := make([]models.Book, 0, len(order))
+	for _, id := range order {
+		data, err := os.ReadFile(p.bookFile(id))
+		if err != nil {
+			return nil, fmt.Errorf("error reading book %s: %w", id, err)
+		}
+
+		var book models.Book
+		if err := json.Unmarshal(data, &book); err != nil {
+			return nil, fmt.Errorf("error parsing book %s: %w", id, err)
+		}
+
+		books = append(books, book)
+		p.saved[id] = data
+	}
+
+	p.order = order
+	return books, nil
+}
+
+
+func (p *DirPersister) Save(books []models.Book) error {
+	order := make([]string, 0, len(books))
+	current := make(map[string][]byte, len(books))
+
+	for _, book := range books {
+		data, err := json.MarshalIndent(book, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error serializing book data: %w", err)
+		}
+
+		order = append(order, book.BookID)
+		current[book.BookID] = data
+
+		if bytes.Equal(p.saved[book.BookID], data) {
+			continue
+		}
+		if err := writeFileAtomic(p.bookFile(book.BookID), data, false); err != nil {
+			return fmt.Errorf("error writing book %s: %w", book.BookID, err)
+		}
+	}
+
+
+	// The index is written before stale files are removed, so a crash in
+	// between leaves only unreferenced files behind.
+	if !slices.Equal(order, p.order) {
+		data, err := json.Marshal(order)
+		if err != nil {
+			return fmt.Errorf("error serializing book index: %w", err)
+		}
+		if err := writeFileAtomic(filepath.Join(p.dir, dirIndexFile), data, false); err != nil {
+			return fmt.Errorf("error writing book index: %w", err)
+		}
+	}
+
+	for id := range p.saved {
+		if _, ok := current[id]; !ok {
+			if err := os.Remove(p.bookFile(id)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing book %s: %w", id, err)
+			}
+		}
+	}
+
+	p.saved = current
+	p.order = order
+	return nil
+}
+
+
+// bookFile maps a BookID to its file. The ID is path-escaped, so it can
+// never point outside the directory and distinct IDs get distinct files.
+func (p *DirPersister) bookFile(id string) string {
+	return filepath.Join(p.dir, "books", url.PathEscape(id)+".json")
+}
+
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+
+// Driver opens a BookRepository from a DSN such as file://data/books.json.
+// Drivers make themselves available by calling Register from an init
+// function, the same way database/sql drivers do.
+type Driver interface {
+	Open(dsn string) (BookRepository, error)
+}
+
+
+// DriverFunc adapts a plain function to the Driver interface.
+type DriverFunc func(dsn string) (BookRepository, error)
+
+
+func (f DriverFunc) Open(dsn string) (BookRepository, error) {
+	return f(dsn)
+}
+
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+
+// Register makes a driver available under the given DSN scheme. It panics
+// if the scheme is registered twice or the driver is nil.
+func Register(scheme string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if driver == nil {
+		panic("repository: Register driver is nil")
+	}
+	if _, dup := drivers[scheme]; dup {
+		panic("repository: Register called twice for scheme " + scheme)
+	}
+
+	drivers[scheme] = driver
+}
+
+
+// Drivers returns the registered schemes in sorted order.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+
+// Open opens the repository described by dsn with the driver registered for
+// its scheme. A scheme of the form base+variant, e.g. sql+postgres, falls
+// back to the driver registered for base when variant has none of its own.
+func Open(dsn string) (BookRepository, error) {
+	scheme, _, ok := strings.Cut(dsn, ":")
+	if !ok || scheme == "" {
+		return nil, fmt.Errorf("invalid repository DSN %q: missing scheme", dsn)
+	}
+
+	driversMu.RLock()
+	driver, found := drivers[scheme]
+	if !found {
+		if base, _, variant := strings.Cut(scheme, "+"); variant {
+			driver, found = drivers[base]
+		}
+	}
+	driversMu.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("unknown repository scheme %q (registered: %s)", scheme, strings.Join(Drivers(), ", "))
+	}
+
+	return driver.Open(dsn)
+}
+
+
+// parseDSN splits a DSN into the location it names and its query options.
+// Both scheme://relative/path and scheme:///absolute/path are accepted.
+func parseDSN(dsn string) (string, url.Values, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid repository DSN %q: %w", dsn, err)
+	}
+
+	location := u.Opaque
+	if location == "" {
+		location = u.Host + u.Path
+	}
+
+	return location, u.Query(), nil
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+
+const encryptionAlgorithm = "AES-GCM"
+
+
+// Keyring holds the AES keys of an encrypted data file. The current key
+// encrypts every write; the others are only used to decrypt, so rotating
+// means adding a new current key and keeping the old one until the file has
+// been written again.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+
+// encryptedDocument is the on-disk form of an encrypted data file.
+type encryptedDocument struct {
+	Encryption string `json:"encryption"`
+	KeyID      string `json:"keyId"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+
+// NewKeyring builds a keyring from raw AES-128, -192 or -256 keys by ID.
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the keyring", current)
+	}
+
+	k := &Keyring{
+		current: current,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+
+	return k, nil
+}
+
+
+// ParseKeyring reads keys written as id:base64key, separated by newlines
+// or commas. The first key is the current one. Blank lines and lines
+// starting with # are ignored.
+func ParseKeyring(text string) (*Keyring, error) {
+	var current string
+	keys := make(map[string][]byte)
+
+	entries := strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("key entry must look like id:base64key")
+		}
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("key %q is listed twice", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64: %w", id, err)
+		}
+
+		keys[id] = key
+		if current == "" {
+			current = id
+		}
+	}
+
+	if current == "" {
+		return nil, fmt.Errorf("keyring is empty")
+	}
+
+	return NewKeyring(current, keys)
+}
+
+
+// LoadKeyringFile reads a keyring in the ParseKeyring format from a file.
+func LoadKeyringFile(filename string) (*Keyring, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	return ParseKeyring(string(data))
+}
+
+
+// KeyringFromEnv reads a keyring in the ParseKeyring format from an
+// environment variable.
+func KeyringFromEnv(name string) (*Keyring, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	return ParseKeyring(value)
+}
+
+
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+
+// seal encrypts data with the current key. The key ID is authenticated
+// along with the data.
+func (k *Keyring) seal(data []byte) ([]byte, error) {
+	document, err := k.encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error serializing encrypted data: %w", err)
+	}
+
+	return sealed, nil
+}
+
+
+// sealLine encrypts data like seal, on a single line for JSON lines files.
+func (k *Keyring) sealLine(data []byte) ([]byte, error) {
+	document, err := k.encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing encrypted data: %w", err)
+	}
+
+	return sealed, nil
+}
+
+
+func (k *Keyring) encrypt(data []byte) (encryptedDocument, error) {
+	aead := k.keys[k.current]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return encryptedDocument{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return encryptedDocument{
+		Encryption: encryptionAlgorithm,
+		KeyID:      k.current,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, data, []byte(k.current)),
+	}, nil
+}
+
+
+// unseal returns data as-is if it is not encrypted, and decrypts it
+// otherwise. keyring may be nil, in which case encrypted data is refused.
+func unseal(keyring *Keyring, data []byte) ([]byte, error) {
+	document, encrypted := parseEncrypted(data)
+	if !encrypted {
+		return data, nil
+	}
+
+	if document.Encryption != encryptionAlgorithm {
+		return nil, fmt.Errorf("%w: unknown encryption %q", ErrDecryption, document.Encryption)
+	}
+	if keyring == nil {
+		return nil, fmt.Errorf("%w: data is encrypted and no key is configured", ErrDecryption)
+	}
+
+	aead, ok := keyring.keys[document.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: key %q is not in the keyring", ErrDecryption, document.KeyID)
+	}
+	if len(document.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrDecryption)
+	}
+
+	plain, err := aead.Open(nil, document.Nonce, document.Ciphertext, []byte(document.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrong key or tampered data", ErrDecryption)
+	}
+
+	return plain, nil
+}
+
+
+// EncryptionKeyring returns the keyring repo encrypts its data with, seen
+// through any decorators, or nil if its data is not encrypted.
+func EncryptionKeyring(repo BookRepository) *Keyring {
+	if source, ok := repo.(encrypted); ok {
+		return source.encryptionKeyring()
+	}
+	return nil
+}
+
+
+func parseEncrypted(data []byte) (encryptedDocument, bool) {
+	var document encryptedDocument
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return document, false
+	}
+	if err := json.Unmarshal(trimmed, &document); err != nil {
+		return document, false
+	}
+	return document, document.Encryption != ""
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+)
+
+
+var (
+	// ErrNotFound is wrapped by every error reporting a missing book.
+	ErrNotFound = errors.New("book not found")
+
+	// ErrRevisionNotFound is returned for a revision a book never had.
+	ErrRevisionNotFound = errors.New("revision not found")
+
+	// ErrVersionConflict is returned when a write names a version that no
+	// longer matches the stored book.
+	ErrVersionConflict = errors.New("version conflict")
+
+	// ErrInvalidBatch is returned for a batch with a malformed operation.
+	ErrInvalidBatch = errors.New("invalid batch")
+
+	// ErrUnsupportedFormat is returned for a books file written in a newer
+	// format than this build understands.
+	ErrUnsupportedFormat = errors.New("unsupported book data format")
+
+	// ErrDecryption is returned for encrypted data that cannot be decrypted
+	// with the configured keys.
+	ErrDecryption = errors.New("cannot decrypt book data")
+
+	// ErrLockTimeout is returned when another process holds the data file
+	// for longer than the lock timeout.
+	ErrLockTimeout = errors.New("timed out waiting for data file lock")
+
+	// ErrChangesTruncated is returned for a replication log position older
+	// than the oldest change still kept.
+	ErrChangesTruncated = errors.New("change log no longer reaches back that far")
+
+	// ErrSnapshotNotFound is returned for a snapshot ID that does not exist.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+
+	// ErrSnapshotCorrupt is returned when a snapshot fails its checksum.
+	ErrSnapshotCorrupt = errors.New("snapshot corrupt")
+
+	// ErrAuthorNotFound is wrapped by every error reporting a missing author.
+	ErrAuthorNotFound = errors.New("author not found")
+
+	// ErrPublisherNotFound is wrapped by every error reporting a missing
+	// publisher.
+	ErrPublisherNotFound = errors.New("publisher not found")
+
+	// ErrInvalidRecord is returned for a record with missing or malformed
+	// fields.
+	ErrInvalidRecord = errors.New("invalid record")
+
+	// ErrInvalidReference is returned for a book naming a related record,
+	// such as its author, that does not exist.
+	ErrInvalidReference = errors.New("reference to a missing record")
+
+	// ErrInsufficientStock is returned for a stock movement that would take
+	// more copies than are on hand.
+	ErrInsufficientStock = errors.New("insufficient stock")
+
+	// ErrLocationNotFound is wrapped by every error reporting a missing
+	// location.
+	ErrLocationNotFound = errors.New("location not found")
+
+	// ErrReservationNotFound is wrapped by every error reporting a missing
+	// reservation.
+	ErrReservationNotFound = errors.New("reservation not found")
+
+	// ErrReservationExpired is returned for a reservation whose hold ran
+	// out before it was confirmed.
+	ErrReservationExpired = errors.New("reservation expired")
+
+	// ErrStillReferenced is returned for a delete that would leave books
+	// pointing at a record that no longer exists.
+	ErrStillReferenced = errors.New("record is still referenced by books")
+)
+
+
+func errBookNotFound(id string) error {
+	return fmt.Errorf("%w with ID: %s", ErrNotFound, id)
+}
+
+
+func errISBNNotFound(isbn string) error {
+	return fmt.Errorf("%w with ISBN: %s", ErrNotFound, isbn)
+}
+
+
+func errTrashedBookNotFound(id string) error {
+	return fmt.Errorf("%w in trash with ID: %s", ErrNotFound, id)
+}
+
+
+func errAuthorNotFound(id string) error {
+	return fmt.Errorf("%w with ID: %s", ErrAuthorNotFound, id)
+}
+
+
+func errPublisherNotFound(id string) error {
+	return fmt.Errorf("%w with ID: %s", ErrPublisherNotFound, id)
+}
+
+
+func errLocationNotFound(id string) error {
+	return fmt.Errorf("%w with ID: %s", ErrLocationNotFound, id)
+}
+
+
+func errReservationNotFound(id string) error {
+	return fmt.Errorf("%w with ID: %s", ErrReservationNotFound, id)
+}
+
+
+func errVersionConflict(id string, expected, actual int) error {
+	return fmt.Errorf("%w: book %s is at version %d, not %d", ErrVersionConflict, id, actual, expected)
+}
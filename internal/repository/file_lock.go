@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+
+// DefaultLockTimeout bounds how long a FileRepository waits for another
+// process to release the data file.
+const DefaultLockTimeout = 10 * time.Second
+
+
+const (
+	lockSuffix        = ".lock"
+	lockRetryInterval = 10 * time.Millisecond
+)
+
+
+// fileLock is an advisory lock on filename.lock, shared by readers and held
+// exclusively by writers. The data file itself cannot carry the lock since
+// every write renames a new file over it.
+type fileLock struct {
+	file *os.File
+}
+
+
+// acquireFileLock waits up to timeout for the lock on filename. Each call
+// opens its own descriptor, because flock locks belong to the open file and
+// would otherwise be shared between goroutines.
+func acquireFileLock(filename string, exclusive bool, timeout time.Duration) (*fileLock, error) {
+	f, err := os.OpenFile(filename+lockSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", filename, err)
+		}
+		if locked {
+			return &fileLock{file: f}, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w: %s is still locked after %s", ErrLockTimeout, filename, timeout)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+
+func (l *fileLock) release() {
+	unlockFile(l.file)
+	l.file.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package repository
+
+import "os"
+
+
+// Without flock only the in-process mutex protects the data file.
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+
+func unlockFile(f *os.File) {}
@@ -1,315 +1,599 @@
 package repository
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
+	"time"
 
 	"crud-in-go-lang/internal/models"
-
-	"github.com/google/uuid"
 )
 
 
+func init() {
+	Register("file", DriverFunc(openFileDSN))
+}
+
+
+// FileRepository keeps the catalog in a single JSON file. The mutex guards
+// the file within this process and an advisory lock file guards it against
+// other processes, so several instances can share one file.
 type FileRepository struct {
-	filename string
-	mutex    sync.RWMutex
+	filename    string
+	constraints []UniqueConstraint
+	keyring     *Keyring
+	lockTimeout time.Duration
+	mutex       sync.RWMutex
+
+	// The last parsed contents of the file, reused while its size and
+	// modification time are unchanged, and the changes seen since the
+	// subscribers were last told. publishing keeps deliveries in the
+	// order the changes were seen.
+	publishing     sync.Mutex
+	cacheMutex     sync.Mutex
+	cached         []models.Book
+	cachedInfo     os.FileInfo
+	rejectedInfo   os.FileInfo
+	pending        []ChangeEvent
+	subscribers    map[int]func(ChangeEvent)
+	nextSubscriber int
+
+	done      chan struct{}
+	watching  sync.WaitGroup
+	closeOnce sync.Once
 }
 
 
-func NewFileRepository(filename string) (*FileRepository, error) {
+func NewFileRepository(filename string, opts ...Option) (*FileRepository, error) {
 
 	dir := filepath.Dir(filename)
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	options := newOptions(opts)
+	repo := &FileRepository{
+		filename:    filename,
+		constraints: options.constraints,
+		keyring:     options.keyring,
+		lockTimeout: options.lockTimeout,
+	}
 
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		file, err := os.Create(filename)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create file: %w", err)
-		}
-		defer file.Close()
+
+	// Another process may be opening the same file; only one gets to
+	// recover, initialize or migrate it.
+	unlock, err := repo.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := repo.recover(); err != nil {
+		return nil, err
+	}
 
 
-		_, err = file.Write([]byte("[]"))
+	if data, err := os.ReadFile(filename); os.IsNotExist(err) || (err == nil && len(bytes.TrimSpace(data)) == 0) {
+		data, err := encodeFile(repo.keyring, nil)
 		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(filename, data, false); err != nil {
 			return nil, fmt.Errorf("failed to initialize file: %w", err)
 		}
 	}
 
-	return &FileRepository{
-		filename: filename,
-	}, nil
-}
-
 
-func (r *FileRepository) GetAll(params models.PaginationParams) ([]models.Book, error) {
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-
-	books, err := r.readBooks()
+	report, err := migrateFile(filename, false, repo.keyring)
 	if err != nil {
 		return nil, err
 	}
+	if report.Needed() {
+		log.Printf("repository: migrated %s from format version %d to %d, %d books changed",
+			filename, report.FromVersion, report.ToVersion, len(report.Changed))
+	}
 
-
-	start := params.Offset
-	end := params.Offset + params.Limit
-
-	if start >= len(books) {
-		return []models.Book{}, nil
+	if err := repo.encryptAtRest(); err != nil {
+		return nil, err
 	}
 
-	if end > len(books) {
-		end = len(books)
+	if options.watchInterval > 0 {
+		repo.done = make(chan struct{})
+		repo.watching.Add(1)
+		go repo.watch(options.watchInterval)
 	}
 
-	return books[start:end], nil
+	return repo, nil
 }
 
 
-func (r *FileRepository) GetByID(id string) (*models.Book, error) {
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-
-	books, err := r.readBooks()
+// openFileDSN handles file://path/to/books.json.
+func openFileDSN(dsn string) (BookRepository, error) {
+	path, values, err := parseDSN(dsn)
 	if err != nil {
 		return nil, err
 	}
+	if path == "" {
+		return nil, fmt.Errorf("file DSN %q has no path", dsn)
+	}
 
-	for _, book := range books {
-		if book.BookID == id {
-			return &book, nil
-		}
+	opts, err := dsnOptions(values)
+	if err != nil {
+		return nil, fmt.Errorf("file DSN %q: %w", dsn, err)
 	}
 
-	return nil, fmt.Errorf("book not found with ID: %s", id)
+	repo, err := NewFileRepository(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
 }
 
 
-func (r *FileRepository) Create(book models.Book) (*models.Book, error) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+func (r *FileRepository) GetAll(params models.PaginationParams) ([]models.Book, error) {
+	var books []models.Book
+	err := r.view(func(c *catalog) {
+		books = c.page(params)
+	})
+	return books, err
+}
 
-	// Generate a UUID if not provided
-	if book.BookID == "" {
-		book.BookID = uuid.New().String()
-	}
 
-	books, err := r.readBooks()
-	if err != nil {
+func (r *FileRepository) GetByID(id string) (*models.Book, error) {
+	var book models.Book
+	var found bool
+	if err := r.view(func(c *catalog) {
+		book, found = c.getLive(id)
+	}); err != nil {
 		return nil, err
 	}
 
-
-	for _, existingBook := range books {
-		if existingBook.BookID == book.BookID {
-			return nil, fmt.Errorf("book with ID %s already exists", book.BookID)
-		}
+	if !found {
+		return nil, errBookNotFound(id)
 	}
 
-
-	books = append(books, book)
+	return &book, nil
+}
 
 
-	if err := r.writeBooks(books); err != nil {
+func (r *FileRepository) GetByISBN(isbn string) (*models.Book, error) {
+	var book models.Book
+	var found bool
+	if err := r.view(func(c *catalog) {
+		book, found = c.getByISBN(isbn)
+	}); err != nil {
 		return nil, err
 	}
 
+	if !found {
+		return nil, errISBNNotFound(isbn)
+	}
+
 	return &book, nil
 }
 
 
-func (r *FileRepository) Update(id string, book models.Book) (*models.Book, error) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-
-	books, err := r.readBooks()
+func (r *FileRepository) Create(book models.Book) (*models.Book, error) {
+	var created models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		created, err = c.create(book)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-
-	found := false
-	for i, existingBook := range books {
-		if existingBook.BookID == id {
-			book.BookID = id 
-			books[i] = book
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		return nil, fmt.Errorf("book not found with ID: %s", id)
-	}
+	return &created, nil
+}
 
 
-	if err := r.writeBooks(books); err != nil {
+func (r *FileRepository) Update(id string, book models.Book) (*models.Book, error) {
+	var updated models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		updated, err = c.update(id, book)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return &book, nil
+	return &updated, nil
 }
 
 
-func (r *FileRepository) Delete(id string) error {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-
-	books, err := r.readBooks()
-	if err != nil {
+func (r *FileRepository) Delete(id string, expectedVersion int) (*models.Book, error) {
+	var deleted models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		deleted, err = c.delete(id, expectedVersion, time.Now())
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	return &deleted, nil
+}
 
-	foundIndex := -1
-	for i, book := range books {
-		if book.BookID == id {
-			foundIndex = i
-			break
-		}
-	}
 
-	if foundIndex == -1 {
-		return fmt.Errorf("book not found with ID: %s", id)
-	}
+func (r *FileRepository) Search(query string) ([]models.Book, error) {
+	var books []models.Book
+	err := r.view(func(c *catalog) {
+		books = c.search(query)
+	})
+	return books, err
+}
 
 
-	books = append(books[:foundIndex], books[foundIndex+1:]...)
+func (r *FileRepository) Count() (int, error) {
+	var count int
+	err := r.view(func(c *catalog) {
+		count = c.count()
+	})
+	return count, err
+}
 
 
-	return r.writeBooks(books)
+func (r *FileRepository) CountMatching(filter models.BookFilter) (int, error) {
+	var count int
+	err := r.view(func(c *catalog) {
+		count = c.countMatching(filter)
+	})
+	return count, err
 }
 
 
-func (r *FileRepository) Search(query string) ([]models.Book, error) {
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
+func (r *FileRepository) GetDeleted(params models.PaginationParams) ([]models.Book, error) {
+	var books []models.Book
+	err := r.view(func(c *catalog) {
+		books = paginate(c.trashed(), params)
+	})
+	return books, err
+}
 
-	books, err := r.readBooks()
+
+func (r *FileRepository) Restore(id string) (*models.Book, error) {
+	var restored models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		restored, err = c.restore(id)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if query == "" {
-		return books, nil
-	}
+	return &restored, nil
+}
+
 
-	query = strings.ToLower(query)
+func (r *FileRepository) Purge(id string) error {
+	return r.mutate(func(c *catalog) error {
+		return c.purge(id)
+	})
+}
+
+
+func (r *FileRepository) PurgeDeletedBefore(cutoff time.Time) (int, error) {
+	var purged []string
+	err := r.mutate(func(c *catalog) error {
+		purged = c.purgeBefore(cutoff)
+		return nil
+	})
+	return len(purged), err
+}
 
 
-	type searchResult struct {
-		books []models.Book
-		err   error
+// Apply runs the whole batch against one read of the file and writes the
+// result once, so a failed operation leaves the file untouched.
+func (r *FileRepository) Apply(ops []models.BatchOp) ([]models.Book, error) {
+	var results []models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		results, err = c.apply(ops, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	return results, nil
+}
 
-	titleChan := make(chan searchResult)
-	descChan := make(chan searchResult)
 
+func (r *FileRepository) Snapshot() ([]models.Book, error) {
+	var books []models.Book
+	err := r.view(func(c *catalog) {
+		books = c.list()
+	})
+	return books, err
+}
 
-	go func() {
-		var results []models.Book
-		for _, book := range books {
-			if strings.Contains(strings.ToLower(book.Title), query) {
-				results = append(results, book)
-			}
-		}
-		titleChan <- searchResult{books: results}
-	}()
 
+func (r *FileRepository) ReplaceAll(books []models.Book) error {
+	return r.mutate(func(c *catalog) error {
+		c.replace(books)
+		return nil
+	})
+}
 
-	go func() {
-		var results []models.Book
-		for _, book := range books {
-			if strings.Contains(strings.ToLower(book.Description), query) {
-				results = append(results, book)
-			}
-		}
-		descChan <- searchResult{books: results}
-	}()
 
+// ApplyChanges stores replicated book states as they are.
+func (r *FileRepository) ApplyChanges(changes []models.Change) error {
+	return r.mutate(func(c *catalog) error {
+		c.applyChanges(changes)
+		return nil
+	})
+}
 
-	titleResults := <-titleChan
-	descResults := <-descChan
 
-	if titleResults.err != nil {
-		return nil, titleResults.err
-	}
-	if descResults.err != nil {
-		return nil, descResults.err
-	}
+func (r *FileRepository) encryptionKeyring() *Keyring {
+	return r.keyring
+}
 
 
-	uniqueBooks := make(map[string]models.Book)
-	
-	for _, book := range titleResults.books {
-		uniqueBooks[book.BookID] = book
+// lock takes the mutex and the lock file, shared or exclusive, and returns
+// a function releasing both.
+func (r *FileRepository) lock(exclusive bool) (func(), error) {
+	if exclusive {
+		r.mutex.Lock()
+	} else {
+		r.mutex.RLock()
 	}
-	
-	for _, book := range descResults.books {
-		uniqueBooks[book.BookID] = book
+
+	fl, err := acquireFileLock(r.filename, exclusive, r.lockTimeout)
+	if err != nil {
+		if exclusive {
+			r.mutex.Unlock()
+		} else {
+			r.mutex.RUnlock()
+		}
+		return nil, err
 	}
 
+	return func() {
+		fl.release()
+		if exclusive {
+			r.mutex.Unlock()
+		} else {
+			r.mutex.RUnlock()
+		}
+		r.publish()
+	}, nil
+}
 
-	var result []models.Book
-	for _, book := range uniqueBooks {
-		result = append(result, book)
+
+// view runs fn against the catalog as currently stored on disk.
+func (r *FileRepository) view(fn func(c *catalog)) error {
+	unlock, err := r.lock(false)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	return result, nil
+	books, err := r.readBooks()
+	if err != nil {
+		return err
+	}
+
+	fn(newCatalog(books, r.constraints))
+	return nil
 }
 
 
-func (r *FileRepository) Count() (int, error) {
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
+// mutate runs fn against the stored catalog and writes the result back,
+// unless fn fails, in which case the file is left untouched.
+func (r *FileRepository) mutate(fn func(c *catalog) error) error {
+	unlock, err := r.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	books, err := r.readBooks()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	return len(books), nil
+	c := newCatalog(books, r.constraints)
+	if err := fn(c); err != nil {
+		return err
+	}
+
+	return r.writeBooks(c.books)
 }
 
 
+// readBooks returns the books in the file, parsing it again only if it was
+// written since the last read. If it was changed into something that does
+// not parse, the last good version keeps being served.
 func (r *FileRepository) readBooks() ([]models.Book, error) {
-	data, err := os.ReadFile(r.filename)
+	info, err := os.Stat(r.filename)
 	if err != nil {
 		return nil, fmt.Errorf("error reading book data: %w", err)
 	}
+	if books, ok := r.cachedBooks(info); ok {
+		return books, nil
+	}
 
-	if len(data) == 0 {
-		return []models.Book{}, nil
+	data, err := os.ReadFile(r.filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading book data: %w", err)
 	}
 
-	var books []models.Book
-	err = json.Unmarshal(data, &books)
+	books, err := decodeFile(r.keyring, data)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing book data: %w", err)
+		if last, ok := r.lastGood(info, err); ok {
+			return last, nil
+		}
+		return nil, err
 	}
 
+	r.cache(books, info)
 	return books, nil
 }
 
 
 func (r *FileRepository) writeBooks(books []models.Book) error {
-	data, err := json.MarshalIndent(books, "", "  ")
+	data, err := encodeFile(r.keyring, books)
 	if err != nil {
-		return fmt.Errorf("error serializing book data: %w", err)
+		return err
+	}
+
+	if err := r.setAsideRejected(); err != nil {
+		return err
 	}
 
-	err = os.WriteFile(r.filename, data, 0644)
+	err = writeFileAtomic(r.filename, data, true)
 	if err != nil {
 		return fmt.Errorf("error writing book data: %w", err)
 	}
 
+	if info, err := os.Stat(r.filename); err == nil {
+		r.cache(books, info)
+	}
+
 	return nil
-}
\ No newline at end of file
+}
+
+
+// cachedBooks returns a copy of the cached books if info still describes
+// the file they were read from, or an edit already rejected.
+func (r *FileRepository) cachedBooks(info os.FileInfo) ([]models.Book, bool) {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	if r.cachedInfo == nil {
+		return nil, false
+	}
+	if !sameFileState(r.cachedInfo, info) && (r.rejectedInfo == nil || !sameFileState(r.rejectedInfo, info)) {
+		return nil, false
+	}
+
+	return append([]models.Book(nil), r.cached...), true
+}
+
+
+func (r *FileRepository) cache(books []models.Book, info os.FileInfo) {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	if r.cachedInfo != nil && len(r.subscribers) > 0 {
+		r.pending = append(r.pending, diffBooks(r.cached, books)...)
+	}
+
+	r.cached = append([]models.Book(nil), books...)
+	r.cachedInfo = info
+	r.rejectedInfo = nil
+}
+
+
+// recover brings the data file back to a consistent state after a crash.
+// Temp files from an interrupted write are discarded, since the write they
+// belonged to never completed. A missing, empty or unparsable data file is
+// replaced by the previous generation kept in filename.bak; the corrupt file
+// is moved aside rather than deleted so it can be inspected.
+func (r *FileRepository) recover() error {
+	leftovers, err := leftoverTempFiles(r.filename)
+	if err != nil {
+		return fmt.Errorf("failed to scan for leftover temp files: %w", err)
+	}
+
+	for _, name := range leftovers {
+		if err := os.Remove(name); err != nil {
+			return fmt.Errorf("failed to remove leftover temp file: %w", err)
+		}
+		log.Printf("repository: removed incomplete write %s", name)
+	}
+
+	data, err := os.ReadFile(r.filename)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading book data: %w", err)
+	}
+
+	// An empty file is treated like a missing one: a crash truncated it,
+	// or it was created empty to be initialized.
+	missing := os.IsNotExist(err) || len(bytes.TrimSpace(data)) == 0
+	var parseErr error
+	if !missing {
+		if _, parseErr = decodeFile(r.keyring, data); parseErr == nil {
+			return nil
+		}
+		// A newer build's file, or one needing another key, is intact;
+		// replacing it would lose data.
+		if errors.Is(parseErr, ErrUnsupportedFormat) || errors.Is(parseErr, ErrDecryption) {
+			return parseErr
+		}
+	}
+
+	backup := r.filename + backupSuffix
+	backupData, err := os.ReadFile(backup)
+	if err != nil {
+		if missing {
+			return nil
+		}
+		return fmt.Errorf("book data in %s is corrupt and no backup is available: %w", r.filename, parseErr)
+	}
+
+	books, err := decodeFile(r.keyring, backupData)
+	if err != nil {
+		if missing {
+			return fmt.Errorf("book data in %s is missing and the backup is corrupt: %w", r.filename, err)
+		}
+		return fmt.Errorf("book data in %s and its backup are both corrupt: %w", r.filename, parseErr)
+	}
+
+	if !missing {
+		corrupt := r.filename + corruptInfix + time.Now().Format("20060102T150405")
+		if err := os.Rename(r.filename, corrupt); err != nil {
+			return fmt.Errorf("failed to move corrupt data file aside: %w", err)
+		}
+		log.Printf("repository: %s is corrupt (%v), moved it to %s", r.filename, parseErr, corrupt)
+	}
+
+	if err := writeFileAtomic(r.filename, backupData, false); err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+	log.Printf("repository: restored %d books in %s from %s", len(books), r.filename, backup)
+
+	return nil
+}
+
+
+// encryptAtRest encrypts the data file and its backup if a keyring is set
+// and either is still in plain text, so no plain copy is left behind.
+func (r *FileRepository) encryptAtRest() error {
+	if r.keyring == nil {
+		return nil
+	}
+
+	for _, name := range []string{r.filename, r.filename + backupSuffix} {
+		data, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading book data: %w", err)
+		}
+		if _, encrypted := parseEncrypted(data); encrypted {
+			continue
+		}
+
+		books, err := decodeBooks(data)
+		if err != nil {
+			return fmt.Errorf("error encrypting %s: %w", name, err)
+		}
+		sealed, err := encodeFile(r.keyring, books)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(name, sealed, false); err != nil {
+			return fmt.Errorf("error encrypting %s: %w", name, err)
+		}
+		log.Printf("repository: encrypted %s with key %s", name, r.keyring.CurrentKeyID())
+	}
+
+	return nil
+}
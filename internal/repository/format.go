@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+// CurrentFormatVersion is the version of the books file envelope written by
+// this build. Version 1 was a bare JSON array of books.
+const CurrentFormatVersion = 2
+
+
+// fileDocument is the envelope of a books file:
+// {"formatVersion": 2, "books": [...]}.
+type fileDocument struct {
+	FormatVersion int           `json:"formatVersion"`
+	Books         []models.Book `json:"books"`
+}
+
+
+// formatMigration upgrades a books file from format version from to from+1.
+// Books are handled as raw JSON objects, so a migration can rename or fill
+// in fields that models.Book no longer has. A migration must keep the
+// number and order of the records.
+type formatMigration struct {
+	from        int
+	description string
+	migrate     func(records []map[string]json.RawMessage) error
+}
+
+
+var formatMigrations = []formatMigration{
+	{
+		from:        1,
+		description: "wrap books in a versioned envelope and give unversioned books version 1",
+		migrate: func(records []map[string]json.RawMessage) error {
+			for _, record := range records {
+				if version, ok := record["version"]; !ok || string(version) == "0" {
+					record["version"] = json.RawMessage("1")
+				}
+			}
+			return nil
+		},
+	},
+}
+
+
+// MigrationReport describes the upgrade of a books file to the current
+// format version.
+type MigrationReport struct {
+	FromVersion int      `json:"fromVersion"`
+	ToVersion   int      `json:"toVersion"`
+	Steps       []string `json:"steps"`
+	Changed     []string `json:"changed"` // IDs of books whose record changed
+	DryRun      bool     `json:"dryRun"`
+}
+
+
+// Needed reports whether the file was not already in the current format.
+func (r *MigrationReport) Needed() bool {
+	return r.FromVersion != r.ToVersion
+}
+
+
+// MigrateFile upgrades the books file at filename in place, keeping the old
+// generation as filename.bak. With dryRun the file is left untouched and
+// the report only says what would change. An encrypted file needs the
+// keyring given WithKeyring.
+func MigrateFile(filename string, dryRun bool, opts ...Option) (*MigrationReport, error) {
+	options := newOptions(opts)
+
+	lock, err := acquireFileLock(filename, !dryRun, options.lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	return migrateFile(filename, dryRun, options.keyring)
+}
+
+
+// migrateFile is MigrateFile for a caller already holding the file lock.
+func migrateFile(filename string, dryRun bool, keyring *Keyring) (*MigrationReport, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading book data: %w", err)
+	}
+
+	plain, err := unseal(keyring, data)
+	if err != nil {
+		return nil, err
+	}
+
+	books, report, err := migrateBooks(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	report.DryRun = dryRun
+	if dryRun || !report.Needed() {
+		return report, nil
+	}
+
+	data, err = encodeFile(keyring, books)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filename, data, true); err != nil {
+		return nil, fmt.Errorf("error writing migrated book data: %w", err)
+	}
+
+	return report, nil
+}
+
+
+// encodeBooks serializes books in the current format.
+func encodeBooks(books []models.Book) ([]byte, error) {
+	if books == nil {
+		books = []models.Book{}
+	}
+
+	data, err := json.MarshalIndent(fileDocument{
+		FormatVersion: CurrentFormatVersion,
+		Books:         books,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error serializing book data: %w", err)
+	}
+
+	return data, nil
+}
+
+
+// encodeFile serializes books for a data file, encrypting them if keyring
+// is not nil.
+func encodeFile(keyring *Keyring, books []models.Book) ([]byte, error) {
+	data, err := encodeBooks(books)
+	if err != nil || keyring == nil {
+		return data, err
+	}
+
+	return keyring.seal(data)
+}
+
+
+// decodeFile parses a data file written by encodeFile, or a plain file of
+// an older format.
+func decodeFile(keyring *Keyring, data []byte) ([]models.Book, error) {
+	plain, err := unseal(keyring, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeBooks(plain)
+}
+
+
+// decodeBooks parses a books file of any supported format version. Older
+// versions are upgraded in memory only; see MigrateFile.
+func decodeBooks(data []byte) ([]models.Book, error) {
+	books, _, err := migrateBooks(data)
+	return books, err
+}
+
+
+func migrateBooks(data []byte) ([]models.Book, *MigrationReport, error) {
+	version, raw, err := splitDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report := &MigrationReport{
+		FromVersion: version,
+		ToVersion:   CurrentFormatVersion,
+		Steps:       []string{},
+		Changed:     []string{},
+	}
+
+	if version > CurrentFormatVersion {
+		return nil, nil, fmt.Errorf("%w: book data has format version %d, newer than the supported %d", ErrUnsupportedFormat, version, CurrentFormatVersion)
+	}
+
+	if version == CurrentFormatVersion {
+		books := []models.Book{}
+		if raw != nil {
+			if err := json.Unmarshal(raw, &books); err != nil {
+				return nil, nil, fmt.Errorf("error parsing book data: %w", err)
+			}
+		}
+		return books, report, nil
+	}
+
+
+	var records []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return nil, nil, fmt.Errorf("error parsing book data: %w", err)
+	}
+
+	original := make([][]byte, len(records))
+	for i, record := range records {
+		original[i], _ = json.Marshal(record)
+	}
+
+	for v := version; v < CurrentFormatVersion; v++ {
+		migration, ok := findFormatMigration(v)
+		if !ok {
+			return nil, nil, fmt.Errorf("no migration from book format version %d", v)
+		}
+
+		if err := migration.migrate(records); err != nil {
+			return nil, nil, fmt.Errorf("book format migration %d -> %d failed: %w", v, v+1, err)
+		}
+		report.Steps = append(report.Steps, fmt.Sprintf("%d -> %d: %s", v, v+1, migration.description))
+	}
+
+	for i, record := range records {
+		migrated, _ := json.Marshal(record)
+		if !bytes.Equal(original[i], migrated) {
+			report.Changed = append(report.Changed, recordID(record, i))
+		}
+	}
+
+	migrated, err := json.Marshal(records)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error serializing book data: %w", err)
+	}
+
+	books := []models.Book{}
+	if err := json.Unmarshal(migrated, &books); err != nil {
+		return nil, nil, fmt.Errorf("error parsing migrated book data: %w", err)
+	}
+
+	return books, report, nil
+}
+
+
+// errEmptyFile reports a books file with nothing in it. NewFileRepository
+// never leaves one behind, so it is a file being rewritten by hand or one
+// truncated by a crash, and is never taken for an empty catalog.
+var errEmptyFile = errors.New("error parsing book data: file is empty")
+
+
+// splitDocument finds the format version of a books file and the raw JSON
+// array of its books.
+func splitDocument(data []byte) (int, json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return 0, nil, errEmptyFile
+	}
+
+	if trimmed[0] == '[' {
+		return 1, trimmed, nil
+	}
+
+	var envelope struct {
+		FormatVersion int             `json:"formatVersion"`
+		Books         json.RawMessage `json:"books"`
+	}
+	if err := json.Unmarshal(trimmed, &envelope); err != nil {
+		return 0, nil, fmt.Errorf("error parsing book data: %w", err)
+	}
+	if envelope.FormatVersion < 2 {
+		return 0, nil, fmt.Errorf("error parsing book data: missing or invalid formatVersion")
+	}
+
+	return envelope.FormatVersion, envelope.Books, nil
+}
+
+
+func findFormatMigration(from int) (formatMigration, bool) {
+	for _, migration := range formatMigrations {
+		if migration.from == from {
+			return migration, true
+		}
+	}
+	return formatMigration{}, false
+}
+
+
+func recordID(record map[string]json.RawMessage, i int) string {
+	var id string
+	if err := json.Unmarshal(record["bookId"], &id); err == nil && id != "" {
+		return id
+	}
+	return fmt.Sprintf("#%d", i)
+}
@@ -0,0 +1,290 @@
+package repository
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+const (
+	journalSuffix = ".journal"
+
+	// DefaultCompactAfter is the number of journal records after which the
+	// journal is folded into a fresh snapshot.
+	DefaultCompactAfter = 1000
+)
+
+
+const (
+	journalOpPut    = "put"
+	journalOpDelete = "delete"
+	journalOpBatch  = "batch"
+)
+
+
+func init() {
+	Register("journal", DriverFunc(openJournalDSN))
+}
+
+
+// journalRecord is one line of the journal. A put carries the complete
+// state of the book after the mutation, so replaying a record never depends
+// on what came before it. A mutation touching several books is written as
+// one batch record holding their puts and deletes, so a torn write can never
+// leave half of it in the journal.
+type journalRecord struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id,omitempty"`
+	Book    *models.Book    `json:"book,omitempty"`
+	Records []journalRecord `json:"records,omitempty"`
+}
+
+
+// JournalRepository is the log-structured storage mode of the file
+// repository. The catalog lives in memory; every mutation is appended to
+// filename.journal and the journal is periodically compacted into the
+// snapshot at filename, which uses the same format as FileRepository.
+type JournalRepository struct {
+	residentCatalog
+	filename     string
+	journal      *os.File
+	records      int
+	compactAfter int
+}
+
+
+// NewJournalRepository opens the snapshot at filename and replays its
+// journal. compactAfter controls how many records accumulate before the
+// journal is compacted; zero or less uses DefaultCompactAfter.
+func NewJournalRepository(filename string, compactAfter int, opts ...Option) (*JournalRepository, error) {
+	if compactAfter <= 0 {
+		compactAfter = DefaultCompactAfter
+	}
+	if newOptions(opts).keyring != nil {
+		return nil, fmt.Errorf("journal repository does not support encryption: journal records are stored in plain text")
+	}
+
+
+	snapshot, err := NewFileRepository(filename, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	books, err := snapshot.readBooks()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &JournalRepository{
+		filename:     filename,
+		compactAfter: compactAfter,
+	}
+	r.books = newCatalog(books, snapshot.constraints)
+	r.save = r.journalChanges
+
+	if err := r.replay(); err != nil {
+		return nil, err
+	}
+
+	journal, err := os.OpenFile(r.journalName(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	r.journal = journal
+
+	return r, nil
+}
+
+
+// openJournalDSN handles journal://path/to/books.json?compactAfter=N.
+func openJournalDSN(dsn string) (BookRepository, error) {
+	path, values, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("journal DSN %q has no path", dsn)
+	}
+
+	compactAfter := 0
+	if value := values.Get("compactAfter"); value != "" {
+		compactAfter, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("journal DSN %q: invalid compactAfter: %w", dsn, err)
+		}
+	}
+
+	opts, err := dsnOptions(values)
+	if err != nil {
+		return nil, fmt.Errorf("journal DSN %q: %w", dsn, err)
+	}
+
+	repo, err := NewJournalRepository(path, compactAfter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+
+// Compact folds the journal into a new snapshot and truncates it.
+func (r *JournalRepository) Compact() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.compact()
+}
+
+
+// Close compacts the journal and releases the journal file.
+func (r *JournalRepository) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.compact(); err != nil {
+		return err
+	}
+
+	return r.journal.Close()
+}
+
+
+func (r *JournalRepository) journalName() string {
+	return r.filename + journalSuffix
+}
+
+
+// journalChanges appends the new state of every touched book. Puts are
+// written in catalog order, so replaying them adds new books in the order
+// they were created.
+func (r *JournalRepository) journalChanges(touched map[string]*models.Book) error {
+	if len(touched) == 1 {
+		for id := range touched {
+			return r.append(r.journalRecordOf(id))
+		}
+	}
+
+	records := make([]journalRecord, 0, len(touched))
+	for _, book := range r.books.books {
+		if _, ok := touched[book.BookID]; ok {
+			records = append(records, journalRecord{Op: journalOpPut, ID: book.BookID, Book: &book})
+		}
+	}
+	var deleted []string
+	for id := range touched {
+		if _, ok := r.books.get(id); !ok {
+			deleted = append(deleted, id)
+		}
+	}
+	slices.Sort(deleted)
+	for _, id := range deleted {
+		records = append(records, journalRecord{Op: journalOpDelete, ID: id})
+	}
+
+	return r.append(journalRecord{Op: journalOpBatch, Records: records})
+}
+
+
+// journalRecordOf is the record of the current state of one book.
+func (r *JournalRepository) journalRecordOf(id string) journalRecord {
+	if book, ok := r.books.get(id); ok {
+		return journalRecord{Op: journalOpPut, ID: id, Book: &book}
+	}
+	return journalRecord{Op: journalOpDelete, ID: id}
+}
+
+
+// append writes records to the journal as a single fsynced write and
+// compacts once enough records have piled up.
+func (r *JournalRepository) append(records ...journalRecord) error {
+	if err := appendJSONLines(r.journal, nil, records...); err != nil {
+		return err
+	}
+
+	r.records += len(records)
+	if r.records >= r.compactAfter {
+		if err := r.compact(); err != nil {
+			// The mutation itself is durable in the journal, so a failed
+			// compaction is only logged and retried on the next write.
+			log.Printf("repository: journal compaction failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+
+// compact writes the in-memory catalog as the new snapshot, then empties
+// the journal. A crash between the two steps is harmless because replaying
+// the old journal over the new snapshot yields the same catalog.
+func (r *JournalRepository) compact() error {
+	data, err := encodeBooks(r.books.list())
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(r.filename, data, true); err != nil {
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+
+	if r.journal != nil {
+		if err := r.journal.Truncate(0); err != nil {
+			return fmt.Errorf("error truncating journal: %w", err)
+		}
+		if err := r.journal.Sync(); err != nil {
+			return fmt.Errorf("error syncing journal: %w", err)
+		}
+	}
+
+	r.records = 0
+	return nil
+}
+
+
+// replay applies the journal on top of the snapshot.
+func (r *JournalRepository) replay() error {
+	records, err := replayJSONLines(r.journalName(), nil, func(record journalRecord, line int) error {
+		return r.replayRecord(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.records = records
+	if records > 0 {
+		log.Printf("repository: replayed %d journal records from %s", records, r.journalName())
+	}
+
+	return nil
+}
+
+
+func (r *JournalRepository) replayRecord(record journalRecord) error {
+	switch record.Op {
+	case journalOpPut:
+		if record.Book == nil {
+			return fmt.Errorf("put without book")
+		}
+		r.books.put(*record.Book)
+	case journalOpDelete:
+		r.books.remove(record.ID)
+	case journalOpBatch:
+		for _, inner := range record.Records {
+			if inner.Op == journalOpBatch {
+				return fmt.Errorf("nested batch")
+			}
+			if err := r.replayRecord(inner); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", record.Op)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+
+// appendJSONLines encodes records one per line, each encrypted on its own
+// if keyring is not nil, and appends them to f as a single fsynced write. A
+// write that fails is cut off again, so it cannot leave a torn line for the
+// next append to land after.
+func appendJSONLines[T any](f *os.File, keyring *Keyring, records ...T) error {
+	var buf bytes.Buffer
+	for _, record := range records {
+		line, err := encodeJSONLine(keyring, record)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", filepath.Base(f.Name()), err)
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return undoAppend(f, info.Size(), fmt.Errorf("error writing %s: %w", filepath.Base(f.Name()), err))
+	}
+	if err := f.Sync(); err != nil {
+		return undoAppend(f, info.Size(), fmt.Errorf("error syncing %s: %w", filepath.Base(f.Name()), err))
+	}
+
+	return nil
+}
+
+
+// undoAppend truncates f back to size after a failed append and returns
+// cause.
+func undoAppend(f *os.File, size int64, cause error) error {
+	if err := f.Truncate(size); err != nil {
+		log.Printf("repository: failed to cut a failed append off %s: %v", f.Name(), err)
+	}
+	return cause
+}
+
+
+// encodeJSONLine serializes record for a JSON lines file, encrypted if
+// keyring is not nil.
+func encodeJSONLine[T any](keyring *Keyring, record T) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing record: %w", err)
+	}
+	if keyring == nil {
+		return line, nil
+	}
+	return keyring.sealLine(line)
+}
+
+
+// decodeJSONLine parses a line written by encodeJSONLine. Plain lines are
+// read whether or not there is a keyring.
+func decodeJSONLine[T any](keyring *Keyring, line []byte) (T, error) {
+	var record T
+	plain, err := unseal(keyring, line)
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(plain, &record)
+	return record, err
+}
+
+
+// replayJSONLines decodes each line of an append-only JSON lines file and
+// hands it to apply, returning the number of records read. A torn final
+// line left by a crash mid-append is dropped and cut off the file; damage
+// anywhere else, or a line that cannot be decrypted, is reported as an
+// error. A missing file holds no records.
+func replayJSONLines[T any](filename string, keyring *Keyring, apply func(record T, line int) error) (int, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading %s: %w", filepath.Base(filename), err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+
+	offset := 0
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := scanner.Bytes()
+		end := offset + len(raw) + 1
+
+		record, err := decodeJSONLine[T](keyring, raw)
+		if errors.Is(err, ErrDecryption) {
+			return 0, fmt.Errorf("%s line %d: %w", filepath.Base(filename), line, err)
+		}
+		if err != nil {
+			if end >= len(data) {
+				return line - 1, truncateTornLine(filename, offset, err)
+			}
+			return 0, fmt.Errorf("%s is corrupt at line %d: %w", filepath.Base(filename), line, err)
+		}
+
+		if err := apply(record, line); err != nil {
+			return 0, fmt.Errorf("%s line %d: %w", filepath.Base(filename), line, err)
+		}
+
+		offset = end
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("error reading %s: %w", filepath.Base(filename), err)
+	}
+
+	return line, nil
+}
+
+
+func truncateTornLine(filename string, offset int, cause error) error {
+	if err := os.Truncate(filename, int64(offset)); err != nil {
+		return fmt.Errorf("failed to truncate torn record: %w", err)
+	}
+
+	log.Printf("repository: dropped incomplete record at offset %d in %s (%v)", offset, filename, cause)
+	return nil
+}
+
+
+// readJSONLinesFrom hands apply the complete lines written to filename
+// after offset, by this process or another, and returns the offset after
+// the last of them. A line still being written is left for the next call.
+func readJSONLinesFrom[T any](filename string, keyring *Keyring, offset int64, apply func(record T)) (int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return offset, fmt.Errorf("error reading %s: %w", filepath.Base(filename), err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, fmt.Errorf("error reading %s: %w", filepath.Base(filename), err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return offset, fmt.Errorf("error reading %s: %w", filepath.Base(filename), err)
+	}
+
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return offset, nil
+		}
+
+		record, err := decodeJSONLine[T](keyring, data[:end])
+		if err != nil {
+			return offset, fmt.Errorf("%s is corrupt at offset %d: %w", filepath.Base(filename), offset, err)
+		}
+		apply(record)
+
+		data = data[end+1:]
+		offset += int64(end + 1)
+	}
+}
+
+
+// encryptJSONLines rewrites the plain lines of filename encrypted with
+// keyring, so no plain copy of them is left behind. Lines already
+// encrypted are kept as they are, whichever key they were written with.
+func encryptJSONLines(filename string, keyring *Keyring) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", filepath.Base(filename), err)
+	}
+
+	var out bytes.Buffer
+	plain := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		trimmed := bytes.TrimSuffix(line, []byte("\n"))
+		if _, encrypted := parseEncrypted(trimmed); encrypted || len(trimmed) == 0 {
+			out.Write(line)
+			continue
+		}
+
+		sealed, err := keyring.sealLine(trimmed)
+		if err != nil {
+			return err
+		}
+		out.Write(sealed)
+		out.WriteByte('\n')
+		plain++
+	}
+	if plain == 0 {
+		return nil
+	}
+
+	if err := writeFileAtomic(filename, out.Bytes(), false); err != nil {
+		return fmt.Errorf("error encrypting %s: %w", filepath.Base(filename), err)
+	}
+	log.Printf("repository: encrypted %d records in %s with key %s", plain, filename, keyring.CurrentKeyID())
+	return nil
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"crud-in-go-lang/internal/models"
+)
+
+
+// LocationRepository stores the locations stock movements refer to by
+// LocationID.
+type LocationRepository = RecordRepository[models.Location]
+
+
+// FileLocationRepository keeps locations in memory and, unless it was
+// created with NewMemoryLocationRepository, in a JSON file.
+type FileLocationRepository struct {
+	*recordRepository[models.Location]
+}
+
+
+// NewFileLocationRepository loads the locations stored in filename.
+func NewFileLocationRepository(filename string) (*FileLocationRepository, error) {
+	records, err := openRecordRepository("location", filename, func(location *models.Location) *string {
+		return &location.LocationID
+	}, errLocationNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileLocationRepository{records}, nil
+}
+
+
+// NewMemoryLocationRepository returns a location repository that is not
+// persisted.
+func NewMemoryLocationRepository() *FileLocationRepository {
+	repo, _ := NewFileLocationRepository("")
+	return repo
+}
@@ -0,0 +1,330 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+func init() {
+	Register("memory", DriverFunc(openMemoryDSN))
+	Register("json-dir", DriverFunc(openJSONDirDSN))
+}
+
+
+// Persister is the durable storage behind a MemoryRepository. Load is called
+// once when the repository is opened and Save after every mutation with the
+// complete catalog.
+type Persister interface {
+	Load() ([]models.Book, error)
+	Save(books []models.Book) error
+}
+
+
+// MemoryRepository serves every read from memory. Books are indexed by
+// BookID, with ISBN, AuthorID, contributors and genre kept as secondary
+// indexes, and the catalog is flushed through an optional Persister on each
+// write.
+type MemoryRepository struct {
+	residentCatalog
+	persister     Persister
+	byISBN        secondaryIndex
+	byAuthor      secondaryIndex
+	byContributor secondaryIndex
+	byGenre       secondaryIndex
+}
+
+
+// NewMemoryRepository loads the catalog from persister. A nil persister
+// gives a purely in-memory repository whose contents die with the process.
+func NewMemoryRepository(persister Persister, opts ...Option) (*MemoryRepository, error) {
+	var books []models.Book
+	if persister != nil {
+		loaded, err := persister.Load()
+		if err != nil {
+			return nil, err
+		}
+		books = loaded
+	}
+
+	r := &MemoryRepository{
+		persister:     persister,
+		byISBN:        secondaryIndex{},
+		byAuthor:      secondaryIndex{},
+		byContributor: secondaryIndex{},
+		byGenre:       secondaryIndex{},
+	}
+	r.books = newCatalog(books, newOptions(opts).constraints)
+	r.save = r.flush
+	r.committed = r.reindexTouched
+
+	for _, book := range books {
+		r.indexBook(book)
+	}
+
+	return r, nil
+}
+
+
+// openMemoryDSN handles memory:// for a purely in-memory catalog and
+// memory://path/to/books.json for one persisted to a JSON file.
+func openMemoryDSN(dsn string) (BookRepository, error) {
+	path, values, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := dsnOptions(values)
+	if err != nil {
+		return nil, fmt.Errorf("memory DSN %q: %w", dsn, err)
+	}
+
+	var persister Persister
+	if path != "" {
+		file, err := NewJSONFilePersister(path, opts...)
+		if err != nil {
+			return nil, err
+		}
+		persister = file
+	}
+
+	repo, err := NewMemoryRepository(persister, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+
+// openJSONDirDSN handles json-dir://path/to/dir, an in-memory catalog
+// persisted as one JSON file per book.
+func openJSONDirDSN(dsn string) (BookRepository, error) {
+	path, values, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("json-dir DSN %q has no path", dsn)
+	}
+
+	persister, err := NewDirPersister(path)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := dsnOptions(values)
+	if err != nil {
+		return nil, fmt.Errorf("json-dir DSN %q: %w", dsn, err)
+	}
+	if newOptions(opts).keyring != nil {
+		return nil, fmt.Errorf("json-dir DSN %q: encryption is not supported", dsn)
+	}
+
+	repo, err := NewMemoryRepository(persister, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+
+func (r *MemoryRepository) GetAll(params models.PaginationParams) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+
+	// Narrow the scan through a secondary index when the filter allows it.
+	switch {
+	case params.Filter.AuthorID != "":
+		candidates := r.lookup(r.byAuthor, params.Filter.AuthorID)
+		return paginate(filterBooks(candidates, params.Filter), params), nil
+	case params.Filter.ContributorID != "":
+		candidates := r.lookup(r.byContributor, params.Filter.ContributorID)
+		return paginate(filterBooks(candidates, params.Filter), params), nil
+	case params.Filter.Genre != "":
+		candidates := r.lookup(r.byGenre, strings.ToLower(params.Filter.Genre))
+		return paginate(filterBooks(candidates, params.Filter), params), nil
+	}
+
+	return r.books.page(params), nil
+}
+
+
+// encryptionKeyring reports the keyring of the persisted file, if any.
+func (r *MemoryRepository) encryptionKeyring() *Keyring {
+	if file, ok := r.persister.(*JSONFilePersister); ok {
+		return file.file.keyring
+	}
+	return nil
+}
+
+
+// FindByISBN returns the books carrying the given ISBN in either form.
+func (r *MemoryRepository) FindByISBN(isbn string) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.lookup(r.byISBN, NormalizeISBN(isbn)), nil
+}
+
+
+// FindByAuthor returns the books written by the given author.
+func (r *MemoryRepository) FindByAuthor(authorID string) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.lookup(r.byAuthor, authorID), nil
+}
+
+
+// FindByGenre returns the books in the given genre, ignoring case.
+func (r *MemoryRepository) FindByGenre(genre string) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.lookup(r.byGenre, strings.ToLower(genre)), nil
+}
+
+
+func (r *MemoryRepository) flush(touched map[string]*models.Book) error {
+	if r.persister == nil {
+		return nil
+	}
+
+	return r.persister.Save(r.books.list())
+}
+
+
+// reindexTouched moves each touched book from its old index entries to its
+// new ones.
+func (r *MemoryRepository) reindexTouched(touched map[string]*models.Book) {
+	for id, original := range touched {
+		if original != nil {
+			r.unindexBook(*original)
+		}
+		if book, ok := r.books.get(id); ok {
+			r.indexBook(book)
+		}
+	}
+}
+
+
+func (r *MemoryRepository) indexBook(book models.Book) {
+	r.byISBN.add(NormalizeISBN(book.ISBN), book.BookID)
+	r.byAuthor.add(book.AuthorID, book.BookID)
+	r.byContributor.add(book.AuthorID, book.BookID)
+	for _, contributor := range book.Contributors {
+		r.byContributor.add(contributor.ContributorID, book.BookID)
+	}
+	r.byGenre.add(strings.ToLower(book.Genre), book.BookID)
+}
+
+
+func (r *MemoryRepository) unindexBook(book models.Book) {
+	r.byISBN.remove(NormalizeISBN(book.ISBN), book.BookID)
+	r.byAuthor.remove(book.AuthorID, book.BookID)
+	r.byContributor.remove(book.AuthorID, book.BookID)
+	for _, contributor := range book.Contributors {
+		r.byContributor.remove(contributor.ContributorID, book.BookID)
+	}
+	r.byGenre.remove(strings.ToLower(book.Genre), book.BookID)
+}
+
+
+// lookup resolves the IDs stored under key to live books, returned in
+// catalog order. Trashed books stay indexed but are skipped here.
+func (r *MemoryRepository) lookup(index secondaryIndex, key string) []models.Book {
+	ids := index[key]
+
+	books := make([]models.Book, 0, len(ids))
+	for id := range ids {
+		if book, ok := r.books.getLive(id); ok {
+			books = append(books, book)
+		}
+	}
+
+	sort.Slice(books, func(i, j int) bool {
+		return r.books.index[books[i].BookID] < r.books.index[books[j].BookID]
+	})
+
+	return books
+}
+
+
+// secondaryIndex maps a field value to the IDs of the books holding it.
+type secondaryIndex map[string]map[string]struct{}
+
+
+func (idx secondaryIndex) add(key, id string) {
+	if key == "" {
+		return
+	}
+
+	ids, ok := idx[key]
+	if !ok {
+		ids = make(map[string]struct{})
+		idx[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+
+func (idx secondaryIndex) remove(key, id string) {
+	ids, ok := idx[key]
+	if !ok {
+		return
+	}
+
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(idx, key)
+	}
+}
+
+
+// JSONFilePersister stores the catalog in a JSON file with the same format,
+// atomic writes and crash recovery as FileRepository.
+type JSONFilePersister struct {
+	file *FileRepository
+}
+
+
+// NewJSONFilePersister opens filename. Of opts only WithKeyring applies;
+// uniqueness is the MemoryRepository's job.
+func NewJSONFilePersister(filename string, opts ...Option) (*JSONFilePersister, error) {
+	file, err := NewFileRepository(filename, append(opts, WithUniqueConstraints())...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONFilePersister{
+		file: file,
+	}, nil
+}
+
+
+func (p *JSONFilePersister) Load() ([]models.Book, error) {
+	unlock, err := p.file.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return p.file.readBooks()
+}
+
+
+func (p *JSONFilePersister) Save(books []models.Book) error {
+	unlock, err := p.file.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return p.file.writeBooks(books)
+}
@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+
+// Option configures a repository when it is opened.
+type Option func(*options)
+
+
+type options struct {
+	constraints   []UniqueConstraint
+	keyring       *Keyring
+	lockTimeout   time.Duration
+	watchInterval time.Duration
+}
+
+
+func newOptions(opts []Option) options {
+	o := options{
+		constraints: DefaultConstraints,
+		lockTimeout: DefaultLockTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+
+// WithUniqueConstraints replaces DefaultConstraints. Calling it with no
+// constraints turns uniqueness checks off.
+func WithUniqueConstraints(constraints ...UniqueConstraint) Option {
+	return func(o *options) {
+		o.constraints = constraints
+	}
+}
+
+
+// WithKeyring encrypts the data file with AES-GCM. Plain files are
+// encrypted when opened; files written with an older key of the keyring
+// are re-encrypted with the current key on the next write.
+func WithKeyring(keyring *Keyring) Option {
+	return func(o *options) {
+		o.keyring = keyring
+	}
+}
+
+
+// WithLockTimeout sets how long to wait for another process to release
+// the data file before failing with ErrLockTimeout.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = timeout
+	}
+}
+
+
+// WithWatchInterval makes a FileRepository poll its file for external
+// edits every interval. Close stops the polling.
+func WithWatchInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.watchInterval = interval
+	}
+}
+
+
+// dsnOptions turns DSN query options into repository options. unique takes
+// a comma-separated list of constraint names, or none. keyFile and keyEnv
+// name a file or environment variable holding the encryption keyring.
+// lockTimeout and watch are durations such as 5s.
+func dsnOptions(values url.Values) ([]Option, error) {
+	var opts []Option
+
+	if values.Has("unique") {
+		var constraints []UniqueConstraint
+		for _, name := range strings.Split(values.Get("unique"), ",") {
+			switch strings.TrimSpace(name) {
+			case UniqueISBN.Name:
+				constraints = append(constraints, UniqueISBN)
+			case UniqueTitleAuthor.Name:
+				constraints = append(constraints, UniqueTitleAuthor)
+			case "none", "":
+			default:
+				return nil, fmt.Errorf("unknown unique constraint %q", name)
+			}
+		}
+		opts = append(opts, WithUniqueConstraints(constraints...))
+	}
+
+	if values.Has("lockTimeout") {
+		timeout, err := time.ParseDuration(values.Get("lockTimeout"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid lockTimeout: %w", err)
+		}
+		opts = append(opts, WithLockTimeout(timeout))
+	}
+
+	if values.Has("watch") {
+		interval, err := time.ParseDuration(values.Get("watch"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid watch interval: %w", err)
+		}
+		opts = append(opts, WithWatchInterval(interval))
+	}
+
+	switch {
+	case values.Has("keyFile") && values.Has("keyEnv"):
+		return nil, fmt.Errorf("keyFile and keyEnv cannot be combined")
+	case values.Has("keyFile"):
+		keyring, err := LoadKeyringFile(values.Get("keyFile"))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithKeyring(keyring))
+	case values.Has("keyEnv"):
+		keyring, err := KeyringFromEnv(values.Get("keyEnv"))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithKeyring(keyring))
+	}
+
+	return opts, nil
+}
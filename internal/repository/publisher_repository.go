@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"crud-in-go-lang/internal/models"
+)
+
+
+// PublisherRepository stores the publishers books refer to by
+// PublisherID.
+type PublisherRepository = RecordRepository[models.Publisher]
+
+
+// FilePublisherRepository keeps publishers in memory and, unless it was
+// created with NewMemoryPublisherRepository, in a JSON file.
+type FilePublisherRepository struct {
+	*recordRepository[models.Publisher]
+}
+
+
+// NewFilePublisherRepository loads the publishers stored in filename.
+func NewFilePublisherRepository(filename string) (*FilePublisherRepository, error) {
+	records, err := openRecordRepository("publisher", filename, func(publisher *models.Publisher) *string {
+		return &publisher.PublisherID
+	}, errPublisherNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FilePublisherRepository{records}, nil
+}
+
+
+// NewMemoryPublisherRepository returns a publisher repository that is
+// not persisted.
+func NewMemoryPublisherRepository() *FilePublisherRepository {
+	repo, _ := NewFilePublisherRepository("")
+	return repo
+}
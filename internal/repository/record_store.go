@@ -0,0 +1,298 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+
+// recordStore keeps an ordered set of records of a small entity, such as
+// authors, in memory. Given a filename it rewrites them there as a JSON
+// array after every change, encrypted if it has a keyring, and a change
+// that cannot be written is undone.
+type recordStore[T any] struct {
+	kind     string
+	filename string
+	keyring  *Keyring
+	id       func(*T) *string
+	records  []T
+	index    map[string]int
+	mutex    sync.RWMutex
+}
+
+
+// newRecordStore loads the records kept in filename, which may not exist
+// yet. An empty filename keeps the records in memory only. id points at the
+// ID field of a record. Given a keyring, a plain file is encrypted when it
+// is opened.
+func newRecordStore[T any](kind, filename string, keyring *Keyring, id func(*T) *string) (*recordStore[T], error) {
+	s := &recordStore[T]{
+		kind:     kind,
+		filename: filename,
+		keyring:  keyring,
+		id:       id,
+	}
+
+	var plain bool
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("error reading %s data: %w", kind, err)
+		case len(data) > 0:
+			_, encrypted := parseEncrypted(data)
+			plain = !encrypted
+			if data, err = unseal(keyring, data); err != nil {
+				return nil, fmt.Errorf("error reading %s data: %w", kind, err)
+			}
+			if err := json.Unmarshal(data, &s.records); err != nil {
+				return nil, fmt.Errorf("error parsing %s data: %w", kind, err)
+			}
+		}
+	}
+
+	s.reindex()
+	if plain && keyring != nil {
+		if err := s.replace(s.records); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+
+func (s *recordStore[T]) reindex() {
+	s.index = make(map[string]int, len(s.records))
+	for i := range s.records {
+		s.index[*s.id(&s.records[i])] = i
+	}
+}
+
+
+// list returns the window of records selected by limit and offset. A
+// negative limit selects everything from offset on.
+func (s *recordStore[T]) list(limit, offset int) []T {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	start := offset
+	if start < 0 {
+		start = 0
+	}
+	if start >= len(s.records) {
+		return []T{}
+	}
+
+	end := len(s.records)
+	if limit >= 0 && limit < end-start {
+		end = start + limit
+	}
+
+	records := make([]T, end-start)
+	copy(records, s.records[start:end])
+	return records
+}
+
+
+func (s *recordStore[T]) get(id string) (T, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	i, ok := s.index[id]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return s.records[i], true
+}
+
+
+func (s *recordStore[T]) count() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.records)
+}
+
+
+// create stores record, generating an ID if it has none.
+func (s *recordStore[T]) create(record T) (T, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := s.id(&record)
+	if *id == "" {
+		*id = uuid.New().String()
+	}
+	if _, exists := s.index[*id]; exists {
+		return record, fmt.Errorf("%s with ID %s already exists", s.kind, *id)
+	}
+
+	records := append(append([]T(nil), s.records...), record)
+	return record, s.replace(records)
+}
+
+
+// update replaces the record with the given ID and reports whether it
+// existed.
+func (s *recordStore[T]) update(id string, record T) (T, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i, ok := s.index[id]
+	if !ok {
+		return record, false, nil
+	}
+	*s.id(&record) = id
+
+	records := append([]T(nil), s.records...)
+	records[i] = record
+	return record, true, s.replace(records)
+}
+
+
+// delete removes the record with the given ID and reports whether it
+// existed.
+func (s *recordStore[T]) delete(id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i, ok := s.index[id]
+	if !ok {
+		return false, nil
+	}
+
+	records := append(append([]T(nil), s.records[:i]...), s.records[i+1:]...)
+	return true, s.replace(records)
+}
+
+
+// replace writes records and only then makes them current.
+func (s *recordStore[T]) replace(records []T) error {
+	if s.filename != "" {
+		if err := os.MkdirAll(filepath.Dir(s.filename), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error serializing %s data: %w", s.kind, err)
+		}
+		if s.keyring != nil {
+			if data, err = s.keyring.seal(data); err != nil {
+				return err
+			}
+		}
+		if err := writeFileAtomic(s.filename, data, false); err != nil {
+			return fmt.Errorf("error writing %s data: %w", s.kind, err)
+		}
+	}
+
+	s.records = records
+	s.reindex()
+	return nil
+}
+
+
+// RecordRepository stores the records of a small entity, such as authors,
+// that books and stock refer to by ID.
+type RecordRepository[T any] interface {
+
+	// GetAll returns records in the order they were created. A negative
+	// limit returns every record from offset on.
+	GetAll(limit, offset int) ([]T, error)
+
+
+	GetByID(id string) (*T, error)
+
+
+	Create(record T) (*T, error)
+
+
+	Update(id string, record T) (*T, error)
+
+
+	Delete(id string) error
+
+
+	Count() (int, error)
+}
+
+
+// recordRepository implements RecordRepository over a recordStore,
+// reporting missing records with notFound.
+type recordRepository[T any] struct {
+	store    *recordStore[T]
+	notFound func(id string) error
+}
+
+
+// openRecordRepository loads the records stored in filename, which is empty
+// for records kept in memory only.
+func openRecordRepository[T any](kind, filename string, id func(*T) *string, notFound func(string) error) (*recordRepository[T], error) {
+	store, err := newRecordStore(kind, filename, nil, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordRepository[T]{store: store, notFound: notFound}, nil
+}
+
+
+func (r *recordRepository[T]) GetAll(limit, offset int) ([]T, error) {
+	return r.store.list(limit, offset), nil
+}
+
+
+func (r *recordRepository[T]) GetByID(id string) (*T, error) {
+	record, ok := r.store.get(id)
+	if !ok {
+		return nil, r.notFound(id)
+	}
+	return &record, nil
+}
+
+
+func (r *recordRepository[T]) Create(record T) (*T, error) {
+	created, err := r.store.create(record)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+
+func (r *recordRepository[T]) Update(id string, record T) (*T, error) {
+	updated, ok, err := r.store.update(id, record)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, r.notFound(id)
+	}
+	return &updated, nil
+}
+
+
+func (r *recordRepository[T]) Delete(id string) error {
+	ok, err := r.store.delete(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return r.notFound(id)
+	}
+	return nil
+}
+
+
+func (r *recordRepository[T]) Count() (int, error) {
+	return r.store.count(), nil
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"crud-in-go-lang/internal/models"
+)
+
+
+// ReservationRepository stores the reservations that are still active.
+// Confirmed, released and expired reservations are deleted; their effect
+// on stock is recorded in the StockLedger.
+type ReservationRepository interface {
+
+	// List returns the reservations of a book, oldest first, or those of
+	// every book for an empty bookID.
+	List(bookID string) ([]models.Reservation, error)
+
+
+	GetByID(id string) (*models.Reservation, error)
+
+
+	Create(reservation models.Reservation) (*models.Reservation, error)
+
+
+	Delete(id string) error
+}
+
+
+// FileReservationRepository keeps reservations in memory and, unless it was
+// created with NewMemoryReservationRepository, in a JSON file.
+type FileReservationRepository struct {
+	store *recordStore[models.Reservation]
+}
+
+
+// NewFileReservationRepository loads the reservations stored in filename,
+// encrypted given WithKeyring.
+func NewFileReservationRepository(filename string, opts ...Option) (*FileReservationRepository, error) {
+	store, err := newRecordStore("reservation", filename, newOptions(opts).keyring, func(reservation *models.Reservation) *string {
+		return &reservation.ReservationID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileReservationRepository{store: store}, nil
+}
+
+
+// NewMemoryReservationRepository returns a reservation repository that is
+// not persisted.
+func NewMemoryReservationRepository() *FileReservationRepository {
+	repo, _ := NewFileReservationRepository("")
+	return repo
+}
+
+
+func (r *FileReservationRepository) List(bookID string) ([]models.Reservation, error) {
+	all := r.store.list(-1, 0)
+	if bookID == "" {
+		return all, nil
+	}
+
+	reservations := []models.Reservation{}
+	for _, reservation := range all {
+		if reservation.BookID == bookID {
+			reservations = append(reservations, reservation)
+		}
+	}
+	return reservations, nil
+}
+
+
+func (r *FileReservationRepository) GetByID(id string) (*models.Reservation, error) {
+	reservation, ok := r.store.get(id)
+	if !ok {
+		return nil, errReservationNotFound(id)
+	}
+	return &reservation, nil
+}
+
+
+func (r *FileReservationRepository) Create(reservation models.Reservation) (*models.Reservation, error) {
+	created, err := r.store.create(reservation)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+
+func (r *FileReservationRepository) Delete(id string) error {
+	ok, err := r.store.delete(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errReservationNotFound(id)
+	}
+	return nil
+}
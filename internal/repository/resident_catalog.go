@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+// residentCatalog implements BookRepository on top of a catalog that lives
+// in memory for the lifetime of the repository. The embedding repository
+// supplies save, which must make a change durable before it is committed,
+// and optionally committed, which is told about every committed change.
+type residentCatalog struct {
+	books     *catalog
+	mutex     sync.RWMutex
+	save      func(touched map[string]*models.Book) error
+	committed func(touched map[string]*models.Book)
+}
+
+
+func (r *residentCatalog) GetAll(params models.PaginationParams) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.books.page(params), nil
+}
+
+
+func (r *residentCatalog) GetByID(id string) (*models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	book, ok := r.books.getLive(id)
+	if !ok {
+		return nil, errBookNotFound(id)
+	}
+
+	return &book, nil
+}
+
+
+func (r *residentCatalog) GetByISBN(isbn string) (*models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	book, ok := r.books.getByISBN(isbn)
+	if !ok {
+		return nil, errISBNNotFound(isbn)
+	}
+
+	return &book, nil
+}
+
+
+func (r *residentCatalog) Create(book models.Book) (*models.Book, error) {
+	var created models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		created, err = c.create(book)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+
+func (r *residentCatalog) Update(id string, book models.Book) (*models.Book, error) {
+	var updated models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		updated, err = c.update(id, book)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+
+func (r *residentCatalog) Delete(id string, expectedVersion int) (*models.Book, error) {
+	var deleted models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		deleted, err = c.delete(id, expectedVersion, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &deleted, nil
+}
+
+
+func (r *residentCatalog) Search(query string) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.books.search(query), nil
+}
+
+
+func (r *residentCatalog) Count() (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.books.count(), nil
+}
+
+
+func (r *residentCatalog) CountMatching(filter models.BookFilter) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.books.countMatching(filter), nil
+}
+
+
+func (r *residentCatalog) GetDeleted(params models.PaginationParams) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return paginate(r.books.trashed(), params), nil
+}
+
+
+func (r *residentCatalog) Restore(id string) (*models.Book, error) {
+	var restored models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		restored, err = c.restore(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &restored, nil
+}
+
+
+func (r *residentCatalog) Purge(id string) error {
+	return r.mutate(func(c *catalog) error {
+		return c.purge(id)
+	})
+}
+
+
+func (r *residentCatalog) PurgeDeletedBefore(cutoff time.Time) (int, error) {
+	var purged []string
+	err := r.mutate(func(c *catalog) error {
+		purged = c.purgeBefore(cutoff)
+		return nil
+	})
+	return len(purged), err
+}
+
+
+func (r *residentCatalog) Apply(ops []models.BatchOp) ([]models.Book, error) {
+	var results []models.Book
+	err := r.mutate(func(c *catalog) (err error) {
+		results, err = c.apply(ops, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+
+func (r *residentCatalog) Snapshot() ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.books.list(), nil
+}
+
+
+func (r *residentCatalog) ReplaceAll(books []models.Book) error {
+	return r.mutate(func(c *catalog) error {
+		c.replace(books)
+		return nil
+	})
+}
+
+
+// ApplyChanges stores replicated book states as they are.
+func (r *residentCatalog) ApplyChanges(changes []models.Change) error {
+	return r.mutate(func(c *catalog) error {
+		c.applyChanges(changes)
+		return nil
+	})
+}
+
+
+// mutate applies fn to the catalog and persists the result through save.
+// If either step fails every book fn touched is rolled back.
+func (r *residentCatalog) mutate(fn func(c *catalog) error) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.books.begin()
+	if err := fn(r.books); err != nil {
+		r.books.rollback()
+		return err
+	}
+
+	if len(r.books.undo) > 0 {
+		if err := r.save(r.books.undo); err != nil {
+			r.books.rollback()
+			return err
+		}
+	}
+
+	touched := r.books.commit()
+	if r.committed != nil && len(touched) > 0 {
+		r.committed(touched)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+// RevisionRepository stores the append-only change history of books.
+type RevisionRepository interface {
+
+	// Append stores rev as the next revision of its book and returns it
+	// with Rev filled in.
+	Append(rev models.Revision) (*models.Revision, error)
+
+
+	// List returns every revision of a book, oldest first.
+	List(bookID string) ([]models.Revision, error)
+
+
+	Get(bookID string, rev int) (*models.Revision, error)
+
+
+	// StatesAt returns the state of every book with recorded history as it
+	// was at t, keyed by BookID. A nil state means the book did not exist.
+	StatesAt(t time.Time) (map[string]*models.Book, error)
+}
+
+
+// FileRevisionRepository keeps revisions in memory and appends each one to
+// a JSON lines file, so history survives restarts and is never rewritten.
+type FileRevisionRepository struct {
+	file    *os.File
+	keyring *Keyring
+	byBook  map[string][]models.Revision
+	mutex   sync.RWMutex
+}
+
+
+// NewFileRevisionRepository loads the history stored in filename. Given
+// WithKeyring, every revision is encrypted, and plain ones already in the
+// file are encrypted when it is opened.
+func NewFileRevisionRepository(filename string, opts ...Option) (*FileRevisionRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	r := NewMemoryRevisionRepository()
+	r.keyring = newOptions(opts).keyring
+
+	_, err := replayJSONLines(filename, r.keyring, func(rev models.Revision, line int) error {
+		r.byBook[rev.BookID] = append(r.byBook[rev.BookID], rev)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if r.keyring != nil {
+		if err := encryptJSONLines(filename, r.keyring); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open revision log: %w", err)
+	}
+	r.file = file
+
+	return r, nil
+}
+
+
+// NewMemoryRevisionRepository returns a history that is not persisted.
+func NewMemoryRevisionRepository() *FileRevisionRepository {
+	return &FileRevisionRepository{
+		byBook: make(map[string][]models.Revision),
+	}
+}
+
+
+func (r *FileRevisionRepository) Append(rev models.Revision) (*models.Revision, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rev.Rev = len(r.byBook[rev.BookID]) + 1
+	rev.Before = cloneBook(rev.Before)
+	rev.After = cloneBook(rev.After)
+
+	if r.file != nil {
+		if err := appendJSONLines(r.file, r.keyring, rev); err != nil {
+			return nil, err
+		}
+	}
+
+	r.byBook[rev.BookID] = append(r.byBook[rev.BookID], rev)
+	return &rev, nil
+}
+
+
+func (r *FileRevisionRepository) List(bookID string) ([]models.Revision, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	revisions := make([]models.Revision, len(r.byBook[bookID]))
+	copy(revisions, r.byBook[bookID])
+	return revisions, nil
+}
+
+
+func (r *FileRevisionRepository) Get(bookID string, rev int) (*models.Revision, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	revisions := r.byBook[bookID]
+	if rev < 1 || rev > len(revisions) {
+		return nil, fmt.Errorf("%w: book %s has no revision %d", ErrRevisionNotFound, bookID, rev)
+	}
+
+	revision := revisions[rev-1]
+	return &revision, nil
+}
+
+
+// StatesAt takes, for each book, the After state of the last revision made
+// at or before t. A book whose first revision is later than t is reported in
+// the Before state of that revision, which is nil if it was created then.
+func (r *FileRevisionRepository) StatesAt(t time.Time) (map[string]*models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	states := make(map[string]*models.Book, len(r.byBook))
+	for bookID, revisions := range r.byBook {
+		state := revisions[0].Before
+		for _, rev := range revisions {
+			if rev.Timestamp.After(t) {
+				break
+			}
+			state = rev.After
+		}
+		states[bookID] = state
+	}
+
+	return states, nil
+}
+
+
+func (r *FileRevisionRepository) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+
+// cloneBook copies book along with the values it points to, so changes
+// made through either copy do not show in the other.
+func cloneBook(book *models.Book) *models.Book {
+	if book == nil {
+		return nil
+	}
+
+	clone := *book
+	if book.DeletedAt != nil {
+		deletedAt := *book.DeletedAt
+		clone.DeletedAt = &deletedAt
+	}
+	clone.Contributors = append([]models.Contributor(nil), book.Contributors...)
+	return &clone
+}
@@ -0,0 +1,1212 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+
+	"github.com/google/uuid"
+)
+
+
+const (
+	// DefaultShards is the number of shard files a new sharded catalog is
+	// spread over.
+	DefaultShards = 1024
+
+	shardManifestVersion = 1
+
+	shardManifestFile = "manifest.json"
+	shardJournalFile  = "manifest.journal"
+	shardPendingFile  = "pending.json"
+	shardReplaceDir   = "replace"
+	shardDir          = "shards"
+)
+
+
+func init() {
+	Register("sharded", DriverFunc(openShardedDSN))
+}
+
+
+// shardManifest is the compacted manifest: how many shards the catalog is
+// spread over and the order of its books, trashed ones included.
+type shardManifest struct {
+	FormatVersion int      `json:"formatVersion"`
+	Shards        int      `json:"shards"`
+	Order         []string `json:"order"`
+}
+
+
+// manifestRecord is one line of the manifest journal. Reset empties the
+// order before Add is applied. Replaying a record twice is harmless.
+type manifestRecord struct {
+	Reset  bool     `json:"reset,omitempty"`
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+
+// shardCommit is everything one write changes: the new contents of each
+// shard it touched and the change to the manifest, if any. A commit that
+// spans more than one file is first written to pending.json and replayed
+// from there after a crash.
+type shardCommit struct {
+	Shards   map[int][]models.Book `json:"shards"`
+	Manifest *manifestRecord       `json:"manifest,omitempty"`
+}
+
+
+// ShardedRepository spreads the catalog over many small shard files in a
+// directory, picking a book's shard by hashing its BookID. Only the
+// manifest, which records the catalog order, and the unique index are kept
+// in memory; books are read from their shard when needed, so updating a
+// book rewrites a single shard. Search and filtered listings scan every
+// shard in parallel.
+type ShardedRepository struct {
+	dir          string
+	shards       int
+	constraints  []UniqueConstraint
+	compactAfter int
+
+	mutex   sync.RWMutex
+	order   []string // "" marks a removed book until the next compaction
+	pos     map[string]int
+	trashed map[string]bool
+	unique  *uniqueIndex
+	journal *os.File
+	records int
+
+	// unfinished is set when a commit failed after writing pending.json,
+	// leaving its shards half written.
+	unfinished bool
+}
+
+
+// NewShardedRepository opens the sharded catalog in dir. shards sets the
+// number of shards of a new catalog; an existing one keeps its own, and
+// zero or less means DefaultShards or whatever dir already uses.
+func NewShardedRepository(dir string, shards int, opts ...Option) (*ShardedRepository, error) {
+	options := newOptions(opts)
+	if options.keyring != nil {
+		return nil, fmt.Errorf("sharded repository does not support encryption")
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, shardDir), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	r := &ShardedRepository{
+		dir:          dir,
+		constraints:  options.constraints,
+		compactAfter: DefaultCompactAfter,
+		pos:          make(map[string]int),
+	}
+
+	if err := r.finishReplace(); err != nil {
+		return nil, err
+	}
+	if err := r.loadManifest(shards); err != nil {
+		return nil, err
+	}
+	if err := r.recoverPending(); err != nil {
+		r.journal.Close()
+		return nil, err
+	}
+	if err := r.buildIndexes(); err != nil {
+		r.journal.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+
+// openShardedDSN handles sharded://path/to/dir?shards=N.
+func openShardedDSN(dsn string) (BookRepository, error) {
+	path, values, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("sharded DSN %q has no path", dsn)
+	}
+
+	shards := 0
+	if value := values.Get("shards"); value != "" {
+		shards, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("sharded DSN %q: invalid shards: %w", dsn, err)
+		}
+	}
+
+	opts, err := dsnOptions(values)
+	if err != nil {
+		return nil, fmt.Errorf("sharded DSN %q: %w", dsn, err)
+	}
+
+	repo, err := NewShardedRepository(path, shards, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+
+func (r *ShardedRepository) GetAll(params models.PaginationParams) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if params.Filter.IsEmpty() {
+		return r.load(paginateIDs(r.ids(false), params))
+	}
+
+	books, err := r.scan(func(books []models.Book) []models.Book {
+		return filterBooks(liveBooks(books), params.Filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paginate(books, params), nil
+}
+
+
+func (r *ShardedRepository) GetByID(id string) (*models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if _, ok := r.pos[id]; !ok || r.trashed[id] {
+		return nil, errBookNotFound(id)
+	}
+
+	books, err := r.load([]string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	return &books[0], nil
+}
+
+
+func (r *ShardedRepository) GetByISBN(isbn string) (*models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	key := NormalizeISBN(isbn)
+	if key == "" {
+		return nil, errISBNNotFound(isbn)
+	}
+
+	if owner, found, indexed := r.unique.lookup(UniqueISBN.Name, key); indexed {
+		if !found || r.trashed[owner] {
+			return nil, errISBNNotFound(isbn)
+		}
+		books, err := r.load([]string{owner})
+		if err != nil {
+			return nil, err
+		}
+		return &books[0], nil
+	}
+
+	books, err := r.scan(func(books []models.Book) []models.Book {
+		var matches []models.Book
+		for _, book := range liveBooks(books) {
+			if NormalizeISBN(book.ISBN) == key {
+				matches = append(matches, book)
+			}
+		}
+		return matches
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, errISBNNotFound(isbn)
+	}
+
+	return &books[0], nil
+}
+
+
+func (r *ShardedRepository) Create(book models.Book) (*models.Book, error) {
+	if book.BookID == "" {
+		book.BookID = uuid.New().String()
+	}
+
+	var created models.Book
+	err := r.change([]string{book.BookID}, []models.Book{book}, func(c *catalog) (err error) {
+		created, err = c.create(book)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+
+func (r *ShardedRepository) Update(id string, book models.Book) (*models.Book, error) {
+	var updated models.Book
+	err := r.change([]string{id}, []models.Book{book}, func(c *catalog) (err error) {
+		updated, err = c.update(id, book)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+
+func (r *ShardedRepository) Delete(id string, expectedVersion int) (*models.Book, error) {
+	var deleted models.Book
+	err := r.change([]string{id}, nil, func(c *catalog) (err error) {
+		deleted, err = c.delete(id, expectedVersion, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &deleted, nil
+}
+
+
+func (r *ShardedRepository) Search(query string) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.scan(func(books []models.Book) []models.Book {
+		return searchBooks(liveBooks(books), query)
+	})
+}
+
+
+func (r *ShardedRepository) Count() (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.pos) - len(r.trashed), nil
+}
+
+
+func (r *ShardedRepository) CountMatching(filter models.BookFilter) (int, error) {
+	if filter.IsEmpty() {
+		return r.Count()
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	books, err := r.scan(func(books []models.Book) []models.Book {
+		return filterBooks(liveBooks(books), filter)
+	})
+	return len(books), err
+}
+
+
+func (r *ShardedRepository) GetDeleted(params models.PaginationParams) ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.load(paginateIDs(r.ids(true), params))
+}
+
+
+func (r *ShardedRepository) Restore(id string) (*models.Book, error) {
+	var restored models.Book
+	err := r.change([]string{id}, nil, func(c *catalog) (err error) {
+		restored, err = c.restore(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &restored, nil
+}
+
+
+func (r *ShardedRepository) Purge(id string) error {
+	return r.change([]string{id}, nil, func(c *catalog) error {
+		return c.purge(id)
+	})
+}
+
+
+func (r *ShardedRepository) PurgeDeletedBefore(cutoff time.Time) (int, error) {
+	r.mutex.RLock()
+	trashed := r.ids(true)
+	r.mutex.RUnlock()
+
+	var purged []string
+	err := r.change(trashed, nil, func(c *catalog) error {
+		purged = c.purgeBefore(cutoff)
+		return nil
+	})
+	return len(purged), err
+}
+
+
+// Apply runs the batch against the books it names and commits every shard
+// it touched together, through pending.json, so it takes effect entirely
+// or not at all.
+func (r *ShardedRepository) Apply(ops []models.BatchOp) ([]models.Book, error) {
+	if err := validateBatch(ops); err != nil {
+		return nil, err
+	}
+
+	// New books need their ID up front to know which shard they go to.
+	ops = append([]models.BatchOp(nil), ops...)
+	ids := make([]string, 0, len(ops))
+	var books []models.Book
+	for i := range ops {
+		switch ops[i].Action {
+		case models.ActionCreate:
+			if ops[i].Book.BookID == "" {
+				ops[i].Book.BookID = uuid.New().String()
+			}
+			ids = append(ids, ops[i].Book.BookID)
+			books = append(books, ops[i].Book)
+		case models.ActionUpdate:
+			ids = append(ids, ops[i].BookID)
+			books = append(books, ops[i].Book)
+		default:
+			ids = append(ids, ops[i].BookID)
+		}
+	}
+
+	var results []models.Book
+	err := r.change(ids, books, func(c *catalog) (err error) {
+		results, err = c.apply(ops, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+
+// ApplyChanges stores replicated book states as they are, committing
+// every shard they touch together.
+func (r *ShardedRepository) ApplyChanges(changes []models.Change) error {
+	ids := make([]string, 0, len(changes))
+	var books []models.Book
+	for _, change := range changes {
+		ids = append(ids, change.BookID)
+		if change.Book != nil {
+			books = append(books, *change.Book)
+		}
+	}
+
+	return r.change(ids, books, func(c *catalog) error {
+		c.applyChanges(changes)
+		return nil
+	})
+}
+
+
+func (r *ShardedRepository) Snapshot() ([]models.Book, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.scan(func(books []models.Book) []models.Book {
+		return books
+	})
+}
+
+
+// ReplaceAll writes the new catalog shard by shard into replace/, away from
+// the live one, and swaps it in once replace/manifest.json marks it
+// complete. Only one shard's books are encoded at a time, so replacing even
+// millions of books never holds a second copy of the catalog.
+func (r *ShardedRepository) ReplaceAll(books []models.Book) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.finishPending(); err != nil {
+		return err
+	}
+
+	staging := filepath.Join(r.dir, shardReplaceDir)
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("error clearing staged catalog: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(staging, shardDir), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	order := make([]string, len(books))
+	byShard := make([][]int, r.shards)
+	for i, book := range books {
+		order[i] = book.BookID
+		s := r.shardOf(book.BookID)
+		byShard[s] = append(byShard[s], i)
+	}
+
+	for s, indexes := range byShard {
+		if len(indexes) == 0 {
+			continue
+		}
+
+		shard := make([]models.Book, len(indexes))
+		for j, i := range indexes {
+			shard[j] = books[i]
+		}
+		data, err := encodeBooks(shard)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(staging, shardDir, shardName(s)), data, false); err != nil {
+			return fmt.Errorf("error writing shard %d: %w", s, err)
+		}
+	}
+
+	data, err := json.MarshalIndent(shardManifest{
+		FormatVersion: shardManifestVersion,
+		Shards:        r.shards,
+		Order:         order,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing manifest: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(staging, shardManifestFile), data, false); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+
+	if err := r.finishReplace(); err != nil {
+		return err
+	}
+
+	r.applyManifest(manifestRecord{Reset: true, Add: order})
+	r.records = 0
+	r.unique = newUniqueIndex(r.constraints, books)
+	r.trashed = make(map[string]bool)
+	for _, book := range books {
+		if book.DeletedAt != nil {
+			r.trashed[book.BookID] = true
+		}
+	}
+
+	return nil
+}
+
+
+// Compact folds the manifest journal into manifest.json.
+func (r *ShardedRepository) Compact() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.compact()
+}
+
+
+// Close compacts the manifest and releases the journal file.
+func (r *ShardedRepository) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.compact(); err != nil {
+		return err
+	}
+
+	return r.journal.Close()
+}
+
+
+// change loads the books with the given IDs into a working catalog, runs fn
+// against it and commits every book fn touched. seeds are the books fn may
+// store, whose unique keys are looked up in the full index beforehand.
+func (r *ShardedRepository) change(ids []string, seeds []models.Book, fn func(c *catalog) error) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.finishPending(); err != nil {
+		return err
+	}
+
+	shardBooks, err := r.readShards(r.shardsOf(ids))
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	var working []models.Book
+	for _, books := range shardBooks {
+		for _, book := range books {
+			if wanted[book.BookID] {
+				working = append(working, book)
+			}
+		}
+	}
+
+	c := newCatalog(working, r.constraints)
+	for _, book := range seeds {
+		c.unique.seed(r.unique, book)
+	}
+
+	c.begin()
+	if err := fn(c); err != nil {
+		return err
+	}
+	touched := c.commit()
+	if len(touched) == 0 {
+		return nil
+	}
+
+	commit := shardCommit{Shards: make(map[int][]models.Book)}
+	record := manifestRecord{}
+	for id, original := range touched {
+		s := r.shardOf(id)
+		book, exists := c.get(id)
+		if exists {
+			shardBooks[s] = putBook(shardBooks[s], book)
+		} else {
+			shardBooks[s] = dropBook(shardBooks[s], id)
+		}
+		commit.Shards[s] = shardBooks[s]
+
+		if original != nil && !exists {
+			record.Remove = append(record.Remove, id)
+		}
+	}
+	// New books join the manifest in the order they were created.
+	for _, book := range c.books {
+		if original, ok := touched[book.BookID]; ok && original == nil {
+			record.Add = append(record.Add, book.BookID)
+		}
+	}
+	if len(record.Add) > 0 || len(record.Remove) > 0 {
+		commit.Manifest = &record
+	}
+
+	if err := r.commit(commit); err != nil {
+		return err
+	}
+
+	for id, original := range touched {
+		if original != nil {
+			r.unique.remove(*original)
+		}
+		delete(r.trashed, id)
+	}
+	for id := range touched {
+		if book, ok := c.get(id); ok {
+			r.unique.add(book)
+			if book.DeletedAt != nil {
+				r.trashed[id] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+
+// commit writes every shard of commit and its manifest change, going
+// through pending.json when that takes more than one file. If that fails
+// part way, pending.json stays behind and the commit is finished before the
+// next write, as it would be after a crash.
+func (r *ShardedRepository) commit(commit shardCommit) error {
+	files := len(commit.Shards)
+	if commit.Manifest != nil {
+		files++
+	}
+
+	pending := filepath.Join(r.dir, shardPendingFile)
+	if files > 1 {
+		data, err := json.Marshal(commit)
+		if err != nil {
+			return fmt.Errorf("error serializing commit: %w", err)
+		}
+		if err := writeFileAtomic(pending, data, false); err != nil {
+			return fmt.Errorf("error writing pending commit: %w", err)
+		}
+	}
+
+	if err := r.persist(commit); err != nil {
+		r.unfinished = files > 1
+		return err
+	}
+
+	if files > 1 {
+		if err := os.Remove(pending); err != nil {
+			r.unfinished = true
+			return fmt.Errorf("error removing pending commit: %w", err)
+		}
+	}
+
+	if commit.Manifest != nil && r.records >= r.compactAfter {
+		if err := r.compact(); err != nil {
+			// The manifest journal already holds the change.
+			log.Printf("repository: manifest compaction failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+
+func (r *ShardedRepository) persist(commit shardCommit) error {
+	for s, books := range commit.Shards {
+		if err := r.writeShard(s, books); err != nil {
+			return err
+		}
+	}
+
+	if commit.Manifest != nil {
+		if err := appendJSONLines(r.journal, nil, *commit.Manifest); err != nil {
+			return err
+		}
+		r.records++
+		r.applyManifest(*commit.Manifest)
+	}
+
+	return nil
+}
+
+
+// recoverPending finishes a commit interrupted by a crash.
+func (r *ShardedRepository) recoverPending() error {
+	pending := filepath.Join(r.dir, shardPendingFile)
+	data, err := os.ReadFile(pending)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading pending commit: %w", err)
+	}
+
+	var commit shardCommit
+	if err := json.Unmarshal(data, &commit); err != nil {
+		return fmt.Errorf("error parsing pending commit: %w", err)
+	}
+
+	if err := r.persist(commit); err != nil {
+		return err
+	}
+	if err := os.Remove(pending); err != nil {
+		return fmt.Errorf("error removing pending commit: %w", err)
+	}
+	log.Printf("repository: finished interrupted commit of %d shards in %s", len(commit.Shards), r.dir)
+
+	return nil
+}
+
+
+// finishPending finishes a commit that failed part way before anything else
+// is written, so no write builds on its half-written shards or replaces its
+// pending.json. The indexes never saw that commit and are rebuilt. Writes
+// fail for as long as the commit cannot be finished.
+func (r *ShardedRepository) finishPending() error {
+	if !r.unfinished {
+		return nil
+	}
+
+	if err := r.recoverPending(); err != nil {
+		return fmt.Errorf("unfinished commit in %s: %w", r.dir, err)
+	}
+	if err := r.buildIndexes(); err != nil {
+		return err
+	}
+
+	r.unfinished = false
+	return nil
+}
+
+
+// finishReplace swaps a catalog staged by ReplaceAll in for the live one.
+// Every step can be repeated, so a swap cut short by a crash is finished
+// when the catalog is next opened. A staged catalog without its manifest
+// was never complete and is dropped.
+func (r *ShardedRepository) finishReplace() error {
+	staging := filepath.Join(r.dir, shardReplaceDir)
+	live := filepath.Join(r.dir, shardDir)
+	old := live + ".old"
+
+	_, err := os.Stat(filepath.Join(staging, shardManifestFile))
+	switch {
+	case err == nil:
+		if _, err := os.Stat(filepath.Join(staging, shardDir)); err == nil {
+			if err := os.RemoveAll(old); err != nil {
+				return fmt.Errorf("error removing old shards: %w", err)
+			}
+			if err := os.Rename(live, old); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error moving old shards aside: %w", err)
+			}
+			if err := os.Rename(filepath.Join(staging, shardDir), live); err != nil {
+				return fmt.Errorf("error moving new shards in: %w", err)
+			}
+		}
+
+		// The journal only holds changes to the old catalog.
+		if err := os.Truncate(filepath.Join(r.dir, shardJournalFile), 0); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error truncating manifest journal: %w", err)
+		}
+		if err := os.Rename(filepath.Join(staging, shardManifestFile), filepath.Join(r.dir, shardManifestFile)); err != nil {
+			return fmt.Errorf("error moving new manifest in: %w", err)
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("error reading staged manifest: %w", err)
+	}
+
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("error removing staged catalog: %w", err)
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("error removing old shards: %w", err)
+	}
+
+	return nil
+}
+
+
+// loadManifest reads manifest.json, creating it for a new catalog, replays
+// the manifest journal and opens it for appending.
+func (r *ShardedRepository) loadManifest(shards int) error {
+	data, err := os.ReadFile(filepath.Join(r.dir, shardManifestFile))
+	switch {
+	case os.IsNotExist(err):
+		r.shards = shards
+		if r.shards <= 0 {
+			r.shards = DefaultShards
+		}
+		if err := r.writeManifest(); err != nil {
+			return err
+		}
+	case err != nil:
+		return fmt.Errorf("error reading manifest: %w", err)
+	default:
+		var manifest shardManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("error parsing manifest: %w", err)
+		}
+		if manifest.Shards <= 0 {
+			return fmt.Errorf("manifest in %s has no shard count", r.dir)
+		}
+		if shards > 0 && shards != manifest.Shards {
+			return fmt.Errorf("catalog in %s has %d shards, not %d", r.dir, manifest.Shards, shards)
+		}
+		r.shards = manifest.Shards
+		r.applyManifest(manifestRecord{Reset: true, Add: manifest.Order})
+	}
+
+	journalName := filepath.Join(r.dir, shardJournalFile)
+	records, err := replayJSONLines(journalName, nil, func(record manifestRecord, line int) error {
+		r.applyManifest(record)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.records = records
+
+	journal, err := os.OpenFile(journalName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest journal: %w", err)
+	}
+	r.journal = journal
+
+	return nil
+}
+
+
+func (r *ShardedRepository) applyManifest(record manifestRecord) {
+	if record.Reset {
+		r.order = nil
+		r.pos = make(map[string]int, len(record.Add))
+	}
+
+	for _, id := range record.Remove {
+		if i, ok := r.pos[id]; ok {
+			r.order[i] = ""
+			delete(r.pos, id)
+		}
+	}
+
+	for _, id := range record.Add {
+		if _, ok := r.pos[id]; !ok {
+			r.pos[id] = len(r.order)
+			r.order = append(r.order, id)
+		}
+	}
+}
+
+
+// compact writes the manifest without removed books and empties the
+// journal. Replaying the old journal over the new manifest is harmless, so
+// a crash in between loses nothing.
+func (r *ShardedRepository) compact() error {
+	r.applyManifest(manifestRecord{Reset: true, Add: r.allIDs()})
+
+	if err := r.writeManifest(); err != nil {
+		return err
+	}
+
+	if r.journal != nil {
+		if err := r.journal.Truncate(0); err != nil {
+			return fmt.Errorf("error truncating manifest journal: %w", err)
+		}
+		if err := r.journal.Sync(); err != nil {
+			return fmt.Errorf("error syncing manifest journal: %w", err)
+		}
+	}
+
+	r.records = 0
+	return nil
+}
+
+
+func (r *ShardedRepository) writeManifest() error {
+	data, err := json.MarshalIndent(shardManifest{
+		FormatVersion: shardManifestVersion,
+		Shards:        r.shards,
+		Order:         r.allIDs(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing manifest: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(r.dir, shardManifestFile), data, false); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+
+	return nil
+}
+
+
+// buildIndexes scans every shard to find the trashed books and fill the
+// unique index. Keys are added in catalog order, so the first of any
+// duplicates keeps the key as it would in a single file.
+func (r *ShardedRepository) buildIndexes() error {
+	type entry struct {
+		pos     int
+		id      string
+		keys    []string
+		deleted bool
+	}
+
+	r.unique = newUniqueIndex(r.constraints, nil)
+	r.trashed = make(map[string]bool)
+
+	entries := make([][]entry, r.shards)
+	err := r.eachShard(r.allShards(), func(s int, books []models.Book) error {
+		for _, book := range books {
+			entries[s] = append(entries[s], entry{
+				pos:     r.pos[book.BookID],
+				id:      book.BookID,
+				keys:    r.unique.keys(book),
+				deleted: book.DeletedAt != nil,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var all []entry
+	for _, list := range entries {
+		all = append(all, list...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].pos < all[j].pos })
+
+	for _, e := range all {
+		r.unique.addKeys(e.id, e.keys)
+		if e.deleted {
+			r.trashed[e.id] = true
+		}
+	}
+
+	return nil
+}
+
+
+// ids lists either the live books or those in the trash, in manifest
+// order.
+func (r *ShardedRepository) ids(trashed bool) []string {
+	var ids []string
+	for _, id := range r.order {
+		if id != "" && r.trashed[id] == trashed {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+
+func (r *ShardedRepository) allIDs() []string {
+	ids := make([]string, 0, len(r.pos))
+	for _, id := range r.order {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+
+// load reads the books with the given IDs, in that order.
+func (r *ShardedRepository) load(ids []string) ([]models.Book, error) {
+	shardBooks, err := r.readShards(r.shardsOf(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]models.Book, len(ids))
+	for _, books := range shardBooks {
+		for _, book := range books {
+			byID[book.BookID] = book
+		}
+	}
+
+	books := make([]models.Book, 0, len(ids))
+	for _, id := range ids {
+		book, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("book %s is in the manifest but not in shard %d", id, r.shardOf(id))
+		}
+		books = append(books, book)
+	}
+
+	return books, nil
+}
+
+
+// scan runs fn over every shard in parallel and returns what it kept in
+// manifest order.
+func (r *ShardedRepository) scan(fn func(books []models.Book) []models.Book) ([]models.Book, error) {
+	kept := make([][]models.Book, r.shards)
+	err := r.eachShard(r.allShards(), func(s int, books []models.Book) error {
+		kept[s] = fn(books)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	books := []models.Book{}
+	for _, list := range kept {
+		books = append(books, list...)
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return r.pos[books[i].BookID] < r.pos[books[j].BookID]
+	})
+
+	return books, nil
+}
+
+
+func (r *ShardedRepository) readShards(shards []int) (map[int][]models.Book, error) {
+	read := make([][]models.Book, r.shards)
+	err := r.eachShard(shards, func(s int, books []models.Book) error {
+		read[s] = books
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shardBooks := make(map[int][]models.Book, len(shards))
+	for _, s := range shards {
+		shardBooks[s] = read[s]
+	}
+	return shardBooks, nil
+}
+
+
+// eachShard reads the given shards from a pool of workers and hands each
+// to fn, which may be called concurrently for different shards.
+func (r *ShardedRepository) eachShard(shards []int, fn func(s int, books []models.Book) error) error {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(shards) {
+		workers = len(shards)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				books, err := r.readShard(s)
+				if err == nil {
+					err = fn(s, books)
+				}
+				if err != nil {
+					once.Do(func() { firstErr = err })
+				}
+			}
+		}()
+	}
+
+	for _, s := range shards {
+		jobs <- s
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+
+// readShard returns the books of a shard that the manifest knows about.
+// Anything else was left by a commit that never reached the manifest.
+func (r *ShardedRepository) readShard(s int) ([]models.Book, error) {
+	data, err := os.ReadFile(r.shardFile(s))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading shard %d: %w", s, err)
+	}
+
+	books, err := decodeBooks(data)
+	if err != nil {
+		return nil, fmt.Errorf("shard %d: %w", s, err)
+	}
+
+	known := books[:0]
+	for _, book := range books {
+		if _, ok := r.pos[book.BookID]; ok {
+			known = append(known, book)
+		}
+	}
+	return known, nil
+}
+
+
+func (r *ShardedRepository) writeShard(s int, books []models.Book) error {
+	if len(books) == 0 {
+		if err := os.Remove(r.shardFile(s)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing shard %d: %w", s, err)
+		}
+		return nil
+	}
+
+	data, err := encodeBooks(books)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(r.shardFile(s), data, false); err != nil {
+		return fmt.Errorf("error writing shard %d: %w", s, err)
+	}
+
+	return nil
+}
+
+
+func (r *ShardedRepository) shardFile(s int) string {
+	return filepath.Join(r.dir, shardDir, shardName(s))
+}
+
+
+func shardName(s int) string {
+	return fmt.Sprintf("%04x.json", s)
+}
+
+
+func (r *ShardedRepository) shardOf(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(r.shards))
+}
+
+
+// shardsOf returns the distinct shards holding the given IDs.
+func (r *ShardedRepository) shardsOf(ids []string) []int {
+	seen := make(map[int]bool)
+	var shards []int
+	for _, id := range ids {
+		if s := r.shardOf(id); !seen[s] {
+			seen[s] = true
+			shards = append(shards, s)
+		}
+	}
+	return shards
+}
+
+
+func (r *ShardedRepository) allShards() []int {
+	shards := make([]int, r.shards)
+	for s := range shards {
+		shards[s] = s
+	}
+	return shards
+}
+
+
+// putBook replaces the book with the same ID in books, or appends it.
+func putBook(books []models.Book, book models.Book) []models.Book {
+	for i := range books {
+		if books[i].BookID == book.BookID {
+			books[i] = book
+			return books
+		}
+	}
+	return append(books, book)
+}
+
+
+func dropBook(books []models.Book, id string) []models.Book {
+	for i := range books {
+		if books[i].BookID == id {
+			return append(books[:i:i], books[i+1:]...)
+		}
+	}
+	return books
+}
+
+
+func liveBooks(books []models.Book) []models.Book {
+	live := make([]models.Book, 0, len(books))
+	for _, book := range books {
+		if book.DeletedAt == nil {
+			live = append(live, book)
+		}
+	}
+	return live
+}
+
+
+// paginateIDs is paginate for a list of IDs.
+func paginateIDs(ids []string, params models.PaginationParams) []string {
+	start := params.Offset
+	if start < 0 {
+		start = 0
+	}
+	if start >= len(ids) {
+		return nil
+	}
+
+	end := len(ids)
+	if params.Limit >= 0 && params.Limit < end-start {
+		end = start + params.Limit
+	}
+
+	return ids[start:end]
+}
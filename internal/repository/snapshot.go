@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+const (
+	snapshotBooksFile    = "books.json"
+	snapshotManifestFile = "manifest.json"
+	snapshotIDLayout     = "20060102T150405.000000000Z"
+
+	// DefaultSnapshotKeep is how many snapshots are retained unless told
+	// otherwise.
+	DefaultSnapshotKeep = 10
+)
+
+
+// SnapshotManifest describes a snapshot. It is written after the books, so
+// a snapshot directory without a manifest is incomplete and ignored.
+type SnapshotManifest struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Books     int       `json:"books"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+}
+
+
+// SnapshotManager writes point-in-time copies of a repository to
+// dir/<id>/books.json and restores them. Only the newest keep snapshots are
+// retained.
+type SnapshotManager struct {
+	repo    BookRepository
+	dir     string
+	keep    int
+	keyring *Keyring
+	mutex   sync.Mutex
+}
+
+
+// encrypted is implemented by repositories that encrypt their data file.
+type encrypted interface {
+	encryptionKeyring() *Keyring
+}
+
+
+// NewSnapshotManager snapshots repo into dir. Snapshots are encrypted with
+// the keyring given WithKeyring, or else with the repository's own keyring,
+// so a backup is never less protected than the catalog it copies.
+func NewSnapshotManager(repo BookRepository, dir string, keep int, opts ...Option) (*SnapshotManager, error) {
+	if keep <= 0 {
+		keep = DefaultSnapshotKeep
+	}
+
+	keyring := newOptions(opts).keyring
+	if keyring == nil {
+		keyring = EncryptionKeyring(repo)
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	return &SnapshotManager{
+		repo:    repo,
+		dir:     dir,
+		keep:    keep,
+		keyring: keyring,
+	}, nil
+}
+
+
+// Create takes a snapshot of the repository and prunes old ones.
+func (m *SnapshotManager) Create() (*SnapshotManifest, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	books, err := m.repo.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := encodeFile(m.keyring, books)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	now := time.Now().UTC()
+	manifest := SnapshotManifest{
+		ID:        m.newID(now),
+		CreatedAt: now,
+		Books:     len(books),
+		Size:      int64(len(data)),
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+
+	dir := filepath.Join(m.dir, manifest.ID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, snapshotBooksFile), data, false); err != nil {
+		return nil, fmt.Errorf("error writing snapshot: %w", err)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error serializing snapshot manifest: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, snapshotManifestFile), manifestData, false); err != nil {
+		return nil, fmt.Errorf("error writing snapshot manifest: %w", err)
+	}
+
+	if err := m.prune(); err != nil {
+		// The snapshot itself is complete, so pruning is retried next time.
+		log.Printf("repository: failed to prune snapshots: %v", err)
+	}
+
+	return &manifest, nil
+}
+
+
+// List returns the complete snapshots, newest first.
+func (m *SnapshotManager) List() ([]SnapshotManifest, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot directory: %w", err)
+	}
+
+	manifests := []SnapshotManifest{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		manifest, err := m.manifest(entry.Name())
+		if err != nil {
+			continue
+		}
+		manifests = append(manifests, *manifest)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].ID > manifests[j].ID
+	})
+
+	return manifests, nil
+}
+
+
+// Load reads a snapshot back after checking it against its manifest.
+func (m *SnapshotManager) Load(id string) ([]models.Book, *SnapshotManifest, error) {
+	manifest, err := m.manifest(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.dir, id, snapshotBooksFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading snapshot %s: %w", id, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != manifest.Size || hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		return nil, nil, fmt.Errorf("%w: %s does not match its checksum", ErrSnapshotCorrupt, id)
+	}
+
+	books, err := decodeFile(m.keyring, data)
+	if errors.Is(err, ErrDecryption) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %v", ErrSnapshotCorrupt, id, err)
+	}
+
+	return books, manifest, nil
+}
+
+
+// Restore replaces the repository's contents with a snapshot.
+func (m *SnapshotManager) Restore(id string) (*SnapshotManifest, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	books, manifest, err := m.Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.repo.ReplaceAll(books); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+
+func (m *SnapshotManager) manifest(id string) (*SnapshotManifest, error) {
+	// IDs come from URLs and the command line; never let one leave dir.
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.dir, id, snapshotManifestFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot manifest: %w", err)
+	}
+
+	var manifest SnapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s has an unreadable manifest", ErrSnapshotCorrupt, id)
+	}
+
+	return &manifest, nil
+}
+
+
+// newID names a snapshot after its creation time, so IDs sort by age.
+func (m *SnapshotManager) newID(now time.Time) string {
+	id := now.Format(snapshotIDLayout)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(m.dir, id)); os.IsNotExist(err) {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", now.Format(snapshotIDLayout), n)
+	}
+}
+
+
+// prune removes every complete snapshot beyond the newest keep.
+func (m *SnapshotManager) prune() error {
+	manifests, err := m.List()
+	if err != nil {
+		return err
+	}
+
+	for i := m.keep; i < len(manifests); i++ {
+		if err := os.RemoveAll(filepath.Join(m.dir, manifests[i].ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,1075 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+
+	"github.com/google/uuid"
+)
+
+
+func init() {
+	Register("sql", DriverFunc(openSQLDSN))
+}
+
+
+// sqlMigration is one step of the versioned books schema. Migrations are
+// applied in order, each in its own transaction, and recorded in
+// schema_migrations so every step runs exactly once per database. backfill,
+// if set, runs after the statements for data that SQL alone cannot derive.
+type sqlMigration struct {
+	version     int
+	description string
+	statements  []string
+	backfill    func(r *SQLRepository, tx *sql.Tx) error
+}
+
+
+var sqlMigrations = []sqlMigration{
+	{
+		version:     1,
+		description: "create books table",
+		statements: []string{
+			`CREATE TABLE books (
+				seq              INTEGER      NOT NULL,
+				book_id          VARCHAR(64)  NOT NULL PRIMARY KEY,
+				author_id        VARCHAR(64)  NOT NULL DEFAULT '',
+				publisher_id     VARCHAR(64)  NOT NULL DEFAULT '',
+				title            VARCHAR(512) NOT NULL DEFAULT '',
+				publication_date VARCHAR(32)  NOT NULL DEFAULT '',
+				isbn             VARCHAR(32)  NOT NULL DEFAULT '',
+				pages            INTEGER      NOT NULL DEFAULT 0,
+				genre            VARCHAR(128) NOT NULL DEFAULT '',
+				description      TEXT         NOT NULL,
+				price            REAL         NOT NULL DEFAULT 0,
+				quantity         INTEGER      NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE book_seq (last_seq INTEGER NOT NULL)`,
+			`INSERT INTO book_seq (last_seq) VALUES (0)`,
+		},
+	},
+	{
+		version:     2,
+		description: "index books for ordering and filtering",
+		statements: []string{
+			`CREATE UNIQUE INDEX books_seq_idx ON books (seq)`,
+			`CREATE INDEX books_author_idx ON books (author_id)`,
+			`CREATE INDEX books_publisher_idx ON books (publisher_id)`,
+			`CREATE INDEX books_genre_idx ON books (genre)`,
+		},
+	},
+	{
+		version:     3,
+		description: "add book versions",
+		statements: []string{
+			`ALTER TABLE books ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+		},
+	},
+	{
+		version:     4,
+		description: "add soft delete",
+		statements: []string{
+			`ALTER TABLE books ADD COLUMN deleted_at VARCHAR(40) NULL`,
+			`CREATE INDEX books_deleted_idx ON books (deleted_at)`,
+		},
+	},
+	{
+		version:     5,
+		description: "add normalized ISBN key",
+		statements: []string{
+			`ALTER TABLE books ADD COLUMN isbn_key VARCHAR(32) NOT NULL DEFAULT ''`,
+			`CREATE INDEX books_isbn_key_idx ON books (isbn_key)`,
+			`ALTER TABLE books ADD COLUMN isbn_unique VARCHAR(32) NULL`,
+			`CREATE UNIQUE INDEX books_isbn_unique_key ON books (isbn_unique)`,
+		},
+		backfill: backfillISBNKeys,
+	},
+	{
+		// A book's contributors are read from the JSON in books.contributors;
+		// book_contributors repeats them one per row so they can be
+		// filtered on.
+		version:     6,
+		description: "add book contributors",
+		statements: []string{
+			`ALTER TABLE books ADD COLUMN contributors TEXT NULL`,
+			`CREATE TABLE book_contributors (
+				book_id        VARCHAR(64) NOT NULL,
+				ordinal        INTEGER     NOT NULL,
+				contributor_id VARCHAR(64) NOT NULL,
+				role           VARCHAR(32) NOT NULL,
+				PRIMARY KEY (book_id, ordinal)
+			)`,
+			`CREATE INDEX book_contributors_contributor_idx ON book_contributors (contributor_id)`,
+		},
+	},
+}
+
+
+const bookColumns = `book_id, author_id, publisher_id, title, publication_date, isbn, pages, genre, description, price, quantity, version, deleted_at, contributors`
+
+
+// sqlTimeLayout is a fixed-width UTC timestamp, so stored times compare
+// correctly as strings in every dialect.
+const sqlTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+
+// SQLRepository stores books in a relational database through database/sql.
+// Filtering, pagination and search run in SQL rather than in Go. The driver
+// itself must be linked into the binary by the caller.
+type SQLRepository struct {
+	db          *sql.DB
+	dollar      bool
+	constraints []UniqueConstraint
+}
+
+
+// OpenSQLRepository opens a database with the named database/sql driver and
+// brings its schema up to date.
+func OpenSQLRepository(driverName, dsn string, opts ...Option) (*SQLRepository, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	repo, err := NewSQLRepository(db, driverName, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+
+// openSQLDSN handles sql+<driver>://<driver dsn>. Everything after :// is
+// handed to the database/sql driver untouched, e.g.
+// sql+postgres://host=localhost dbname=books sslmode=disable.
+func openSQLDSN(dsn string) (BookRepository, error) {
+	scheme, driverDSN, ok := strings.Cut(dsn, "://")
+	_, driverName, hasDriver := strings.Cut(scheme, "+")
+	if !ok || !hasDriver || driverName == "" {
+		return nil, fmt.Errorf("sql DSN %q must look like sql+<driver>://<dsn>", dsn)
+	}
+
+	repo, err := OpenSQLRepository(driverName, driverDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+
+// NewSQLRepository wraps an open database and applies any pending schema
+// migrations. driverName decides the placeholder style: PostgreSQL drivers
+// get $1-style placeholders, everything else gets ?.
+func NewSQLRepository(db *sql.DB, driverName string, opts ...Option) (*SQLRepository, error) {
+	r := &SQLRepository{
+		db:          db,
+		dollar:      driverName == "postgres" || driverName == "pgx",
+		constraints: newOptions(opts).constraints,
+	}
+
+	if err := r.migrate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+
+func (r *SQLRepository) GetAll(params models.PaginationParams) ([]models.Book, error) {
+	where, args := sqlFilter(params.Filter)
+
+	query, args := sqlPage(`SELECT `+bookColumns+` FROM books`+where+` ORDER BY seq`, args, params)
+	return r.queryBooks(query, args...)
+}
+
+
+func (r *SQLRepository) GetByID(id string) (*models.Book, error) {
+	row := r.db.QueryRow(r.rebind(`SELECT `+bookColumns+` FROM books WHERE book_id = ? AND deleted_at IS NULL`), id)
+
+	book, err := scanBook(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errBookNotFound(id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading book data: %w", err)
+	}
+
+	return &book, nil
+}
+
+
+func (r *SQLRepository) GetByISBN(isbn string) (*models.Book, error) {
+	key := NormalizeISBN(isbn)
+	if key == "" {
+		return nil, errISBNNotFound(isbn)
+	}
+
+	row := r.db.QueryRow(r.rebind(`SELECT `+bookColumns+` FROM books WHERE isbn_key = ? AND deleted_at IS NULL ORDER BY seq LIMIT 1`), key)
+
+	book, err := scanBook(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errISBNNotFound(isbn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading book data: %w", err)
+	}
+
+	return &book, nil
+}
+
+
+func (r *SQLRepository) Create(book models.Book) (*models.Book, error) {
+	var created models.Book
+	err := r.inTx(func(tx *sql.Tx) (err error) {
+		created, err = r.create(tx, book)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+
+func (r *SQLRepository) Update(id string, book models.Book) (*models.Book, error) {
+	var updated models.Book
+	err := r.inTx(func(tx *sql.Tx) (err error) {
+		updated, err = r.update(tx, id, book)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+
+func (r *SQLRepository) Delete(id string, expectedVersion int) (*models.Book, error) {
+	var deleted models.Book
+	err := r.inTx(func(tx *sql.Tx) (err error) {
+		deleted, err = r.delete(tx, id, expectedVersion, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &deleted, nil
+}
+
+
+// Apply runs the whole batch in one database transaction.
+func (r *SQLRepository) Apply(ops []models.BatchOp) ([]models.Book, error) {
+	if err := validateBatch(ops); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	results := make([]models.Book, 0, len(ops))
+	err := r.inTx(func(tx *sql.Tx) error {
+		for i, op := range ops {
+			var book models.Book
+			var err error
+
+			switch op.Action {
+			case models.ActionCreate:
+				book, err = r.create(tx, op.Book)
+			case models.ActionUpdate:
+				op.Book.Version = op.ExpectedVersion
+				book, err = r.update(tx, op.BookID, op.Book)
+			case models.ActionDelete:
+				book, err = r.delete(tx, op.BookID, op.ExpectedVersion, now)
+			}
+			if err != nil {
+				return batchError(i, op, err)
+			}
+
+			results = append(results, book)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+
+func (r *SQLRepository) Snapshot() ([]models.Book, error) {
+	var books []models.Book
+	err := r.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT ` + bookColumns + ` FROM books ORDER BY seq`)
+		if err != nil {
+			return fmt.Errorf("error reading book data: %w", err)
+		}
+		defer rows.Close()
+
+		books = []models.Book{}
+		for rows.Next() {
+			book, err := scanBook(rows)
+			if err != nil {
+				return fmt.Errorf("error reading book data: %w", err)
+			}
+			books = append(books, book)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+
+func (r *SQLRepository) ReplaceAll(books []models.Book) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM book_contributors`); err != nil {
+			return fmt.Errorf("error writing book data: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM books`); err != nil {
+			return fmt.Errorf("error writing book data: %w", err)
+		}
+
+		for i, book := range books {
+			_, err := tx.Exec(r.rebind(`INSERT INTO books (seq, isbn_key, isbn_unique, `+bookColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+				append([]any{i + 1, NormalizeISBN(book.ISBN), r.uniqueISBN(book)}, bookValues(book)...)...)
+			if err != nil {
+				return fmt.Errorf("error writing book %s: %w", book.BookID, err)
+			}
+			if err := r.writeContributors(tx, book); err != nil {
+				return fmt.Errorf("error writing book %s: %w", book.BookID, err)
+			}
+		}
+
+		if _, err := tx.Exec(r.rebind(`UPDATE book_seq SET last_seq = ?`), len(books)); err != nil {
+			return fmt.Errorf("error writing book data: %w", err)
+		}
+		return nil
+	})
+}
+
+
+// ApplyChanges stores replicated book states as they are, in one
+// transaction. Books keep their place in the catalog order.
+func (r *SQLRepository) ApplyChanges(changes []models.Change) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		for _, change := range changes {
+			if err := r.applyChange(tx, change); err != nil {
+				return fmt.Errorf("error writing book %s: %w", change.BookID, err)
+			}
+		}
+		return nil
+	})
+}
+
+
+func (r *SQLRepository) applyChange(tx *sql.Tx, change models.Change) error {
+	if change.Book == nil {
+		if _, err := tx.Exec(r.rebind(`DELETE FROM book_contributors WHERE book_id = ?`), change.BookID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(r.rebind(`DELETE FROM books WHERE book_id = ?`), change.BookID)
+		return err
+	}
+
+	book := *change.Book
+	values := bookValues(book)
+	result, err := tx.Exec(r.rebind(`UPDATE books SET author_id = ?, publisher_id = ?, title = ?, publication_date = ?, isbn = ?,
+		pages = ?, genre = ?, description = ?, price = ?, quantity = ?, version = ?, deleted_at = ?, contributors = ?, isbn_key = ?, isbn_unique = ?
+		WHERE book_id = ?`),
+		append(values[1:], NormalizeISBN(book.ISBN), r.uniqueISBN(book), book.BookID)...)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		seq, err := nextSeq(tx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.rebind(`INSERT INTO books (seq, isbn_key, isbn_unique, `+bookColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			append([]any{seq, NormalizeISBN(book.ISBN), r.uniqueISBN(book)}, bookValues(book)...)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.writeContributors(tx, book)
+}
+
+
+func (r *SQLRepository) create(tx *sql.Tx, book models.Book) (models.Book, error) {
+	// Generate a UUID if not provided
+	if book.BookID == "" {
+		book.BookID = uuid.New().String()
+	}
+
+	var exists int
+	err := tx.QueryRow(r.rebind(`SELECT COUNT(*) FROM books WHERE book_id = ?`), book.BookID).Scan(&exists)
+	if err != nil {
+		return models.Book{}, err
+	}
+	if exists > 0 {
+		return models.Book{}, fmt.Errorf("book with ID %s already exists", book.BookID)
+	}
+	if err := r.checkUnique(tx, book, nil); err != nil {
+		return models.Book{}, err
+	}
+
+	seq, err := nextSeq(tx)
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	book.Version = 1
+	book.DeletedAt = nil
+	_, err = tx.Exec(r.rebind(`INSERT INTO books (seq, isbn_key, isbn_unique, `+bookColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		append([]any{seq, NormalizeISBN(book.ISBN), r.uniqueISBN(book)}, bookValues(book)...)...)
+	if err != nil {
+		return models.Book{}, keyConflict(err, book)
+	}
+	if err := r.writeContributors(tx, book); err != nil {
+		return models.Book{}, err
+	}
+
+	return book, nil
+}
+
+
+func (r *SQLRepository) update(tx *sql.Tx, id string, book models.Book) (models.Book, error) {
+	book.BookID = id
+	book.DeletedAt = nil
+
+	current, err := r.currentVersion(tx, id, book.Version)
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	existing, err := scanBook(tx.QueryRow(r.rebind(`SELECT `+bookColumns+` FROM books WHERE book_id = ?`), id))
+	if err != nil {
+		return models.Book{}, fmt.Errorf("error reading book data: %w", err)
+	}
+	if err := r.checkUnique(tx, book, &existing); err != nil {
+		return models.Book{}, err
+	}
+
+	book.Version = current + 1
+	values := bookValues(book)
+	result, err := tx.Exec(r.rebind(`UPDATE books SET author_id = ?, publisher_id = ?, title = ?, publication_date = ?, isbn = ?,
+		pages = ?, genre = ?, description = ?, price = ?, quantity = ?, version = ?, deleted_at = ?, contributors = ?, isbn_key = ?, isbn_unique = ?
+		WHERE book_id = ? AND version = ?`),
+		append(values[1:], NormalizeISBN(book.ISBN), r.uniqueISBN(book), id, current)...)
+	if err != nil {
+		return models.Book{}, keyConflict(err, book)
+	}
+
+	// The version is compared again in the UPDATE itself, so a writer
+	// that slipped in after the SELECT is still caught.
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return models.Book{}, r.lostRace(tx, id, current)
+	}
+	if err := r.writeContributors(tx, book); err != nil {
+		return models.Book{}, err
+	}
+
+	return book, nil
+}
+
+
+func (r *SQLRepository) delete(tx *sql.Tx, id string, expectedVersion int, now time.Time) (models.Book, error) {
+	current, err := r.currentVersion(tx, id, expectedVersion)
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	result, err := tx.Exec(r.rebind(`UPDATE books SET deleted_at = ?, version = version + 1 WHERE book_id = ? AND version = ?`),
+		now.UTC().Format(sqlTimeLayout), id, current)
+	if err != nil {
+		return models.Book{}, fmt.Errorf("error writing book data: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return models.Book{}, r.lostRace(tx, id, current)
+	}
+
+	return scanBook(tx.QueryRow(r.rebind(`SELECT `+bookColumns+` FROM books WHERE book_id = ?`), id))
+}
+
+
+// nextSeq hands out the next catalog position from book_seq. The UPDATE
+// locks the counter row until tx ends, so concurrent writers never share a
+// seq the way two reads of MAX(seq) could.
+func nextSeq(tx *sql.Tx) (int64, error) {
+	if _, err := tx.Exec(`UPDATE book_seq SET last_seq = last_seq + 1`); err != nil {
+		return 0, err
+	}
+
+	var seq int64
+	err := tx.QueryRow(`SELECT last_seq FROM book_seq`).Scan(&seq)
+	return seq, err
+}
+
+
+// checkUnique enforces the unique constraints inside tx. Candidates are
+// narrowed down in SQL where a column allows it and then compared by key,
+// so custom constraints work too, only more slowly. Trashed books count.
+func (r *SQLRepository) checkUnique(tx *sql.Tx, book models.Book, existing *models.Book) error {
+	for _, constraint := range r.constraints {
+		key := constraint.Key(book)
+		if key == "" || (existing != nil && constraint.Key(*existing) == key) {
+			continue
+		}
+
+		query := `SELECT ` + bookColumns + ` FROM books WHERE book_id <> ?`
+		args := []any{book.BookID}
+		switch constraint.Name {
+		case UniqueISBN.Name:
+			query += ` AND isbn_key = ?`
+			args = append(args, key)
+		case UniqueTitleAuthor.Name:
+			query += ` AND author_id = ?`
+			args = append(args, book.AuthorID)
+		}
+
+		owner, err := r.findKeyOwner(tx, query+` ORDER BY seq`, args, constraint, key)
+		if err != nil {
+			return err
+		}
+		if owner != "" {
+			return &ConflictError{Constraint: constraint.Name, Key: key, BookID: owner}
+		}
+	}
+
+	return nil
+}
+
+
+// uniqueISBN is the isbn_unique value of book: its ISBN key while ISBNs
+// must be unique, NULL otherwise. NULLs never collide, so books without an
+// ISBN stay out of books_isbn_unique_key. The column is kept for trashed
+// books too, since they still hold their ISBN.
+func (r *SQLRepository) uniqueISBN(book models.Book) any {
+	key := NormalizeISBN(book.ISBN)
+	if key == "" || !slices.ContainsFunc(r.constraints, func(c UniqueConstraint) bool { return c.Name == UniqueISBN.Name }) {
+		return nil
+	}
+
+	return key
+}
+
+
+// keyConflict reports a write rejected by books_isbn_unique_key, which a
+// writer racing past checkUnique runs into, as a ConflictError. Drivers
+// word the violation differently, but all of them name the column or the
+// index.
+func keyConflict(err error, book models.Book) error {
+	if !strings.Contains(err.Error(), "isbn_unique") {
+		return err
+	}
+
+	return &ConflictError{Constraint: UniqueISBN.Name, Key: NormalizeISBN(book.ISBN)}
+}
+
+
+// findConflictOwner fills in the book holding the key of a conflict from
+// keyConflict. It runs after the rollback, since some databases refuse any
+// further statement in a transaction that failed.
+func (r *SQLRepository) findConflictOwner(err error) {
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.BookID != "" || conflict.Constraint != UniqueISBN.Name {
+		return
+	}
+
+	row := r.db.QueryRow(r.rebind(`SELECT book_id FROM books WHERE isbn_unique = ?`), conflict.Key)
+	if err := row.Scan(&conflict.BookID); err != nil {
+		log.Printf("repository: failed to look up the owner of ISBN %s: %v", conflict.Key, err)
+	}
+}
+
+
+func (r *SQLRepository) findKeyOwner(tx *sql.Tx, query string, args []any, constraint UniqueConstraint, key string) (string, error) {
+	rows, err := tx.Query(r.rebind(query), args...)
+	if err != nil {
+		return "", fmt.Errorf("error reading book data: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		candidate, err := scanBook(rows)
+		if err != nil {
+			return "", fmt.Errorf("error reading book data: %w", err)
+		}
+		if constraint.Key(candidate) == key {
+			return candidate.BookID, nil
+		}
+	}
+
+	return "", rows.Err()
+}
+
+
+// currentVersion reads the stored version of a live book inside tx and
+// checks it against the caller's expected version, where zero means
+// unconditional.
+func (r *SQLRepository) currentVersion(tx *sql.Tx, id string, expected int) (int, error) {
+	var current int
+	err := tx.QueryRow(r.rebind(`SELECT version FROM books WHERE book_id = ? AND deleted_at IS NULL`), id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errBookNotFound(id)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading book data: %w", err)
+	}
+
+	if expected != 0 && expected != current {
+		return 0, errVersionConflict(id, expected, current)
+	}
+
+	return current, nil
+}
+
+
+// lostRace explains a conditional write that matched no row because
+// another writer changed the book after its version was read as seen: the
+// book is gone, or the conflict names the version it is at now.
+func (r *SQLRepository) lostRace(tx *sql.Tx, id string, seen int) error {
+	var actual int
+	err := tx.QueryRow(r.rebind(`SELECT version FROM books WHERE book_id = ? AND deleted_at IS NULL`), id).Scan(&actual)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errBookNotFound(id)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading book data: %w", err)
+	}
+
+	return errVersionConflict(id, seen, actual)
+}
+
+
+func (r *SQLRepository) Search(query string) ([]models.Book, error) {
+	if query == "" {
+		return r.queryBooks(`SELECT ` + bookColumns + ` FROM books WHERE deleted_at IS NULL ORDER BY seq`)
+	}
+
+	pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+	return r.queryBooks(`SELECT `+bookColumns+` FROM books
+		WHERE deleted_at IS NULL AND (LOWER(title) LIKE ? ESCAPE '!' OR LOWER(description) LIKE ? ESCAPE '!')
+		ORDER BY seq`, pattern, pattern)
+}
+
+
+func (r *SQLRepository) Count() (int, error) {
+	var count int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM books WHERE deleted_at IS NULL`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting books: %w", err)
+	}
+
+	return count, nil
+}
+
+
+func (r *SQLRepository) CountMatching(filter models.BookFilter) (int, error) {
+	where, args := sqlFilter(filter)
+
+	var count int
+	if err := r.db.QueryRow(r.rebind(`SELECT COUNT(*) FROM books`+where), args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting books: %w", err)
+	}
+
+	return count, nil
+}
+
+
+func (r *SQLRepository) GetDeleted(params models.PaginationParams) ([]models.Book, error) {
+	query := `SELECT ` + bookColumns + ` FROM books WHERE deleted_at IS NOT NULL ORDER BY seq`
+	query, args := sqlPage(query, nil, params)
+	return r.queryBooks(query, args...)
+}
+
+
+func (r *SQLRepository) Restore(id string) (*models.Book, error) {
+	result, err := r.db.Exec(r.rebind(`UPDATE books SET deleted_at = NULL, version = version + 1
+		WHERE book_id = ? AND deleted_at IS NOT NULL`), id)
+	if err != nil {
+		return nil, fmt.Errorf("error writing book data: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil, errTrashedBookNotFound(id)
+	}
+
+	return r.GetByID(id)
+}
+
+
+func (r *SQLRepository) Purge(id string) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(r.rebind(`DELETE FROM books WHERE book_id = ? AND deleted_at IS NOT NULL`), id)
+		if err != nil {
+			return fmt.Errorf("error writing book data: %w", err)
+		}
+
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			return errTrashedBookNotFound(id)
+		}
+
+		if _, err := tx.Exec(r.rebind(`DELETE FROM book_contributors WHERE book_id = ?`), id); err != nil {
+			return fmt.Errorf("error writing book data: %w", err)
+		}
+		return nil
+	})
+}
+
+
+func (r *SQLRepository) PurgeDeletedBefore(cutoff time.Time) (int, error) {
+	var purged int64
+	err := r.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(r.rebind(`DELETE FROM book_contributors WHERE book_id IN
+			(SELECT book_id FROM books WHERE deleted_at IS NOT NULL AND deleted_at < ?)`), cutoff.UTC().Format(sqlTimeLayout))
+		if err != nil {
+			return fmt.Errorf("error writing book data: %w", err)
+		}
+
+		result, err := tx.Exec(r.rebind(`DELETE FROM books WHERE deleted_at IS NOT NULL AND deleted_at < ?`),
+			cutoff.UTC().Format(sqlTimeLayout))
+		if err != nil {
+			return fmt.Errorf("error writing book data: %w", err)
+		}
+
+		purged, err = result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error counting purged books: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(purged), nil
+}
+
+
+// Close closes the underlying database.
+func (r *SQLRepository) Close() error {
+	return r.db.Close()
+}
+
+
+// SchemaVersion reports the newest migration applied to the database.
+func (r *SQLRepository) SchemaVersion() (int, error) {
+	var version int
+	err := r.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+
+func (r *SQLRepository) migrate() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER      NOT NULL PRIMARY KEY,
+		description VARCHAR(255) NOT NULL,
+		applied_at  VARCHAR(64)  NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	current, err := r.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, migration := range sqlMigrations {
+		if migration.version <= current {
+			continue
+		}
+
+		err := r.inTx(func(tx *sql.Tx) error {
+			for _, statement := range migration.statements {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			if migration.backfill != nil {
+				if err := migration.backfill(r, tx); err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.Exec(r.rebind(`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`),
+				migration.version, migration.description, time.Now().UTC().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", migration.version, migration.description, err)
+		}
+	}
+
+	return nil
+}
+
+
+func (r *SQLRepository) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		r.findConflictOwner(err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+
+func (r *SQLRepository) queryBooks(query string, args ...any) ([]models.Book, error) {
+	rows, err := r.db.Query(r.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading book data: %w", err)
+	}
+	defer rows.Close()
+
+	books := []models.Book{}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading book data: %w", err)
+		}
+		books = append(books, book)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading book data: %w", err)
+	}
+
+	return books, nil
+}
+
+
+// rebind rewrites ? placeholders as $1, $2, ... for PostgreSQL drivers.
+func (r *SQLRepository) rebind(query string) string {
+	if !r.dollar {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, ch := range query {
+		if ch == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(ch)
+	}
+
+	return b.String()
+}
+
+
+// sqlPage appends the LIMIT/OFFSET clause selected by params.
+func sqlPage(query string, args []any, params models.PaginationParams) (string, []any) {
+	if params.Limit >= 0 {
+		return query + ` LIMIT ? OFFSET ?`, append(args, params.Limit, max(params.Offset, 0))
+	}
+	if params.Offset > 0 {
+		// Not every dialect accepts OFFSET without LIMIT.
+		return query + ` LIMIT ? OFFSET ?`, append(args, int64(1<<62), params.Offset)
+	}
+	return query, args
+}
+
+
+// sqlFilter builds the WHERE clause selecting live books matching filter.
+func sqlFilter(filter models.BookFilter) (string, []any) {
+	clauses := []string{`deleted_at IS NULL`}
+	var args []any
+
+	if filter.AuthorID != "" {
+		clauses = append(clauses, `author_id = ?`)
+		args = append(args, filter.AuthorID)
+	}
+	if filter.PublisherID != "" {
+		clauses = append(clauses, `publisher_id = ?`)
+		args = append(args, filter.PublisherID)
+	}
+	if filter.Genre != "" {
+		clauses = append(clauses, `LOWER(genre) = ?`)
+		args = append(args, strings.ToLower(filter.Genre))
+	}
+	if filter.ContributorID != "" || filter.Role != "" {
+		clause, clauseArgs := sqlContributorFilter(filter.ContributorID, filter.Role)
+		clauses = append(clauses, clause)
+		args = append(args, clauseArgs...)
+	}
+
+	return ` WHERE ` + strings.Join(clauses, ` AND `), args
+}
+
+
+// sqlContributorFilter matches books crediting id in role, either of which
+// may be empty, with the primary author counting in the author role.
+func sqlContributorFilter(id, role string) (string, []any) {
+	exists := `EXISTS (SELECT 1 FROM book_contributors c WHERE c.book_id = books.book_id`
+	var args []any
+	if id != "" {
+		exists += ` AND c.contributor_id = ?`
+		args = append(args, id)
+	}
+	if role != "" {
+		exists += ` AND LOWER(c.role) = ?`
+		args = append(args, strings.ToLower(role))
+	}
+	exists += `)`
+
+	if role != "" && !strings.EqualFold(role, models.RoleAuthor) {
+		return exists, args
+	}
+	if id == "" {
+		return `(author_id <> '' OR ` + exists + `)`, args
+	}
+	return `(author_id = ? OR ` + exists + `)`, append([]any{id}, args...)
+}
+
+
+// writeContributors replaces the book_contributors rows of book.
+func (r *SQLRepository) writeContributors(tx *sql.Tx, book models.Book) error {
+	if _, err := tx.Exec(r.rebind(`DELETE FROM book_contributors WHERE book_id = ?`), book.BookID); err != nil {
+		return err
+	}
+
+	for i, contributor := range book.Contributors {
+		_, err := tx.Exec(r.rebind(`INSERT INTO book_contributors (book_id, ordinal, contributor_id, role) VALUES (?, ?, ?, ?)`),
+			book.BookID, i, contributor.ContributorID, contributor.Role)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+
+func scanBook(row rowScanner) (models.Book, error) {
+	var book models.Book
+	var deletedAt, contributors sql.NullString
+	err := row.Scan(&book.BookID, &book.AuthorID, &book.PublisherID, &book.Title, &book.PublicationDate,
+		&book.ISBN, &book.Pages, &book.Genre, &book.Description, &book.Price, &book.Quantity, &book.Version, &deletedAt, &contributors)
+	if err != nil {
+		return book, err
+	}
+
+	if contributors.Valid && contributors.String != "" {
+		if err := json.Unmarshal([]byte(contributors.String), &book.Contributors); err != nil {
+			return book, fmt.Errorf("invalid contributors %q: %w", contributors.String, err)
+		}
+	}
+
+	if deletedAt.Valid {
+		t, err := time.Parse(sqlTimeLayout, deletedAt.String)
+		if err != nil {
+			return book, fmt.Errorf("invalid deleted_at %q: %w", deletedAt.String, err)
+		}
+		book.DeletedAt = &t
+	}
+
+	return book, nil
+}
+
+
+// bookValues lists the fields of book in bookColumns order.
+func bookValues(book models.Book) []any {
+	var deletedAt, contributors any
+	if book.DeletedAt != nil {
+		deletedAt = book.DeletedAt.UTC().Format(sqlTimeLayout)
+	}
+	if len(book.Contributors) > 0 {
+		// A slice of plain strings always marshals.
+		data, _ := json.Marshal(book.Contributors)
+		contributors = string(data)
+	}
+
+	return []any{book.BookID, book.AuthorID, book.PublisherID, book.Title, book.PublicationDate,
+		book.ISBN, book.Pages, book.Genre, book.Description, book.Price, book.Quantity, book.Version, deletedAt, contributors}
+}
+
+
+// escapeLike escapes LIKE wildcards with '!', which unlike a backslash means
+// the same thing in every SQL dialect.
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+	return replacer.Replace(s)
+}
+
+
+// backfillISBNKeys fills isbn_key for rows written before migration 5, and
+// isbn_unique for the first book in catalog order holding each key, so
+// duplicates stored before ISBNs were unique do not fail the migration.
+func backfillISBNKeys(r *SQLRepository, tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT book_id, isbn FROM books WHERE isbn <> '' ORDER BY seq`)
+	if err != nil {
+		return err
+	}
+
+	var ids, keys []string
+	for rows.Next() {
+		var id, isbn string
+		if err := rows.Scan(&id, &isbn); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		keys = append(keys, NormalizeISBN(isbn))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	owned := make(map[string]bool)
+	for i, id := range ids {
+		var unique any
+		if !owned[keys[i]] {
+			owned[keys[i]] = true
+			unique = r.uniqueISBN(models.Book{ISBN: keys[i]})
+		}
+		if _, err := tx.Exec(r.rebind(`UPDATE books SET isbn_key = ?, isbn_unique = ? WHERE book_id = ?`), keys[i], unique, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
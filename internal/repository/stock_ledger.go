@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+// StockLedger stores the append-only stock movements of books.
+type StockLedger interface {
+
+	// Append stores movements as the next movements of their books, in a
+	// single write, and returns them with Seq filled in.
+	Append(movements ...models.StockMovement) ([]models.StockMovement, error)
+
+
+	// List returns every movement of a book, oldest first.
+	List(bookID string) ([]models.StockMovement, error)
+
+
+	// AtLocation returns the copies of each book the movements leave at a
+	// location, leaving out books with none.
+	AtLocation(locationID string) (map[string]int, error)
+}
+
+
+// FileStockLedger keeps movements in memory and appends them to a JSON
+// lines file, so the ledger survives restarts and is never rewritten.
+// Several processes can share the file: appends take the same advisory
+// lock as a FileRepository, and movements appended by another process are
+// read in before each append and read.
+type FileStockLedger struct {
+	filename   string
+	file       *os.File
+	keyring    *Keyring
+	offset     int64
+	byBook     map[string][]models.StockMovement
+	byLocation map[string]map[string]int
+	mutex      sync.Mutex
+}
+
+
+// NewFileStockLedger loads the ledger stored in filename. Given
+// WithKeyring, every movement is encrypted, and plain ones already in the
+// file are encrypted when it is opened.
+func NewFileStockLedger(filename string, opts ...Option) (*FileStockLedger, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	fl, err := acquireFileLock(filename, true, DefaultLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer fl.release()
+
+	l := NewMemoryStockLedger()
+	l.filename = filename
+	l.keyring = newOptions(opts).keyring
+
+	_, err = replayJSONLines(filename, l.keyring, func(movement models.StockMovement, line int) error {
+		l.add(movement)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if l.keyring != nil {
+		if err := encryptJSONLines(filename, l.keyring); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stock ledger: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open stock ledger: %w", err)
+	}
+	l.file = file
+	l.offset = info.Size()
+
+	return l, nil
+}
+
+
+// NewMemoryStockLedger returns a ledger that is not persisted.
+func NewMemoryStockLedger() *FileStockLedger {
+	return &FileStockLedger{
+		byBook:     make(map[string][]models.StockMovement),
+		byLocation: make(map[string]map[string]int),
+	}
+}
+
+
+func (l *FileStockLedger) Append(movements ...models.StockMovement) ([]models.StockMovement, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file != nil {
+		fl, err := acquireFileLock(l.filename, true, DefaultLockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		defer fl.release()
+
+		if err := l.catchUp(); err != nil {
+			return nil, err
+		}
+	}
+
+	appended := make([]models.StockMovement, len(movements))
+	next := make(map[string]int)
+	for i, movement := range movements {
+		if _, ok := next[movement.BookID]; !ok {
+			next[movement.BookID] = len(l.byBook[movement.BookID])
+		}
+		next[movement.BookID]++
+		movement.Seq = next[movement.BookID]
+		appended[i] = movement
+	}
+
+	if l.file != nil {
+		if err := appendJSONLines(l.file, l.keyring, appended...); err != nil {
+			return nil, err
+		}
+		info, err := l.file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("error reading stock ledger: %w", err)
+		}
+		l.offset = info.Size()
+	}
+
+	for _, movement := range appended {
+		l.add(movement)
+	}
+	return appended, nil
+}
+
+
+func (l *FileStockLedger) List(bookID string) ([]models.StockMovement, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.refresh(); err != nil {
+		return nil, err
+	}
+
+	movements := make([]models.StockMovement, len(l.byBook[bookID]))
+	copy(movements, l.byBook[bookID])
+	return movements, nil
+}
+
+
+func (l *FileStockLedger) AtLocation(locationID string) (map[string]int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.refresh(); err != nil {
+		return nil, err
+	}
+
+	books := make(map[string]int)
+	for bookID, quantity := range l.byLocation[locationID] {
+		if quantity != 0 {
+			books[bookID] = quantity
+		}
+	}
+	return books, nil
+}
+
+
+func (l *FileStockLedger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+
+// refresh reads in movements another process has appended since the last
+// read or append. The caller holds mutex.
+func (l *FileStockLedger) refresh() error {
+	if l.file == nil {
+		return nil
+	}
+
+	info, err := os.Stat(l.filename)
+	if err != nil {
+		return fmt.Errorf("error reading stock ledger: %w", err)
+	}
+	if info.Size() == l.offset {
+		return nil
+	}
+
+	fl, err := acquireFileLock(l.filename, false, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer fl.release()
+
+	return l.catchUp()
+}
+
+
+// catchUp reads the movements after offset. The caller holds mutex and
+// the lock file.
+func (l *FileStockLedger) catchUp() error {
+	offset, err := readJSONLinesFrom(l.filename, l.keyring, l.offset, l.add)
+	l.offset = offset
+	return err
+}
+
+
+// add indexes a stored movement. The caller holds mutex.
+func (l *FileStockLedger) add(movement models.StockMovement) {
+	l.byBook[movement.BookID] = append(l.byBook[movement.BookID], movement)
+
+	books, ok := l.byLocation[movement.LocationID]
+	if !ok {
+		books = make(map[string]int)
+		l.byLocation[movement.LocationID] = books
+	}
+	books[movement.BookID] += movement.Delta()
+}
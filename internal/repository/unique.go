@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+// UniqueConstraint declares that no two books, trashed ones included, may
+// share the same non-empty Key.
+type UniqueConstraint struct {
+	Name string
+	Key  func(book models.Book) string
+}
+
+
+var (
+	// UniqueISBN keys books by their normalized ISBN, so the ISBN-10 and
+	// ISBN-13 forms of the same number collide.
+	UniqueISBN = UniqueConstraint{
+		Name: "isbn",
+		Key: func(book models.Book) string {
+			return NormalizeISBN(book.ISBN)
+		},
+	}
+
+	// UniqueTitleAuthor keys books by case-insensitive title and AuthorID.
+	UniqueTitleAuthor = UniqueConstraint{
+		Name: "title-author",
+		Key: func(book models.Book) string {
+			title := strings.ToLower(strings.Join(strings.Fields(book.Title), " "))
+			if title == "" || book.AuthorID == "" {
+				return ""
+			}
+			return book.AuthorID + "\x00" + title
+		},
+	}
+)
+
+
+// DefaultConstraints are enforced by repositories that were not given
+// WithUniqueConstraints.
+var DefaultConstraints = []UniqueConstraint{UniqueISBN}
+
+
+// ConflictError reports a write that would break a unique constraint.
+type ConflictError struct {
+	Constraint string
+	Key        string
+	BookID     string // the book already holding Key
+}
+
+
+func (e *ConflictError) Error() string {
+	key := strings.ReplaceAll(e.Key, "\x00", " / ")
+	if e.BookID == "" {
+		return fmt.Sprintf("%s %q is already used by another book", e.Constraint, key)
+	}
+	return fmt.Sprintf("%s %q is already used by book %s", e.Constraint, key, e.BookID)
+}
+
+
+// NormalizeISBN strips an "ISBN" prefix, hyphens and spaces and converts a
+// valid ISBN-10 to its ISBN-13 form. Anything else is returned upper-cased
+// but otherwise unchanged.
+func NormalizeISBN(isbn string) string {
+	isbn = strings.ToUpper(strings.TrimSpace(isbn))
+	isbn = strings.TrimPrefix(isbn, "ISBN")
+	isbn = strings.TrimLeft(isbn, ":")
+	clean := strings.NewReplacer("-", "", " ", "").Replace(isbn)
+
+	if len(clean) == 10 && validISBN10(clean) {
+		digits := "978" + clean[:9]
+		return digits + string(rune('0'+isbn13CheckDigit(digits)))
+	}
+
+	return clean
+}
+
+
+func validISBN10(isbn string) bool {
+	sum := 0
+	for i, r := range isbn {
+		var digit int
+		switch {
+		case r >= '0' && r <= '9':
+			digit = int(r - '0')
+		case r == 'X' && i == 9:
+			digit = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * digit
+	}
+	return sum%11 == 0
+}
+
+
+func isbn13CheckDigit(first12 string) int {
+	sum := 0
+	for i, r := range first12 {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(r-'0')
+	}
+	return (10 - sum%10) % 10
+}
+
+
+// uniqueIndex maps the key of every constraint to the book holding it. If
+// stored data already contains duplicates, the first book keeps the key.
+type uniqueIndex struct {
+	constraints []UniqueConstraint
+	owners      []map[string]string
+}
+
+
+func newUniqueIndex(constraints []UniqueConstraint, books []models.Book) *uniqueIndex {
+	u := &uniqueIndex{
+		constraints: constraints,
+		owners:      make([]map[string]string, len(constraints)),
+	}
+	for i := range constraints {
+		u.owners[i] = make(map[string]string)
+	}
+
+	for _, book := range books {
+		u.add(book)
+	}
+	return u
+}
+
+
+// check reports whether book may be stored. existing is the stored version
+// of the same book, if any; keys it already holds are never a conflict.
+func (u *uniqueIndex) check(book models.Book, existing *models.Book) error {
+	for i, constraint := range u.constraints {
+		key := constraint.Key(book)
+		if key == "" || (existing != nil && constraint.Key(*existing) == key) {
+			continue
+		}
+
+		if owner, ok := u.owners[i][key]; ok && owner != book.BookID {
+			return &ConflictError{Constraint: constraint.Name, Key: key, BookID: owner}
+		}
+	}
+	return nil
+}
+
+
+func (u *uniqueIndex) add(book models.Book) {
+	u.addKeys(book.BookID, u.keys(book))
+}
+
+
+// keys returns the key of book under each constraint, in order.
+func (u *uniqueIndex) keys(book models.Book) []string {
+	keys := make([]string, len(u.constraints))
+	for i, constraint := range u.constraints {
+		keys[i] = constraint.Key(book)
+	}
+	return keys
+}
+
+
+// addKeys makes id the owner of every key, as returned by keys, that is
+// not taken yet.
+func (u *uniqueIndex) addKeys(id string, keys []string) {
+	for i, key := range keys {
+		if _, taken := u.owners[i][key]; key != "" && !taken {
+			u.owners[i][key] = id
+		}
+	}
+}
+
+
+// seed copies from a fuller index the owners of book's keys, so an index
+// over only some of the books still sees every conflict book could cause.
+func (u *uniqueIndex) seed(from *uniqueIndex, book models.Book) {
+	for i, key := range u.keys(book) {
+		if key == "" {
+			continue
+		}
+		if _, taken := u.owners[i][key]; taken {
+			continue
+		}
+		if owner, ok := from.owners[i][key]; ok {
+			u.owners[i][key] = owner
+		}
+	}
+}
+
+
+func (u *uniqueIndex) remove(book models.Book) {
+	for i, constraint := range u.constraints {
+		key := constraint.Key(book)
+		if u.owners[i][key] == book.BookID {
+			delete(u.owners[i], key)
+		}
+	}
+}
+
+
+// lookup returns the book holding key under the named constraint and
+// whether there is one. The last result is false if no constraint of that
+// name is enforced, in which case the index cannot answer.
+func (u *uniqueIndex) lookup(name, key string) (string, bool, bool) {
+	for i, constraint := range u.constraints {
+		if constraint.Name == name {
+			owner, ok := u.owners[i][key]
+			return owner, ok, true
+		}
+	}
+	return "", false, false
+}
@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+// ChangeEvent reports one book that changed in a FileRepository, whether
+// through the repository or by an edit to the file. Before is nil for a
+// new book and After is nil for one removed from the file.
+type ChangeEvent struct {
+	BookID string
+	Before *models.Book
+	After  *models.Book
+}
+
+
+// Subscribe calls fn with every change the repository sees from now on,
+// after the lock on the file is released. It returns a function that
+// cancels the subscription.
+func (r *FileRepository) Subscribe(fn func(ChangeEvent)) func() {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	if r.subscribers == nil {
+		r.subscribers = make(map[int]func(ChangeEvent))
+	}
+	id := r.nextSubscriber
+	r.nextSubscriber++
+	r.subscribers[id] = fn
+
+	return func() {
+		r.cacheMutex.Lock()
+		defer r.cacheMutex.Unlock()
+		delete(r.subscribers, id)
+	}
+}
+
+
+// Close stops the watcher started by WithWatchInterval and waits for a
+// reload in progress to finish.
+func (r *FileRepository) Close() error {
+	r.closeOnce.Do(func() {
+		if r.done != nil {
+			close(r.done)
+		}
+	})
+	r.watching.Wait()
+	return nil
+}
+
+
+// watch polls the file every interval so external edits are picked up,
+// and announced to subscribers, without waiting for the next request.
+func (r *FileRepository) watch(interval time.Duration) {
+	defer r.watching.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.reload()
+		}
+	}
+}
+
+
+func (r *FileRepository) reload() {
+	unlock, err := r.lock(false)
+	if err != nil {
+		log.Printf("repository: failed to reload %s: %v", r.filename, err)
+		return
+	}
+	defer unlock()
+
+	if _, err := r.readBooks(); err != nil {
+		log.Printf("repository: failed to reload %s: %v", r.filename, err)
+	}
+}
+
+
+// lastGood returns the books read before the file was last changed into
+// something that does not parse. The bad version is logged once.
+func (r *FileRepository) lastGood(info os.FileInfo, parseErr error) ([]models.Book, bool) {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	if r.cachedInfo == nil {
+		return nil, false
+	}
+
+	if r.rejectedInfo == nil || !sameFileState(r.rejectedInfo, info) {
+		log.Printf("repository: rejected edit to %s, still serving the last good version: %v", r.filename, parseErr)
+		r.rejectedInfo = info
+	}
+
+	return append([]models.Book(nil), r.cached...), true
+}
+
+
+// setAsideRejected moves a rejected edit out of the way before the file is
+// written and puts the last good version back, so the edit is not lost
+// and the backup generation is still one that parses.
+func (r *FileRepository) setAsideRejected() error {
+	info, err := os.Stat(r.filename)
+	if err != nil {
+		return nil
+	}
+
+	r.cacheMutex.Lock()
+	rejected := r.rejectedInfo != nil && sameFileState(r.rejectedInfo, info)
+	lastGood := r.cached
+	r.rejectedInfo = nil
+	r.cacheMutex.Unlock()
+
+	if !rejected {
+		return nil
+	}
+
+	aside := r.filename + corruptInfix + time.Now().Format("20060102T150405")
+	if err := os.Rename(r.filename, aside); err != nil {
+		return fmt.Errorf("failed to move rejected edit aside: %w", err)
+	}
+	log.Printf("repository: moved rejected edit of %s to %s", r.filename, aside)
+
+	data, err := encodeFile(r.keyring, lastGood)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.filename, data, false); err != nil {
+		return fmt.Errorf("failed to restore last good book data: %w", err)
+	}
+
+	return nil
+}
+
+
+// publish hands the changes collected under the lock to subscribers, one
+// caller at a time so no subscriber sees a book's changes out of order.
+func (r *FileRepository) publish() {
+	r.publishing.Lock()
+	defer r.publishing.Unlock()
+
+	r.cacheMutex.Lock()
+	events := r.pending
+	r.pending = nil
+	subscribers := make([]func(ChangeEvent), 0, len(r.subscribers))
+	for _, fn := range r.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	r.cacheMutex.Unlock()
+
+	for _, event := range events {
+		for _, fn := range subscribers {
+			fn(event)
+		}
+	}
+}
+
+
+// diffBooks lists the books that differ between two versions of the file,
+// in the order they appear in the newer one, followed by removed books.
+func diffBooks(before, after []models.Book) []ChangeEvent {
+	old := make(map[string]models.Book, len(before))
+	for _, book := range before {
+		old[book.BookID] = book
+	}
+
+	var events []ChangeEvent
+	for i := range after {
+		book := after[i]
+		previous, existed := old[book.BookID]
+		delete(old, book.BookID)
+
+		if existed && SameBook(previous, book) {
+			continue
+		}
+		event := ChangeEvent{BookID: book.BookID, After: &book}
+		if existed {
+			event.Before = &previous
+		}
+		events = append(events, event)
+	}
+
+	for i := range before {
+		if book, removed := old[before[i].BookID]; removed {
+			events = append(events, ChangeEvent{BookID: book.BookID, Before: &book})
+		}
+	}
+
+	return events
+}
+
+
+// SameBook compares books by their JSON form, so timestamps read back from
+// a file equal the ones they were written from and in-memory details such
+// as the monotonic clock reading of a DeletedAt are ignored.
+func SameBook(a, b models.Book) bool {
+	left, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	right, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(left) == string(right)
+}
+
+
+func sameFileState(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
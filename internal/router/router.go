@@ -10,18 +10,29 @@ import (
 )
 
 
-func SetupRouter(bookController *controller.BookController) *mux.Router {
+// Routes is implemented by every controller.
+type Routes interface {
+	RegisterRoutes(router *mux.Router)
+}
+
+
+// SetupRouter serves the book, admin and replication endpoints along with
+// those of any further controllers, such as authors.
+func SetupRouter(bookController *controller.BookController, adminController *controller.AdminController, replicationController *controller.ReplicationController, controllers ...Routes) *mux.Router {
 	r := mux.NewRouter()
 
 
 	r.Use(loggingMiddleware)
 	r.Use(corsMiddleware)
+	r.Use(replicationController.RedirectWrites)
 
 
 	bookController.RegisterRoutes(r)
-
-
-	r.HandleFunc("/health", healthCheckHandler).Methods("GET")
+	adminController.RegisterRoutes(r)
+	replicationController.RegisterRoutes(r)
+	for _, c := range controllers {
+		c.RegisterRoutes(r)
+	}
 
 	return r
 }
@@ -56,7 +67,8 @@ func corsMiddleware(next http.Handler) http.Handler {
 
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, X-Actor")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 
 		if r.Method == "OPTIONS" {
@@ -67,11 +79,4 @@ func corsMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(w, r)
 	})
-}
-
-
-func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok"}`))
 }
\ No newline at end of file
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+)
+
+
+// DeletePolicy decides what happens to the books of a record being deleted.
+type DeletePolicy string
+
+
+const (
+	// DeleteRestrict refuses to delete a record that live books still
+	// refer to.
+	DeleteRestrict DeletePolicy = "restrict"
+
+	// DeleteCascade moves the books referring to a record to the trash
+	// along with it.
+	DeleteCascade DeletePolicy = "cascade"
+)
+
+
+// ParseDeletePolicy accepts "restrict" or "cascade". An empty value selects
+// DeleteRestrict.
+func ParseDeletePolicy(value string) (DeletePolicy, error) {
+	switch policy := DeletePolicy(strings.ToLower(value)); policy {
+	case "":
+		return DeleteRestrict, nil
+	case DeleteRestrict, DeleteCascade:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown delete policy %q, want restrict or cascade", value)
+	}
+}
+
+
+// dateLayout is the form of every date field, as in Book.PublicationDate.
+const dateLayout = "2006-01-02"
+
+
+type AuthorService struct {
+	recordService[models.Author]
+	authors repository.AuthorRepository
+	books   *BookService
+	policy  DeletePolicy
+}
+
+
+// NewAuthorService manages authors and the books referring to them. books
+// should have been created WithAuthors(authors) so new books are checked
+// against the same authors.
+func NewAuthorService(authors repository.AuthorRepository, books *BookService, policy DeletePolicy) *AuthorService {
+	if policy == "" {
+		policy = DeleteRestrict
+	}
+
+	return &AuthorService{
+		recordService: recordService[models.Author]{records: authors, prepare: prepareAuthor},
+		authors:       authors,
+		books:         books,
+		policy:        policy,
+	}
+}
+
+
+// Delete removes an author. Live books crediting the author are handled by
+// the delete policy: DeleteRestrict fails with
+// repository.ErrStillReferenced, while DeleteCascade moves the books they
+// are the primary author of to the trash and drops their other credits. It
+// returns how many books were changed.
+func (s *AuthorService) Delete(id string, actor string) (int, error) {
+	if _, err := s.authors.GetByID(id); err != nil {
+		return 0, err
+	}
+
+	cascade := func(book models.Book) models.BatchOp {
+		if book.AuthorID == id {
+			return trashBook(book)
+		}
+
+		var kept []models.Contributor
+		for _, contributor := range book.Contributors {
+			if contributor.ContributorID != id {
+				kept = append(kept, contributor)
+			}
+		}
+		book.Contributors = kept
+		return models.BatchOp{Action: models.ActionUpdate, BookID: book.BookID, Book: book, ExpectedVersion: book.Version}
+	}
+
+	return s.books.deleteReferenced("author "+id, models.BookFilter{ContributorID: id}, s.policy, actor, cascade, func() error {
+		return s.authors.Delete(id)
+	})
+}
+
+
+// GetBooks returns a page of the live books crediting an author in any
+// role along with how many there are in total.
+func (s *AuthorService) GetBooks(id string, limit, offset int) ([]models.Book, int, error) {
+	if _, err := s.authors.GetByID(id); err != nil {
+		return nil, 0, err
+	}
+
+	filter := models.BookFilter{ContributorID: id}
+	books, err := s.books.GetAll(limit, offset, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	count, err := s.books.CountMatching(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return books, count, nil
+}
+
+
+func prepareAuthor(author models.Author) (models.Author, error) {
+	if strings.TrimSpace(author.Name) == "" {
+		return author, fmt.Errorf("%w: author name is required", repository.ErrInvalidRecord)
+	}
+
+	var birth, death time.Time
+	var err error
+	if author.BirthDate != "" {
+		if birth, err = time.Parse(dateLayout, author.BirthDate); err != nil {
+			return author, fmt.Errorf("%w: birthDate must be a date such as 1900-01-31", repository.ErrInvalidRecord)
+		}
+	}
+	if author.DeathDate != "" {
+		if death, err = time.Parse(dateLayout, author.DeathDate); err != nil {
+			return author, fmt.Errorf("%w: deathDate must be a date such as 1900-01-31", repository.ErrInvalidRecord)
+		}
+	}
+	if !birth.IsZero() && !death.IsZero() && death.Before(birth) {
+		return author, fmt.Errorf("%w: deathDate is before birthDate", repository.ErrInvalidRecord)
+	}
+
+	return author, nil
+}
@@ -1,6 +1,10 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"crud-in-go-lang/internal/models"
@@ -9,18 +13,83 @@ import (
 
 
 type BookService struct {
-	repo repository.BookRepository
+	repo      repository.BookRepository
+	revisions repository.RevisionRepository
+	asOf      *repository.AsOfView
+	snapshots *repository.SnapshotManager
+
+	// authors and publishers, when set, are checked for the author and
+	// publisher of every book written.
+	authors    repository.AuthorRepository
+	publishers repository.PublisherRepository
+
+	// stock, when set, owns Quantity: writes keep the stored value and only
+	// a StockService changes it.
+	stock repository.StockLedger
+
+	// writeMutex serializes writes so the before and after states recorded
+	// in a revision always belong to the same change.
+	writeMutex sync.Mutex
+}
+
+
+// Option configures optional collaborators of a BookService.
+type Option func(*BookService)
+
+
+// WithRevisions stores the change history in revisions instead of the
+// default in-memory history.
+func WithRevisions(revisions repository.RevisionRepository) Option {
+	return func(s *BookService) {
+		s.revisions = revisions
+	}
 }
 
 
-func NewBookService(repo repository.BookRepository) *BookService {
-	return &BookService{
-		repo: repo,
+// WithAuthors makes writes refuse books whose AuthorID names an author
+// that is not in authors. Books without an AuthorID are still accepted.
+func WithAuthors(authors repository.AuthorRepository) Option {
+	return func(s *BookService) {
+		s.authors = authors
+	}
+}
+
+
+// WithPublishers makes writes refuse books whose PublisherID names a
+// publisher that is not in publishers.
+func WithPublishers(publishers repository.PublisherRepository) Option {
+	return func(s *BookService) {
+		s.publishers = publishers
+	}
+}
+
+
+// WithStockLedger hands Quantity over to ledger. Updates keep a book's
+// stored quantity, so stock can only change through recorded movements; a
+// new book's Quantity becomes its opening balance.
+func WithStockLedger(ledger repository.StockLedger) Option {
+	return func(s *BookService) {
+		s.stock = ledger
+	}
+}
+
+
+func NewBookService(repo repository.BookRepository, opts ...Option) *BookService {
+	s := &BookService{
+		repo:      repo,
+		revisions: repository.NewMemoryRevisionRepository(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.asOf = repository.NewAsOfView(repo, s.revisions)
+
+	return s
 }
 
 
-func (s *BookService) GetAll(limit, offset int) ([]models.Book, error) {
+func (s *BookService) GetAll(limit, offset int, filter models.BookFilter) ([]models.Book, error) {
 
 	if limit <= 0 {
 		limit = 10
@@ -32,6 +101,7 @@ func (s *BookService) GetAll(limit, offset int) ([]models.Book, error) {
 	params := models.PaginationParams{
 		Limit:  limit,
 		Offset: offset,
+		Filter: filter,
 	}
 
 	return s.repo.GetAll(params)
@@ -43,22 +113,81 @@ func (s *BookService) GetByID(id string) (*models.Book, error) {
 }
 
 
-func (s *BookService) Create(book models.Book) (*models.Book, error) {
+func (s *BookService) GetByISBN(isbn string) (*models.Book, error) {
+	return s.repo.GetByISBN(isbn)
+}
 
-	
-	return s.repo.Create(book)
+
+func (s *BookService) Create(book models.Book, actor string) (*models.Book, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if err := prepareContributors(&book); err != nil {
+		return nil, err
+	}
+	if err := s.checkReferences(book, nil); err != nil {
+		return nil, err
+	}
+
+	created, err := s.repo.Create(book)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(models.ActionCreate, actor, nil, created)
+	return created, nil
 }
 
 
-func (s *BookService) Update(id string, book models.Book) (*models.Book, error) {
+// Update replaces a book. A non-zero book.Version is treated as the version
+// the caller expects to overwrite.
+func (s *BookService) Update(id string, book models.Book, actor string) (*models.Book, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
 
-	
-	return s.repo.Update(id, book)
+	before, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := prepareContributors(&book); err != nil {
+		return nil, err
+	}
+	if err := s.checkReferences(book, before); err != nil {
+		return nil, err
+	}
+	if s.stock != nil {
+		book.Quantity = before.Quantity
+	}
+
+	updated, err := s.repo.Update(id, book)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(models.ActionUpdate, actor, before, updated)
+	return updated, nil
 }
 
 
-func (s *BookService) Delete(id string) error {
-	return s.repo.Delete(id)
+// Delete moves a book to the trash. A non-zero expectedVersion makes the
+// delete fail with repository.ErrVersionConflict if the book has changed.
+func (s *BookService) Delete(id string, expectedVersion int, actor string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	before, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.repo.Delete(id, expectedVersion)
+	if err != nil {
+		return err
+	}
+
+	s.record(models.ActionDelete, actor, before, deleted)
+	return nil
 }
 
 
@@ -83,4 +212,356 @@ func (s *BookService) Search(query string) (models.SearchResult, error) {
 
 func (s *BookService) Count() (int, error) {
 	return s.repo.Count()
-}
\ No newline at end of file
+}
+
+
+// CountMatching counts the books matching filter. An empty filter counts
+// the whole catalog.
+func (s *BookService) CountMatching(filter models.BookFilter) (int, error) {
+	return s.repo.CountMatching(filter)
+}
+
+
+// ErrCacheDisabled is returned by CacheStats when the catalog is read
+// without a cache in front of it.
+var ErrCacheDisabled = errors.New("no cache is configured")
+
+
+func (s *BookService) CacheStats() (repository.CacheStats, error) {
+	cache := repository.FindCache(s.repo)
+	if cache == nil {
+		return repository.CacheStats{}, ErrCacheDisabled
+	}
+
+	return cache.Stats(), nil
+}
+
+
+func (s *BookService) GetTrash(limit, offset int) ([]models.Book, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return s.repo.GetDeleted(models.PaginationParams{Limit: limit, Offset: offset})
+}
+
+
+func (s *BookService) Restore(id string, actor string) (*models.Book, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	before, err := s.findInTrash(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkReferences(*before, nil); err != nil {
+		return nil, err
+	}
+
+	restored, err := s.repo.Restore(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(models.ActionRestore, actor, before, restored)
+	return restored, nil
+}
+
+
+// Purge permanently removes a single book from the trash.
+func (s *BookService) Purge(id string, actor string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	before, err := s.findInTrash(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Purge(id); err != nil {
+		return err
+	}
+
+	s.record(models.ActionPurge, actor, before, nil)
+	return nil
+}
+
+
+// PurgeTrash permanently removes books that have been in the trash for
+// longer than retention. A zero retention empties the trash.
+func (s *BookService) PurgeTrash(retention time.Duration, actor string) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	cutoff := time.Now()
+	if retention > 0 {
+		cutoff = cutoff.Add(-retention)
+	}
+
+	trash, err := s.repo.GetDeleted(models.PaginationParams{Limit: -1})
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := s.repo.PurgeDeletedBefore(cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range trash {
+		if trash[i].DeletedAt.Before(cutoff) {
+			s.record(models.ActionPurge, actor, &trash[i], nil)
+		}
+	}
+
+	return purged, nil
+}
+
+
+// ApplyBatch applies ops atomically and records a revision for each of
+// them once the whole batch has been committed.
+func (s *BookService) ApplyBatch(ops []models.BatchOp, actor string) ([]models.Book, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	return s.applyBatch(ops, actor)
+}
+
+
+// applyBatch is ApplyBatch for callers already holding writeMutex.
+func (s *BookService) applyBatch(ops []models.BatchOp, actor string) ([]models.Book, error) {
+	// Before states are read up front. A book touched by several
+	// operations takes the result of the previous one instead.
+	states := make(map[string]*models.Book)
+	for _, op := range ops {
+		if op.Action == models.ActionCreate {
+			continue
+		}
+		if _, ok := states[op.BookID]; !ok {
+			states[op.BookID], _ = s.repo.GetByID(op.BookID)
+		}
+	}
+
+	ops = append([]models.BatchOp(nil), ops...)
+	for i := range ops {
+		op := &ops[i]
+		if op.Action != models.ActionCreate && op.Action != models.ActionUpdate {
+			continue
+		}
+		if err := prepareContributors(&op.Book); err != nil {
+			return nil, fmt.Errorf("batch operation %d (%s %s): %w", i+1, op.Action, op.BookID, err)
+		}
+
+		var before *models.Book
+		if op.Action == models.ActionUpdate {
+			before = states[op.BookID]
+		}
+		if err := s.checkReferences(op.Book, before); err != nil {
+			return nil, fmt.Errorf("batch operation %d (%s %s): %w", i+1, op.Action, op.BookID, err)
+		}
+		if s.stock != nil && before != nil {
+			op.Book.Quantity = before.Quantity
+		}
+	}
+
+	results, err := s.repo.Apply(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		var before *models.Book
+		if ops[i].Action != models.ActionCreate {
+			before = states[results[i].BookID]
+		}
+
+		s.record(ops[i].Action, actor, before, &results[i])
+		states[results[i].BookID] = &results[i]
+	}
+
+	return results, nil
+}
+
+
+func (s *BookService) findInTrash(id string) (*models.Book, error) {
+	trash, err := s.repo.GetDeleted(models.PaginationParams{Limit: -1})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range trash {
+		if trash[i].BookID == id {
+			return &trash[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w in trash with ID: %s", repository.ErrNotFound, id)
+}
+
+
+// checkReferences makes sure the records book refers to exist. Only
+// references that differ from before are checked, so books written before
+// checks were enabled can still be edited.
+func (s *BookService) checkReferences(book models.Book, before *models.Book) error {
+	if s.authors != nil {
+		if book.AuthorID != "" && (before == nil || before.AuthorID != book.AuthorID) {
+			if err := s.checkAuthor(book.AuthorID); err != nil {
+				return err
+			}
+		}
+
+		for _, contributor := range book.Contributors {
+			if before != nil && credits(*before, contributor.ContributorID) {
+				continue
+			}
+			if err := s.checkAuthor(contributor.ContributorID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.publishers != nil && book.PublisherID != "" && (before == nil || before.PublisherID != book.PublisherID) {
+		if _, err := s.publishers.GetByID(book.PublisherID); err != nil {
+			if errors.Is(err, repository.ErrPublisherNotFound) {
+				return fmt.Errorf("%w: publisher %s does not exist", repository.ErrInvalidReference, book.PublisherID)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+
+func (s *BookService) checkAuthor(id string) error {
+	if _, err := s.authors.GetByID(id); err != nil {
+		if errors.Is(err, repository.ErrAuthorNotFound) {
+			return fmt.Errorf("%w: author %s does not exist", repository.ErrInvalidReference, id)
+		}
+		return err
+	}
+	return nil
+}
+
+
+// deleteReferenced deletes a record that the books matching filter refer
+// to, applying policy to those books first. Under DeleteCascade each book
+// is changed by the operation cascade returns for it. Holding the write
+// lock keeps a book referring to the record from being written in between.
+// It returns how many books were changed.
+func (s *BookService) deleteReferenced(what string, filter models.BookFilter, policy DeletePolicy, actor string,
+	cascade func(book models.Book) models.BatchOp, remove func() error) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	books, err := s.repo.GetAll(models.PaginationParams{Limit: -1, Filter: filter})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(books) > 0 {
+		if policy != DeleteCascade {
+			return 0, fmt.Errorf("%w: %s has %d books", repository.ErrStillReferenced, what, len(books))
+		}
+
+		ops := make([]models.BatchOp, len(books))
+		for i, book := range books {
+			ops[i] = cascade(book)
+		}
+		if _, err := s.applyBatch(ops, actor); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := remove(); err != nil {
+		return 0, err
+	}
+
+	return len(books), nil
+}
+
+
+// setQuantity stores the stock level of book decided by the stock ledger.
+// The update is conditional on book still being at its version. The caller
+// holds writeMutex.
+func (s *BookService) setQuantity(book models.Book, quantity int, actor string) (*models.Book, error) {
+	before := book
+	book.Quantity = quantity
+
+	updated, err := s.repo.Update(book.BookID, book)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(models.ActionStock, actor, &before, updated)
+	return updated, nil
+}
+
+
+// reconcileStock records an adjustment for every book whose Quantity was
+// changed without a stock movement, so the ledger balance matches it
+// again. Books without movements are left to their opening balance. The
+// caller holds writeMutex.
+func (s *BookService) reconcileStock(books []models.Book, reason, actor string) {
+	if s.stock == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	var adjustments []models.StockMovement
+	for _, book := range books {
+		movements, err := s.stock.List(book.BookID)
+		if err != nil {
+			log.Printf("service: failed to reconcile stock of book %s: %v", book.BookID, err)
+			continue
+		}
+		if len(movements) == 0 {
+			continue
+		}
+		if balance := movements[len(movements)-1].Balance; balance != book.Quantity {
+			adjustments = append(adjustments, reconciliation(book, balance, now, actor, reason))
+		}
+	}
+
+	if len(adjustments) == 0 {
+		return
+	}
+	if _, err := s.stock.Append(adjustments...); err != nil {
+		log.Printf("service: failed to record %d stock adjustments for %s: %v", len(adjustments), reason, err)
+	}
+}
+
+
+// trashBook is the cascade of a record whose books cannot exist without it.
+func trashBook(book models.Book) models.BatchOp {
+	return models.BatchOp{Action: models.ActionDelete, BookID: book.BookID, ExpectedVersion: book.Version}
+}
+
+
+// record appends a revision for a change that has already been applied.
+// The change cannot be undone at this point, so a failure to record it is
+// logged rather than returned.
+func (s *BookService) record(action, actor string, before, after *models.Book) {
+	bookID := ""
+	if after != nil {
+		bookID = after.BookID
+	} else if before != nil {
+		bookID = before.BookID
+	}
+
+	_, err := s.revisions.Append(models.Revision{
+		BookID:    bookID,
+		Timestamp: time.Now().UTC(),
+		Actor:     actor,
+		Action:    action,
+		Before:    before,
+		After:     after,
+	})
+	if err != nil {
+		log.Printf("service: failed to record %s of book %s by %s: %v", action, bookID, actor, err)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+)
+
+
+// prepareContributors validates the contributors of book and normalizes
+// their roles to lower case. A book without an AuthorID takes its first
+// contributor in the author role as its primary author.
+func prepareContributors(book *models.Book) error {
+	if len(book.Contributors) == 0 {
+		return nil
+	}
+
+	contributors := make([]models.Contributor, len(book.Contributors))
+	seen := make(map[models.Contributor]bool)
+	for i, contributor := range book.Contributors {
+		contributor.ContributorID = strings.TrimSpace(contributor.ContributorID)
+		contributor.Role = strings.ToLower(strings.TrimSpace(contributor.Role))
+
+		if contributor.ContributorID == "" {
+			return fmt.Errorf("%w: contributor %d has no contributorId", repository.ErrInvalidRecord, i+1)
+		}
+		if !slices.Contains(models.ContributorRoles, contributor.Role) {
+			return fmt.Errorf("%w: contributor %d has role %q, want one of %s",
+				repository.ErrInvalidRecord, i+1, contributor.Role, strings.Join(models.ContributorRoles, ", "))
+		}
+		if seen[contributor] {
+			return fmt.Errorf("%w: %s is credited as %s twice", repository.ErrInvalidRecord, contributor.ContributorID, contributor.Role)
+		}
+		seen[contributor] = true
+
+		contributors[i] = contributor
+	}
+	book.Contributors = contributors
+
+	if book.AuthorID == "" {
+		for _, contributor := range contributors {
+			if contributor.Role == models.RoleAuthor {
+				book.AuthorID = contributor.ContributorID
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+
+// credits reports whether book names id as its primary author or as any
+// contributor.
+func credits(book models.Book, id string) bool {
+	if book.AuthorID == id {
+		return true
+	}
+	for _, contributor := range book.Contributors {
+		if contributor.ContributorID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+)
+
+
+// GetHistory returns every recorded revision of a book, oldest first.
+func (s *BookService) GetHistory(id string) ([]models.Revision, error) {
+	revisions, err := s.revisions.List(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		if _, err := s.repo.GetByID(id); err != nil {
+			return nil, err
+		}
+	}
+
+	return revisions, nil
+}
+
+
+// GetAllAsOf pages through the catalog as it stood at t and also returns
+// the number of books matching filter at that time.
+func (s *BookService) GetAllAsOf(limit, offset int, filter models.BookFilter, t time.Time) ([]models.Book, int, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return s.asOf.GetAll(models.PaginationParams{
+		Limit:  limit,
+		Offset: offset,
+		Filter: filter,
+	}, t)
+}
+
+
+func (s *BookService) GetByIDAsOf(id string, t time.Time) (*models.Book, error) {
+	return s.asOf.GetByID(id, t)
+}
+
+
+func (s *BookService) GetRevision(id string, rev int) (*models.Revision, error) {
+	return s.revisions.Get(id, rev)
+}
+
+
+// Diff compares the book as it stood after revision from with the book as
+// it stood after revision to.
+func (s *BookService) Diff(id string, from, to int) (*models.RevisionDiff, error) {
+	fromRev, err := s.revisions.Get(id, from)
+	if err != nil {
+		return nil, err
+	}
+
+	toRev, err := s.revisions.Get(id, to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := diffBooks(fromRev.After, toRev.After)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RevisionDiff{
+		BookID:  id,
+		FromRev: from,
+		ToRev:   to,
+		Changes: changes,
+	}, nil
+}
+
+
+// diffBooks lists the JSON fields whose values differ between two book
+// states. A nil book, as left by a purge, has no fields at all.
+func diffBooks(from, to *models.Book) ([]models.FieldChange, error) {
+	fromFields, err := bookFields(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toFields, err := bookFields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{})
+	for name := range fromFields {
+		names[name] = struct{}{}
+	}
+	for name := range toFields {
+		names[name] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	changes := []models.FieldChange{}
+	for _, name := range sorted {
+		if !reflect.DeepEqual(fromFields[name], toFields[name]) {
+			changes = append(changes, models.FieldChange{
+				Field: name,
+				From:  fromFields[name],
+				To:    toFields[name],
+			})
+		}
+	}
+
+	return changes, nil
+}
+
+
+func bookFields(book *models.Book) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if book == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(book)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing book: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("error decoding book fields: %w", err)
+	}
+
+	return fields, nil
+}
+
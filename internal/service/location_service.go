@@ -0,0 +1,66 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+)
+
+
+type LocationService struct {
+	recordService[models.Location]
+	locations repository.LocationRepository
+	stock     *StockService
+}
+
+
+// NewLocationService manages the locations stock is held at. stock should
+// have been created WithLocations(locations).
+func NewLocationService(locations repository.LocationRepository, stock *StockService) *LocationService {
+	return &LocationService{
+		recordService: recordService[models.Location]{records: locations, prepare: prepareLocation},
+		locations:     locations,
+		stock:         stock,
+	}
+}
+
+
+// Delete removes a location that holds no stock of live books. Copies still
+// there have to be transferred elsewhere or written off first; copies of
+// books in the trash or purged cannot be moved and do not count.
+func (s *LocationService) Delete(id string) error {
+	s.stock.books.writeMutex.Lock()
+	defer s.stock.books.writeMutex.Unlock()
+
+	if _, err := s.locations.GetByID(id); err != nil {
+		return err
+	}
+
+	held, err := s.stock.liveBooksAt(id)
+	if err != nil {
+		return err
+	}
+	if len(held) > 0 {
+		return fmt.Errorf("%w: location %s still holds stock of %d books", repository.ErrStillReferenced, id, len(held))
+	}
+
+	return s.locations.Delete(id)
+}
+
+
+func prepareLocation(location models.Location) (models.Location, error) {
+	if strings.TrimSpace(location.Name) == "" {
+		return location, fmt.Errorf("%w: location name is required", repository.ErrInvalidRecord)
+	}
+
+	location.Type = strings.ToLower(strings.TrimSpace(location.Type))
+	if !slices.Contains(models.LocationTypes, location.Type) {
+		return location, fmt.Errorf("%w: location type %q, want one of %s",
+			repository.ErrInvalidRecord, location.Type, strings.Join(models.LocationTypes, ", "))
+	}
+
+	return location, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+
+type PublisherService struct {
+	recordService[models.Publisher]
+	publishers repository.PublisherRepository
+	books      *BookService
+	policy     DeletePolicy
+}
+
+
+// NewPublisherService manages publishers and the books referring to them.
+// books should have been created WithPublishers(publishers).
+func NewPublisherService(publishers repository.PublisherRepository, books *BookService, policy DeletePolicy) *PublisherService {
+	if policy == "" {
+		policy = DeleteRestrict
+	}
+
+	return &PublisherService{
+		recordService: recordService[models.Publisher]{records: publishers, prepare: preparePublisher},
+		publishers:    publishers,
+		books:         books,
+		policy:        policy,
+	}
+}
+
+
+// Delete removes a publisher, handling its live books by the delete policy
+// in the same way as AuthorService.Delete.
+func (s *PublisherService) Delete(id string, actor string) (int, error) {
+	if _, err := s.publishers.GetByID(id); err != nil {
+		return 0, err
+	}
+
+	return s.books.deleteReferenced("publisher "+id, models.BookFilter{PublisherID: id}, s.policy, actor, trashBook, func() error {
+		return s.publishers.Delete(id)
+	})
+}
+
+
+// GetBooks returns a page of the live books of a publisher along with how
+// many there are in total.
+func (s *PublisherService) GetBooks(id string, limit, offset int) ([]models.Book, int, error) {
+	if _, err := s.publishers.GetByID(id); err != nil {
+		return nil, 0, err
+	}
+
+	filter := models.BookFilter{PublisherID: id}
+	books, err := s.books.GetAll(limit, offset, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	count, err := s.books.CountMatching(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return books, count, nil
+}
+
+
+// preparePublisher validates publisher and gives new imprints an ID.
+func preparePublisher(publisher models.Publisher) (models.Publisher, error) {
+	if strings.TrimSpace(publisher.Name) == "" {
+		return publisher, fmt.Errorf("%w: publisher name is required", repository.ErrInvalidRecord)
+	}
+	if email := publisher.Contact.Email; email != "" && !strings.Contains(email, "@") {
+		return publisher, fmt.Errorf("%w: contact email %q is not an email address", repository.ErrInvalidRecord, email)
+	}
+
+	imprints := make([]models.Imprint, len(publisher.Imprints))
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	for i, imprint := range publisher.Imprints {
+		name := strings.ToLower(strings.TrimSpace(imprint.Name))
+		if name == "" {
+			return publisher, fmt.Errorf("%w: imprint name is required", repository.ErrInvalidRecord)
+		}
+		if names[name] {
+			return publisher, fmt.Errorf("%w: imprint %q is listed twice", repository.ErrInvalidRecord, imprint.Name)
+		}
+		names[name] = true
+
+		if imprint.ImprintID == "" {
+			imprint.ImprintID = uuid.New().String()
+		}
+		if ids[imprint.ImprintID] {
+			return publisher, fmt.Errorf("%w: imprint ID %s is used twice", repository.ErrInvalidRecord, imprint.ImprintID)
+		}
+		ids[imprint.ImprintID] = true
+
+		imprints[i] = imprint
+	}
+	publisher.Imprints = imprints
+
+	return publisher, nil
+}
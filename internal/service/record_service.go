@@ -0,0 +1,56 @@
+package service
+
+import (
+	"crud-in-go-lang/internal/repository"
+)
+
+
+// recordService implements the listing, lookup and write methods shared by
+// the services of small entities such as authors. prepare validates and
+// normalizes a record before it is stored.
+type recordService[T any] struct {
+	records repository.RecordRepository[T]
+	prepare func(T) (T, error)
+}
+
+
+func (s *recordService[T]) GetAll(limit, offset int) ([]T, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return s.records.GetAll(limit, offset)
+}
+
+
+func (s *recordService[T]) GetByID(id string) (*T, error) {
+	return s.records.GetByID(id)
+}
+
+
+func (s *recordService[T]) Count() (int, error) {
+	return s.records.Count()
+}
+
+
+func (s *recordService[T]) Create(record T) (*T, error) {
+	record, err := s.prepare(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.records.Create(record)
+}
+
+
+func (s *recordService[T]) Update(id string, record T) (*T, error) {
+	record, err := s.prepare(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.records.Update(id, record)
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+)
+
+
+// ErrSnapshotsDisabled is returned by the snapshot methods of a service
+// created without WithSnapshots.
+var ErrSnapshotsDisabled = errors.New("snapshots are not configured")
+
+
+// WithSnapshots enables backups of the catalog through snapshots.
+func WithSnapshots(snapshots *repository.SnapshotManager) Option {
+	return func(s *BookService) {
+		s.snapshots = snapshots
+	}
+}
+
+
+func (s *BookService) CreateSnapshot() (*repository.SnapshotManifest, error) {
+	if s.snapshots == nil {
+		return nil, ErrSnapshotsDisabled
+	}
+
+	// Holding the write lock keeps the snapshot and the revision history in
+	// step with each other.
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	return s.snapshots.Create()
+}
+
+
+func (s *BookService) ListSnapshots() ([]repository.SnapshotManifest, error) {
+	if s.snapshots == nil {
+		return nil, ErrSnapshotsDisabled
+	}
+
+	return s.snapshots.List()
+}
+
+
+// RestoreSnapshot replaces the catalog with a snapshot and records a
+// revision for every book the restore changed. Quantities it changed are
+// recorded as stock adjustments.
+func (s *BookService) RestoreSnapshot(id string, actor string) (*repository.SnapshotManifest, error) {
+	if s.snapshots == nil {
+		return nil, ErrSnapshotsDisabled
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	before, err := s.repo.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := s.snapshots.Restore(id)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := s.repo.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make(map[string]*models.Book, len(before))
+	for i := range before {
+		previous[before[i].BookID] = &before[i]
+	}
+	for i := range after {
+		old := previous[after[i].BookID]
+		delete(previous, after[i].BookID)
+		if old == nil || !repository.SameBook(*old, after[i]) {
+			s.record(models.ActionRestoreSnapshot, actor, old, &after[i])
+		}
+	}
+	for _, old := range previous {
+		s.record(models.ActionRestoreSnapshot, actor, old, nil)
+	}
+	s.reconcileStock(after, "snapshot "+id+" restored", actor)
+
+	return manifest, nil
+}
@@ -0,0 +1,789 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+
+// BackorderPolicy decides whether a sale may take more copies than are on
+// hand.
+type BackorderPolicy string
+
+
+const (
+	BackordersRejected BackorderPolicy = "reject"
+	BackordersAllowed  BackorderPolicy = "allow"
+)
+
+
+// ParseBackorderPolicy accepts "reject" or "allow". An empty value selects
+// BackordersRejected.
+func ParseBackorderPolicy(value string) (BackorderPolicy, error) {
+	switch policy := BackorderPolicy(strings.ToLower(value)); policy {
+	case "":
+		return BackordersRejected, nil
+	case BackordersRejected, BackordersAllowed:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown backorder policy %q, want reject or allow", value)
+	}
+}
+
+
+var movementTypes = []string{
+	models.MovementReceipt, models.MovementSale, models.MovementAdjustment, models.MovementDamage, models.MovementReturn,
+}
+
+
+// DefaultReservationTTL is how long a reservation holds copies unless
+// WithReservationTTL says otherwise.
+const DefaultReservationTTL = 15 * time.Minute
+
+
+// StockService records stock movements and keeps Book.Quantity equal to
+// the ledger balance. Reservations hold copies on hand back from sales
+// until they are confirmed, released or expire.
+type StockService struct {
+	ledger         repository.StockLedger
+	books          *BookService
+	backorders     BackorderPolicy
+	reservations   repository.ReservationRepository
+	reservationTTL time.Duration
+	locations      repository.LocationRepository
+	now            func() time.Time
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+
+// StockOption configures optional collaborators of a StockService.
+type StockOption func(*StockService)
+
+
+// WithReservations stores reservations in reservations instead of the
+// default in-memory repository.
+func WithReservations(reservations repository.ReservationRepository) StockOption {
+	return func(s *StockService) {
+		s.reservations = reservations
+	}
+}
+
+
+// WithLocations makes movements, transfers and the location filter check
+// that the locations they name exist.
+func WithLocations(locations repository.LocationRepository) StockOption {
+	return func(s *StockService) {
+		s.locations = locations
+	}
+}
+
+
+// WithClock makes the service read the time from now, which decides when
+// reservations expire and stamps movements.
+func WithClock(now func() time.Time) StockOption {
+	return func(s *StockService) {
+		s.now = now
+	}
+}
+
+
+func WithReservationTTL(ttl time.Duration) StockOption {
+	return func(s *StockService) {
+		if ttl > 0 {
+			s.reservationTTL = ttl
+		}
+	}
+}
+
+
+// NewStockService records movements in ledger. books should have been
+// created WithStockLedger(ledger) so nothing else changes Quantity.
+func NewStockService(ledger repository.StockLedger, books *BookService, backorders BackorderPolicy, opts ...StockOption) *StockService {
+	if backorders == "" {
+		backorders = BackordersRejected
+	}
+
+	s := &StockService{
+		ledger:         ledger,
+		books:          books,
+		backorders:     backorders,
+		reservations:   repository.NewMemoryReservationRepository(),
+		reservationTTL: DefaultReservationTTL,
+		now:            time.Now,
+		done:           make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+
+// Record applies movement to a book and returns it as stored along with
+// the book at its new quantity. A movement that would take copies that are
+// not available, because they are not on hand at its location or are
+// reserved, fails with repository.ErrInsufficientStock, unless it is a sale
+// and backorders are allowed.
+func (s *StockService) Record(bookID string, movement models.StockMovement, actor string) (*models.StockMovement, *models.Book, error) {
+	movement.Type = strings.ToLower(strings.TrimSpace(movement.Type))
+	if err := validateMovement(movement); err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkLocation(movement.LocationID); err != nil {
+		return nil, nil, err
+	}
+
+	s.books.writeMutex.Lock()
+	defer s.books.writeMutex.Unlock()
+
+	book, err := s.books.repo.GetByID(bookID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.record(*book, movement, actor, "")
+}
+
+
+// record is Record for callers holding the book write lock. The
+// reservation named by confirming, if any, does not count as holding
+// copies back from the movement.
+func (s *StockService) record(book models.Book, movement models.StockMovement, actor, confirming string) (*models.StockMovement, *models.Book, error) {
+	now := s.now().UTC()
+	movements, onHand, err := s.openingBalance(book, now, actor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reserved, reservedAt, err := s.reserved(book.BookID, now, confirming)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	balance := onHand + movement.Delta()
+	if movement.Delta() < 0 && !(movement.Type == models.MovementSale && s.backorders == BackordersAllowed) {
+		if balance < reserved {
+			return nil, nil, fmt.Errorf("%w: book %s has %d copies available, cannot take %d",
+				repository.ErrInsufficientStock, book.BookID, max(onHand-reserved, 0), -movement.Delta())
+		}
+
+		held, err := s.byLocation(book)
+		if err != nil {
+			return nil, nil, err
+		}
+		if available := held[movement.LocationID] - reservedAt[movement.LocationID]; available+movement.Delta() < 0 {
+			return nil, nil, fmt.Errorf("%w: %s has %d copies of book %s available, cannot take %d",
+				repository.ErrInsufficientStock, describeLocation(movement.LocationID), max(available, 0), book.BookID, -movement.Delta())
+		}
+	}
+
+	movement.BookID = book.BookID
+	movement.Timestamp = now
+	movement.Actor = actor
+	movement.Balance = balance
+
+	// The book is written first: it is the step that can fail on a version
+	// conflict or lock timeout, and nothing is in the ledger yet if it does.
+	updated, err := s.books.setQuantity(book, balance, actor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	appended, err := s.ledger.Append(append(movements, movement)...)
+	if err != nil {
+		if _, revertErr := s.books.setQuantity(*updated, book.Quantity, actor); revertErr != nil {
+			// The next movement records the difference as an adjustment.
+			log.Printf("service: book %s was left at quantity %d, which the stock ledger failed to record: %v", book.BookID, balance, revertErr)
+		}
+		return nil, nil, err
+	}
+
+	return &appended[len(appended)-1], updated, nil
+}
+
+
+// Levels reports how many copies of a book are on hand, reserved and
+// available.
+func (s *StockService) Levels(bookID string) (*models.StockLevels, error) {
+	book, err := s.books.GetByID(bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now().UTC()
+	_, onHand, err := s.openingBalance(*book, now, "")
+	if err != nil {
+		return nil, err
+	}
+
+	reserved, _, err := s.reserved(bookID, now, "")
+	if err != nil {
+		return nil, err
+	}
+
+	held, err := s.byLocation(*book)
+	if err != nil {
+		return nil, err
+	}
+	var locations []models.LocationStock
+	for locationID, quantity := range held {
+		if quantity != 0 {
+			locations = append(locations, models.LocationStock{LocationID: locationID, OnHand: quantity})
+		}
+	}
+	slices.SortFunc(locations, func(a, b models.LocationStock) int {
+		return strings.Compare(a.LocationID, b.LocationID)
+	})
+	// Books that are not stocked at any location need no breakdown.
+	if len(locations) == 1 && locations[0].LocationID == "" {
+		locations = nil
+	}
+
+	return &models.StockLevels{
+		OnHand:    onHand,
+		Reserved:  reserved,
+		Available: onHand - reserved,
+		Locations: locations,
+	}, nil
+}
+
+
+// Transfer moves copies of a book between locations, recording a
+// transfer-out and a transfer-in in a single ledger write. It returns both
+// movements. Reserved copies stay where they are held, and the book's
+// quantity does not change.
+func (s *StockService) Transfer(bookID string, transfer models.StockTransfer, actor string) ([]models.StockMovement, *models.Book, error) {
+	if transfer.Quantity <= 0 {
+		return nil, nil, fmt.Errorf("%w: a transfer needs a positive quantity", repository.ErrInvalidRecord)
+	}
+	if transfer.From == transfer.To {
+		return nil, nil, fmt.Errorf("%w: a transfer needs two different locations", repository.ErrInvalidRecord)
+	}
+	for _, locationID := range []string{transfer.From, transfer.To} {
+		if err := s.checkLocation(locationID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	s.books.writeMutex.Lock()
+	defer s.books.writeMutex.Unlock()
+
+	book, err := s.books.repo.GetByID(bookID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := s.now().UTC()
+	movements, onHand, err := s.openingBalance(*book, now, actor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	held, err := s.byLocation(*book)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, reservedAt, err := s.reserved(bookID, now, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if available := held[transfer.From] - reservedAt[transfer.From]; available < transfer.Quantity {
+		return nil, nil, fmt.Errorf("%w: %s has %d copies of book %s available, cannot transfer %d",
+			repository.ErrInsufficientStock, describeLocation(transfer.From), max(available, 0), bookID, transfer.Quantity)
+	}
+
+	out := models.StockMovement{
+		BookID:     bookID,
+		Timestamp:  now,
+		Actor:      actor,
+		Type:       models.MovementTransferOut,
+		Quantity:   transfer.Quantity,
+		Balance:    onHand,
+		LocationID: transfer.From,
+		TransferID: uuid.New().String(),
+		Reference:  transfer.Reference,
+	}
+	in := out
+	in.Type = models.MovementTransferIn
+	in.LocationID = transfer.To
+
+	appended, err := s.ledger.Append(append(movements, out, in)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return appended[len(appended)-2:], book, nil
+}
+
+
+// BooksAt returns a page of the live books matching filter that have
+// copies on hand at a location, in catalog order, along with how many
+// there are in total.
+func (s *StockService) BooksAt(locationID string, limit, offset int, filter models.BookFilter) ([]models.Book, int, error) {
+	if s.locations != nil {
+		if _, err := s.locations.GetByID(locationID); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	held, err := s.ledger.AtLocation(locationID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if limit <= 0 {
+		limit = 10
+	}
+	offset = max(offset, 0)
+
+	// The catalog is walked a page at a time, keeping only the window.
+	books := []models.Book{}
+	count := 0
+	for start := 0; ; start += booksAtPage {
+		page, err := s.books.repo.GetAll(models.PaginationParams{Limit: booksAtPage, Offset: start, Filter: filter})
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, book := range page {
+			if held[book.BookID] <= 0 {
+				continue
+			}
+			if count >= offset && count < offset+limit {
+				books = append(books, book)
+			}
+			count++
+		}
+		if len(page) < booksAtPage {
+			break
+		}
+	}
+
+	return books, count, nil
+}
+
+
+// booksAtPage is how many books BooksAt reads from the catalog at once.
+const booksAtPage = 500
+
+
+// liveBooksAt returns the live books with copies on hand at a location.
+// Copies of books in the trash or purged are left out.
+func (s *StockService) liveBooksAt(locationID string) ([]models.Book, error) {
+	held, err := s.ledger.AtLocation(locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []models.Book{}
+	for bookID, quantity := range held {
+		if quantity <= 0 {
+			continue
+		}
+		book, err := s.books.repo.GetByID(bookID)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, *book)
+	}
+
+	return books, nil
+}
+
+
+// Reserve holds hold.Quantity copies of a book available at
+// hold.LocationID for the reservation TTL.
+func (s *StockService) Reserve(bookID string, hold models.Reservation, actor string) (*models.Reservation, error) {
+	if hold.Quantity <= 0 {
+		return nil, fmt.Errorf("%w: a reservation needs a positive quantity", repository.ErrInvalidRecord)
+	}
+	if err := s.checkLocation(hold.LocationID); err != nil {
+		return nil, err
+	}
+
+	s.books.writeMutex.Lock()
+	defer s.books.writeMutex.Unlock()
+
+	book, err := s.books.repo.GetByID(bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now().UTC()
+	_, onHand, err := s.openingBalance(*book, now, actor)
+	if err != nil {
+		return nil, err
+	}
+	reserved, reservedAt, err := s.reserved(bookID, now, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if available := onHand - reserved; hold.Quantity > available {
+		return nil, fmt.Errorf("%w: book %s has %d copies available, cannot reserve %d",
+			repository.ErrInsufficientStock, bookID, max(available, 0), hold.Quantity)
+	}
+
+	held, err := s.byLocation(*book)
+	if err != nil {
+		return nil, err
+	}
+	if available := held[hold.LocationID] - reservedAt[hold.LocationID]; hold.Quantity > available {
+		return nil, fmt.Errorf("%w: %s has %d copies of book %s available, cannot reserve %d",
+			repository.ErrInsufficientStock, describeLocation(hold.LocationID), max(available, 0), bookID, hold.Quantity)
+	}
+
+	return s.reservations.Create(models.Reservation{
+		BookID:     bookID,
+		Quantity:   hold.Quantity,
+		LocationID: hold.LocationID,
+		Status:     models.ReservationActive,
+		Reference:  hold.Reference,
+		Actor:      actor,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(s.reservationTTL),
+	})
+}
+
+
+// Reservations lists the active reservations of a book.
+func (s *StockService) Reservations(bookID string) ([]models.Reservation, error) {
+	if _, err := s.books.GetByID(bookID); err != nil {
+		return nil, err
+	}
+
+	reservations, err := s.reservations.List(bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now().UTC()
+	active := []models.Reservation{}
+	for _, reservation := range reservations {
+		if now.Before(reservation.ExpiresAt) {
+			active = append(active, reservation)
+		}
+	}
+	return active, nil
+}
+
+
+// GetReservation returns an active reservation.
+func (s *StockService) GetReservation(id string) (*models.Reservation, error) {
+	reservation, err := s.reservations.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !s.now().Before(reservation.ExpiresAt) {
+		return nil, fmt.Errorf("%w: reservation %s expired at %s", repository.ErrReservationExpired, id, reservation.ExpiresAt.Format(time.RFC3339))
+	}
+	return reservation, nil
+}
+
+
+// Confirm turns a reservation into a sale of the copies it held, at the
+// location holding them, and returns it along with the sale and the book
+// at its new quantity.
+func (s *StockService) Confirm(id string, actor string) (*models.Reservation, *models.StockMovement, *models.Book, error) {
+	s.books.writeMutex.Lock()
+	defer s.books.writeMutex.Unlock()
+
+	reservation, err := s.take(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	book, err := s.books.repo.GetByID(reservation.BookID)
+	if err == nil {
+		sale := models.StockMovement{
+			Type:       models.MovementSale,
+			Quantity:   reservation.Quantity,
+			Reason:     "reservation confirmed",
+			Reference:  reservation.ReservationID,
+			LocationID: reservation.LocationID,
+		}
+
+		var movement *models.StockMovement
+		var updated *models.Book
+		movement, updated, err = s.record(*book, sale, actor, reservation.ReservationID)
+		if err == nil {
+			reservation.Status = models.ReservationConfirmed
+			return reservation, movement, updated, nil
+		}
+
+		// The copies are gone if the sale could not be undone, and holding
+		// them as well would count them twice.
+		current, getErr := s.books.repo.GetByID(book.BookID)
+		if getErr != nil || current.Quantity != book.Quantity {
+			log.Printf("service: reservation %s was not restored after its confirmation failed: %v", id, err)
+			return nil, nil, nil, err
+		}
+	}
+
+	// Put the hold back so the customer can try again.
+	if _, restoreErr := s.reservations.Create(*reservation); restoreErr != nil {
+		log.Printf("service: failed to restore reservation %s after a failed confirmation: %v", id, restoreErr)
+	}
+	return nil, nil, nil, err
+}
+
+
+// Release gives the copies held by a reservation back.
+func (s *StockService) Release(id string) (*models.Reservation, error) {
+	s.books.writeMutex.Lock()
+	defer s.books.writeMutex.Unlock()
+
+	reservation, err := s.take(id)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation.Status = models.ReservationReleased
+	return reservation, nil
+}
+
+
+// ExpireReservations deletes every reservation past its expiry and
+// reports how many there were. Expired reservations stop holding copies
+// straight away; this only clears them out.
+func (s *StockService) ExpireReservations() (int, error) {
+	s.books.writeMutex.Lock()
+	defer s.books.writeMutex.Unlock()
+
+	reservations, err := s.reservations.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.now()
+	expired := 0
+	for _, reservation := range reservations {
+		if now.Before(reservation.ExpiresAt) {
+			continue
+		}
+		if err := s.reservations.Delete(reservation.ReservationID); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+
+// StartReaper expires reservations every interval until StopReaper is
+// called.
+func (s *StockService) StartReaper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+
+			expired, err := s.ExpireReservations()
+			if err != nil {
+				log.Printf("service: failed to expire reservations: %v", err)
+			}
+			if expired > 0 {
+				log.Printf("service: expired %d reservations", expired)
+			}
+		}
+	}()
+}
+
+
+func (s *StockService) StopReaper() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+
+// take removes an active reservation so it can be confirmed or released.
+// A reservation found expired is removed as well, but reported as
+// repository.ErrReservationExpired.
+func (s *StockService) take(id string) (*models.Reservation, error) {
+	reservation, err := s.reservations.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.reservations.Delete(id); err != nil {
+		return nil, err
+	}
+
+	if !s.now().Before(reservation.ExpiresAt) {
+		return nil, fmt.Errorf("%w: reservation %s expired at %s", repository.ErrReservationExpired, id, reservation.ExpiresAt.Format(time.RFC3339))
+	}
+	return reservation, nil
+}
+
+
+// reserved counts the copies of a book held by unexpired reservations
+// other than except, in total and at each location.
+func (s *StockService) reserved(bookID string, now time.Time, except string) (int, map[string]int, error) {
+	reservations, err := s.reservations.List(bookID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	reserved := 0
+	at := make(map[string]int)
+	for _, reservation := range reservations {
+		if reservation.ReservationID != except && now.Before(reservation.ExpiresAt) {
+			reserved += reservation.Quantity
+			at[reservation.LocationID] += reservation.Quantity
+		}
+	}
+	return reserved, at, nil
+}
+
+
+// Movements returns a page of the movements of a book, oldest first, along
+// with how many there are in total.
+func (s *StockService) Movements(bookID string, limit, offset int) ([]models.StockMovement, int, error) {
+	if _, err := s.books.GetByID(bookID); err != nil {
+		return nil, 0, err
+	}
+
+	movements, err := s.ledger.List(bookID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if limit <= 0 {
+		limit = 10
+	}
+	start := min(max(offset, 0), len(movements))
+	end := min(start+limit, len(movements))
+
+	return movements[start:end], len(movements), nil
+}
+
+
+// openingBalance returns the stock on hand, which is the book's stored
+// Quantity, along with the movements that bring the ledger up to it. A book
+// with no movements yet gets its Quantity recorded as an opening balance.
+// One whose Quantity was changed outside the ledger, by a hand edit,
+// another process or a failed movement, gets the difference recorded as an
+// adjustment. Either is appended with the next movement.
+func (s *StockService) openingBalance(book models.Book, now time.Time, actor string) ([]models.StockMovement, int, error) {
+	movements, err := s.ledger.List(book.BookID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	balance, reason := 0, "opening balance"
+	if len(movements) > 0 {
+		balance, reason = movements[len(movements)-1].Balance, "quantity changed outside the stock ledger"
+	}
+	if book.Quantity == balance {
+		return nil, balance, nil
+	}
+
+	return []models.StockMovement{reconciliation(book, balance, now, actor, reason)}, book.Quantity, nil
+}
+
+
+// reconciliation is the adjustment that takes the ledger from balance to
+// the book's Quantity.
+func reconciliation(book models.Book, balance int, now time.Time, actor, reason string) models.StockMovement {
+	return models.StockMovement{
+		BookID:    book.BookID,
+		Timestamp: now,
+		Actor:     actor,
+		Type:      models.MovementAdjustment,
+		Quantity:  book.Quantity - balance,
+		Balance:   book.Quantity,
+		Reason:    reason,
+	}
+}
+
+
+// byLocation returns the copies of a book on hand at each location.
+// Quantity the ledger has not recorded yet, such as a new book's opening
+// balance, is unassigned; see openingBalance.
+func (s *StockService) byLocation(book models.Book) (map[string]int, error) {
+	movements, err := s.ledger.List(book.BookID)
+	if err != nil {
+		return nil, err
+	}
+
+	held := make(map[string]int)
+	balance := 0
+	for _, movement := range movements {
+		held[movement.LocationID] += movement.Delta()
+		balance = movement.Balance
+	}
+	if book.Quantity != balance {
+		held[""] += book.Quantity - balance
+	}
+	return held, nil
+}
+
+
+// checkLocation reports an ErrInvalidReference for a location that does
+// not exist. An empty ID names unassigned stock and is always accepted.
+func (s *StockService) checkLocation(id string) error {
+	if id == "" || s.locations == nil {
+		return nil
+	}
+
+	_, err := s.locations.GetByID(id)
+	if errors.Is(err, repository.ErrLocationNotFound) {
+		return fmt.Errorf("%w: location %s does not exist", repository.ErrInvalidReference, id)
+	}
+	return err
+}
+
+
+func describeLocation(id string) string {
+	if id == "" {
+		return "unassigned stock"
+	}
+	return "location " + id
+}
+
+
+func validateMovement(movement models.StockMovement) error {
+	if !slices.Contains(movementTypes, movement.Type) {
+		return fmt.Errorf("%w: movement type %q, want one of %s",
+			repository.ErrInvalidRecord, movement.Type, strings.Join(movementTypes, ", "))
+	}
+
+	if movement.Type == models.MovementAdjustment {
+		if movement.Quantity == 0 {
+			return fmt.Errorf("%w: an adjustment needs a non-zero quantity", repository.ErrInvalidRecord)
+		}
+		if strings.TrimSpace(movement.Reason) == "" {
+			return fmt.Errorf("%w: an adjustment needs a reason", repository.ErrInvalidRecord)
+		}
+		return nil
+	}
+
+	if movement.Quantity <= 0 {
+		return fmt.Errorf("%w: a %s needs a positive quantity", repository.ErrInvalidRecord, movement.Type)
+	}
+	return nil
+}
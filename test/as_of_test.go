@@ -0,0 +1,82 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCatalogAsOf(t *testing.T) {
+	_, svc, ctrl, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// pause keeps revision timestamps strictly apart from the marks taken
+	// between them.
+	pause := func() time.Time {
+		time.Sleep(2 * time.Millisecond)
+		mark := time.Now()
+		time.Sleep(2 * time.Millisecond)
+		return mark
+	}
+
+	beforeAll := pause()
+	kept, err := svc.Create(models.Book{Title: "Kept", Price: 10, Quantity: 5}, "")
+	assert.NoError(t, err)
+	gone, err := svc.Create(models.Book{Title: "Gone", Price: 20}, "")
+	assert.NoError(t, err)
+	monthEnd := pause()
+
+	kept.Price = 15
+	kept.Quantity = 3
+	_, err = svc.Update(kept.BookID, *kept, "")
+	assert.NoError(t, err)
+	assert.NoError(t, svc.Delete(gone.BookID, 0, ""))
+	assert.NoError(t, svc.Purge(gone.BookID, ""))
+
+
+	books, count, err := svc.GetAllAsOf(10, 0, models.BookFilter{}, monthEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, "Kept", books[0].Title)
+	assert.Equal(t, 10.0, books[0].Price)
+	assert.Equal(t, 5, books[0].Quantity)
+	assert.Equal(t, "Gone", books[1].Title)
+
+	_, count, err = svc.GetAllAsOf(10, 0, models.BookFilter{}, beforeAll)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	books, count, err = svc.GetAllAsOf(10, 0, models.BookFilter{}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 15.0, books[0].Price)
+
+
+	router := mux.NewRouter()
+	ctrl.RegisterRoutes(router)
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	asOf := url.QueryEscape(monthEnd.Format(time.RFC3339Nano))
+	rr := get("/books/" + gone.BookID + "?asOf=" + asOf)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var book models.Book
+	json.Unmarshal(rr.Body.Bytes(), &book)
+	assert.Equal(t, "Gone", book.Title)
+
+	assert.Equal(t, http.StatusNotFound, get("/books/"+gone.BookID).Code)
+	assert.Equal(t, http.StatusNotFound, get("/books/"+kept.BookID+"?asOf="+url.QueryEscape(beforeAll.Format(time.RFC3339Nano))).Code)
+	assert.Equal(t, http.StatusBadRequest, get("/books?asOf=yesterday").Code)
+}
@@ -0,0 +1,195 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crud-in-go-lang/internal/controller"
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/router"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupRecords serves books along with the authors and publishers they
+// must refer to, deleted under policy.
+func setupRecords(t *testing.T, policy service.DeletePolicy) (*service.BookService, *mux.Router) {
+	repo, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+
+	authors := repository.NewMemoryAuthorRepository()
+	publishers := repository.NewMemoryPublisherRepository()
+	svc := service.NewBookService(repo, service.WithAuthors(authors), service.WithPublishers(publishers))
+	r := router.SetupRouter(controller.NewBookController(svc), controller.NewAdminController(svc),
+		controller.NewReplicationController(repository.NewChangeLog(repo, 0), nil),
+		controller.NewAuthorController(service.NewAuthorService(authors, svc, policy)),
+		controller.NewPublisherController(service.NewPublisherService(publishers, svc, policy)))
+
+	return svc, r
+}
+
+func serveJSON(r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// createRecord posts record to path and returns the record created.
+func createRecord[T any](t *testing.T, r http.Handler, path string, record T) T {
+	rr := serveJSON(r, "POST", path, record)
+	assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var created T
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	return created
+}
+
+func TestAuthorCRUD(t *testing.T) {
+	_, r := setupRecords(t, service.DeleteRestrict)
+
+	author := createRecord(t, r, "/authors", models.Author{Name: "Ursula K. Le Guin", BirthDate: "1929-10-21", DeathDate: "2018-01-22", Nationality: "American"})
+	assert.NotEmpty(t, author.AuthorID)
+
+	rr := serveJSON(r, "GET", "/authors/"+author.AuthorID, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	author.Bio = "Wrote Earthsea."
+	rr = serveJSON(r, "PUT", "/authors/"+author.AuthorID, author)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var updated models.Author
+	json.Unmarshal(rr.Body.Bytes(), &updated)
+	assert.Equal(t, "Wrote Earthsea.", updated.Bio)
+
+	rr = serveJSON(r, "GET", "/authors", nil)
+	var page struct {
+		Authors    []models.Author `json:"authors"`
+		TotalCount int             `json:"total_count"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	assert.Equal(t, 1, page.TotalCount)
+	assert.Len(t, page.Authors, 1)
+
+	rr = serveJSON(r, "DELETE", "/authors/"+author.AuthorID, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = serveJSON(r, "GET", "/authors/"+author.AuthorID, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAuthorValidation(t *testing.T) {
+	_, r := setupRecords(t, service.DeleteRestrict)
+
+	assert.Equal(t, http.StatusBadRequest, serveJSON(r, "POST", "/authors", models.Author{}).Code)
+	assert.Equal(t, http.StatusBadRequest, serveJSON(r, "POST", "/authors", models.Author{Name: "X", BirthDate: "yesterday"}).Code)
+	assert.Equal(t, http.StatusBadRequest, serveJSON(r, "POST", "/authors",
+		models.Author{Name: "X", BirthDate: "1950-01-01", DeathDate: "1949-01-01"}).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(r, "PUT", "/authors/missing", models.Author{Name: "X"}).Code)
+}
+
+func TestBooksMustReferenceExistingAuthors(t *testing.T) {
+	svc, r := setupRecords(t, service.DeleteRestrict)
+	author := createRecord(t, r, "/authors", models.Author{Name: "Frank Herbert"})
+
+	rr := serveJSON(r, "POST", "/books", models.Book{Title: "Dune", AuthorID: "nobody"})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = serveJSON(r, "POST", "/books", models.Book{Title: "Anonymous"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	book, err := svc.Create(models.Book{Title: "Dune", AuthorID: author.AuthorID}, "alice")
+	assert.NoError(t, err)
+
+	book.AuthorID = "nobody"
+	_, err = svc.Update(book.BookID, *book, "alice")
+	assert.ErrorIs(t, err, repository.ErrInvalidReference)
+
+	_, err = svc.ApplyBatch([]models.BatchOp{
+		{Action: models.ActionCreate, Book: models.Book{Title: "Fine", AuthorID: author.AuthorID}},
+		{Action: models.ActionCreate, Book: models.Book{Title: "Dangling", AuthorID: "nobody"}},
+	}, "alice")
+	assert.ErrorIs(t, err, repository.ErrInvalidReference)
+	count, _ := svc.Count()
+	assert.Equal(t, 2, count)
+}
+
+func TestAuthorBooks(t *testing.T) {
+	svc, r := setupRecords(t, service.DeleteRestrict)
+	herbert := createRecord(t, r, "/authors", models.Author{Name: "Frank Herbert"})
+	austen := createRecord(t, r, "/authors", models.Author{Name: "Jane Austen"})
+
+	for _, title := range []string{"Dune", "Dune Messiah", "Children of Dune"} {
+		_, err := svc.Create(models.Book{Title: title, AuthorID: herbert.AuthorID}, "alice")
+		assert.NoError(t, err)
+	}
+	_, err := svc.Create(models.Book{Title: "Emma", AuthorID: austen.AuthorID}, "alice")
+	assert.NoError(t, err)
+
+	rr := serveJSON(r, "GET", "/authors/"+herbert.AuthorID+"/books?limit=2", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var page struct {
+		Books      []models.Book `json:"books"`
+		TotalCount int           `json:"total_count"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	assert.Len(t, page.Books, 2)
+	assert.Equal(t, 3, page.TotalCount)
+
+	assert.Equal(t, http.StatusNotFound, serveJSON(r, "GET", "/authors/missing/books", nil).Code)
+}
+
+func TestDeleteAuthorWithBooks(t *testing.T) {
+	svc, r := setupRecords(t, service.DeleteRestrict)
+	author := createRecord(t, r, "/authors", models.Author{Name: "Frank Herbert"})
+	book, err := svc.Create(models.Book{Title: "Dune", AuthorID: author.AuthorID}, "alice")
+	assert.NoError(t, err)
+
+	rr := serveJSON(r, "DELETE", "/authors/"+author.AuthorID, nil)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, http.StatusOK, serveJSON(r, "GET", "/authors/"+author.AuthorID, nil).Code)
+
+
+	svc, r = setupRecords(t, service.DeleteCascade)
+	author = createRecord(t, r, "/authors", models.Author{Name: "Frank Herbert"})
+	book, err = svc.Create(models.Book{Title: "Dune", AuthorID: author.AuthorID}, "alice")
+	assert.NoError(t, err)
+
+	rr = serveJSON(r, "DELETE", "/authors/"+author.AuthorID, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	_, err = svc.GetByID(book.BookID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	trash, err := svc.GetTrash(10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, trash, 1)
+
+	// The book cannot come back without its author.
+	_, err = svc.Restore(book.BookID, "alice")
+	assert.ErrorIs(t, err, repository.ErrInvalidReference)
+}
+
+func TestFileAuthorRepositoryPersists(t *testing.T) {
+	filename := t.TempDir() + "/authors.json"
+	authors, err := repository.NewFileAuthorRepository(filename)
+	assert.NoError(t, err)
+
+	created, err := authors.Create(models.Author{Name: "Jane Austen"})
+	assert.NoError(t, err)
+
+	reopened, err := repository.NewFileAuthorRepository(filename)
+	assert.NoError(t, err)
+	author, err := reopened.GetByID(created.AuthorID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane Austen", author.Name)
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchIsAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+
+	file, err := repository.NewFileRepository(filepath.Join(dir, "file.json"))
+	assert.NoError(t, err)
+	journal, err := repository.NewJournalRepository(filepath.Join(dir, "journal.json"), 100)
+	assert.NoError(t, err)
+	memory, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+
+	repos := map[string]repository.BookRepository{"file": file, "journal": journal, "memory": memory}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			first, err := repo.Create(models.Book{Title: "First", Quantity: 1})
+			assert.NoError(t, err)
+			second, err := repo.Create(models.Book{Title: "Second", Quantity: 1})
+			assert.NoError(t, err)
+
+			_, err = repo.Apply([]models.BatchOp{
+				{Action: models.ActionUpdate, BookID: first.BookID, Book: models.Book{Title: "First", Quantity: 9}},
+				{Action: models.ActionUpdate, BookID: second.BookID, Book: models.Book{Title: "Second", Quantity: 9}, ExpectedVersion: 7},
+			})
+			assert.ErrorIs(t, err, repository.ErrVersionConflict)
+
+			unchanged, err := repo.GetByID(first.BookID)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, unchanged.Quantity)
+			assert.Equal(t, 1, unchanged.Version)
+
+
+			results, err := repo.Apply([]models.BatchOp{
+				{Action: models.ActionUpdate, BookID: first.BookID, Book: models.Book{Title: "First", Quantity: 9}, ExpectedVersion: 1},
+				{Action: models.ActionDelete, BookID: second.BookID},
+				{Action: models.ActionCreate, Book: models.Book{Title: "Third"}},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 3, len(results))
+			assert.Equal(t, 9, results[0].Quantity)
+			assert.NotNil(t, results[1].DeletedAt)
+			assert.Equal(t, 1, results[2].Version)
+
+			count, err := repo.Count()
+			assert.NoError(t, err)
+			assert.Equal(t, 2, count)
+		})
+	}
+
+
+	// The successful batch is a single journal line and survives a reopen.
+	data, err := os.ReadFile(filepath.Join(dir, "journal.json.journal"))
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Contains(t, lines[len(lines)-1], `"op":"batch"`)
+
+	reopened, err := repository.NewJournalRepository(filepath.Join(dir, "journal.json"), 100)
+	assert.NoError(t, err)
+	count, err := reopened.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestBatchEndpoint(t *testing.T) {
+	_, svc, ctrl, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	ctrl.RegisterRoutes(router)
+
+	book := createTestBooks(t, ctrl, 1)[0]
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/books/batch", bytes.NewBufferString(body))
+		req.Header.Set("X-Actor", "restock")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post(`{"operations":[{"action":"update","bookId":"` + book.BookID + `","book":{"title":"Restocked","quantity":40}},{"action":"create","book":{"title":"New"}}]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Books []models.Book `json:"books"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	assert.Equal(t, 2, len(response.Books))
+	assert.Equal(t, 40, response.Books[0].Quantity)
+
+	history, err := svc.GetHistory(book.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, "restock", history[1].Actor)
+	assert.Equal(t, book.Title, history[1].Before.Title)
+
+	assert.Equal(t, http.StatusBadRequest, post(`{"operations":[{"action":"rename","bookId":"x"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"operations":[]}`).Code)
+	assert.Equal(t, http.StatusNotFound, post(`{"operations":[{"action":"delete","bookId":"missing"}]}`).Code)
+}
@@ -40,6 +40,8 @@ func setupTestEnvironment(t *testing.T) (*repository.FileRepository, *service.Bo
 
 	cleanup := func() {
 		os.Remove(tmpFile.Name())
+		os.Remove(tmpFile.Name() + ".bak")
+		os.Remove(tmpFile.Name() + ".lock")
 	}
 	
 	return repo, svc, ctrl, cleanup
@@ -554,4 +556,29 @@ func TestConcurrentOperations(t *testing.T) {
 			assert.Contains(t, book.Title, "Updated")
 		}
 	}
-}
\ No newline at end of file
+}
+func TestGetAllBooksFiltered(t *testing.T) {
+	_, _, ctrl, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+	
+
+	books := createTestBooks(t, ctrl, 3)
+	
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/books?authorId=%s&genre=test+genre", books[1].AuthorID), nil)
+	rr := httptest.NewRecorder()
+	
+	router := mux.NewRouter()
+	router.HandleFunc("/books", ctrl.GetAll).Methods("GET")
+	router.ServeHTTP(rr, req)
+	
+	assert.Equal(t, http.StatusOK, rr.Code)
+	
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	
+	booksArray, ok := response["books"].([]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(booksArray))
+	assert.Equal(t, float64(1), response["total_count"])
+}
@@ -0,0 +1,225 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"crud-in-go-lang/internal/controller"
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingRepository counts the reads that reach the wrapped repository.
+type countingRepository struct {
+	repository.BookRepository
+	reads int
+}
+
+func (r *countingRepository) GetByID(id string) (*models.Book, error) {
+	r.reads++
+	return r.BookRepository.GetByID(id)
+}
+
+func (r *countingRepository) GetAll(params models.PaginationParams) ([]models.Book, error) {
+	r.reads++
+	return r.BookRepository.GetAll(params)
+}
+
+func (r *countingRepository) Count() (int, error) {
+	r.reads++
+	return r.BookRepository.Count()
+}
+
+func newCountingRepository(t *testing.T) *countingRepository {
+	repo, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+	return &countingRepository{BookRepository: repo}
+}
+
+func TestCachedRepositoryServesRepeatReadsFromMemory(t *testing.T) {
+	inner := newCountingRepository(t)
+	cache := repository.NewCachedRepository(inner, 0, time.Minute)
+
+	book, err := cache.Create(models.Book{Title: "Cached", Genre: "Fiction", Contributors: []models.Contributor{{ContributorID: "ed", Role: models.RoleEditor}}})
+	assert.NoError(t, err)
+
+	page := models.PaginationParams{Limit: 10, Filter: models.BookFilter{Genre: "fiction"}}
+	for i := 0; i < 3; i++ {
+		got, err := cache.GetByID(book.BookID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Cached", got.Title)
+
+		books, err := cache.GetAll(page)
+		assert.NoError(t, err)
+		assert.Len(t, books, 1)
+
+		count, err := cache.Count()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+	}
+
+	assert.Equal(t, 3, inner.reads)
+	stats := cache.Stats()
+	assert.Equal(t, uint64(6), stats.Hits)
+	assert.Equal(t, uint64(3), stats.Misses)
+	assert.Equal(t, 3, stats.Entries)
+
+
+	// Callers cannot change what the cache holds.
+	got, _ := cache.GetByID(book.BookID)
+	got.Title = "Changed by caller"
+	got.Contributors[0].Role = "changed"
+	books, _ := cache.GetAll(page)
+	books[0].Title = "Changed by caller"
+	books[0].Contributors[0].Role = "changed"
+
+	got, _ = cache.GetByID(book.BookID)
+	assert.Equal(t, "Cached", got.Title)
+	assert.Equal(t, models.RoleEditor, got.Contributors[0].Role)
+	books, _ = cache.GetAll(page)
+	assert.Equal(t, "Cached", books[0].Title)
+	assert.Equal(t, models.RoleEditor, books[0].Contributors[0].Role)
+}
+
+func TestCacheStatsEndpoint(t *testing.T) {
+	repo, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+	cache := repository.NewCachedRepository(repo, 0, time.Minute)
+
+	// The cache is found behind the change log wrapping it.
+	svc := service.NewBookService(repository.NewChangeLog(cache, 0))
+	book, err := svc.Create(models.Book{Title: "Cached"}, "alice")
+	assert.NoError(t, err)
+	cache.GetByID(book.BookID)
+	cache.GetByID(book.BookID)
+
+	r := mux.NewRouter()
+	controller.NewAdminController(svc).RegisterRoutes(r)
+	rr := serveJSON(r, "GET", "/admin/cache", nil)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var stats repository.CacheStats
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, cache.Stats(), stats)
+
+	uncached := mux.NewRouter()
+	controller.NewAdminController(service.NewBookService(repo)).RegisterRoutes(uncached)
+	rr = serveJSON(uncached, "GET", "/admin/cache", nil)
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+func TestCachedRepositoryInvalidatesOnWrites(t *testing.T) {
+	inner := newCountingRepository(t)
+	cache := repository.NewCachedRepository(inner, 0, time.Minute)
+
+	book, err := cache.Create(models.Book{Title: "Before"})
+	assert.NoError(t, err)
+	page := models.PaginationParams{Limit: 10}
+
+	_, _ = cache.GetByID(book.BookID)
+	_, _ = cache.GetAll(page)
+	_, _ = cache.Count()
+
+
+	book.Title = "After"
+	_, err = cache.Update(book.BookID, *book)
+	assert.NoError(t, err)
+
+	got, err := cache.GetByID(book.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, "After", got.Title)
+	books, err := cache.GetAll(page)
+	assert.NoError(t, err)
+	assert.Equal(t, "After", books[0].Title)
+
+
+	_, err = cache.Create(models.Book{Title: "Second"})
+	assert.NoError(t, err)
+	count, err := cache.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	books, err = cache.GetAll(page)
+	assert.NoError(t, err)
+	assert.Len(t, books, 2)
+
+
+	_, err = cache.Delete(book.BookID, 0)
+	assert.NoError(t, err)
+	_, err = cache.GetByID(book.BookID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	count, _ = cache.Count()
+	assert.Equal(t, 1, count)
+
+
+	_, err = cache.Restore(book.BookID)
+	assert.NoError(t, err)
+	_, err = cache.GetByID(book.BookID)
+	assert.NoError(t, err)
+
+
+	assert.NoError(t, cache.ReplaceAll(nil))
+	_, err = cache.GetByID(book.BookID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	count, _ = cache.Count()
+	assert.Equal(t, 0, count)
+}
+
+func TestCachedRepositoryExpiresAndEvicts(t *testing.T) {
+	inner := newCountingRepository(t)
+	cache := repository.NewCachedRepository(inner, 2, 20*time.Millisecond)
+
+	var ids []string
+	for _, title := range []string{"One", "Two", "Three"} {
+		book, err := cache.Create(models.Book{Title: title})
+		assert.NoError(t, err)
+		ids = append(ids, book.BookID)
+	}
+
+	for _, id := range ids {
+		_, err := cache.GetByID(id)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint64(1), cache.Stats().Evictions)
+
+	// The oldest book was evicted; the newest is still cached.
+	_, _ = cache.GetByID(ids[0])
+	assert.Equal(t, 4, inner.reads)
+	_, _ = cache.GetByID(ids[2])
+	assert.Equal(t, 4, inner.reads)
+
+
+	time.Sleep(30 * time.Millisecond)
+	_, _ = cache.GetByID(ids[2])
+	assert.Equal(t, 5, inner.reads)
+}
+
+func TestCachedRepositorySeesWatchedFileEdits(t *testing.T) {
+	filename := t.TempDir() + "/books.json"
+	repo, err := repository.NewFileRepository(filename, repository.WithWatchInterval(10*time.Millisecond))
+	assert.NoError(t, err)
+	defer repo.Close()
+
+	cache := repository.NewCachedRepository(repo, 0, time.Hour)
+	count, err := cache.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+
+	// A second repository on the same file stands in for an external edit.
+	other, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+	_, err = other.Create(models.Book{Title: "Edited elsewhere"})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		count, err := cache.Count()
+		return err == nil && count == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterBooksByContributor(t *testing.T) {
+	dir := t.TempDir()
+	dsns := []string{
+		"file://" + filepath.Join(dir, "file", "books.json"),
+		"memory://",
+		"sharded://" + filepath.Join(dir, "sharded") + "?shards=4",
+	}
+
+	for _, dsn := range dsns {
+		repo, err := repository.Open(dsn)
+		if !assert.NoError(t, err, dsn) {
+			continue
+		}
+
+		good, err := repo.Create(models.Book{Title: "Good Omens", AuthorID: "pratchett", Contributors: []models.Contributor{
+			{ContributorID: "pratchett", Role: models.RoleAuthor},
+			{ContributorID: "gaiman", Role: models.RoleCoAuthor},
+		}})
+		assert.NoError(t, err, dsn)
+		translated, err := repo.Create(models.Book{Title: "Translated", AuthorID: "eco", Contributors: []models.Contributor{
+			{ContributorID: "weaver", Role: models.RoleTranslator},
+		}})
+		assert.NoError(t, err, dsn)
+		_, err = repo.Create(models.Book{Title: "Sandman", AuthorID: "gaiman"})
+		assert.NoError(t, err, dsn)
+
+		titles := func(filter models.BookFilter) []string {
+			books, err := repo.GetAll(models.PaginationParams{Limit: -1, Filter: filter})
+			assert.NoError(t, err, dsn)
+			var titles []string
+			for _, book := range books {
+				titles = append(titles, book.Title)
+			}
+			return titles
+		}
+
+		assert.Equal(t, []string{"Good Omens", "Sandman"}, titles(models.BookFilter{ContributorID: "gaiman"}), dsn)
+		assert.Equal(t, []string{"Good Omens"}, titles(models.BookFilter{ContributorID: "gaiman", Role: models.RoleCoAuthor}), dsn)
+		assert.Equal(t, []string{"Sandman"}, titles(models.BookFilter{ContributorID: "gaiman", Role: models.RoleAuthor}), dsn)
+		assert.Equal(t, []string{"Translated"}, titles(models.BookFilter{Role: "Translator"}), dsn)
+		assert.Equal(t, []string{"Translated"}, titles(models.BookFilter{ContributorID: "eco"}), dsn)
+
+		book, err := repo.GetByID(good.BookID)
+		assert.NoError(t, err, dsn)
+		assert.Equal(t, good.Contributors, book.Contributors, dsn)
+
+
+		// Changing the credits moves the book between filters.
+		translated.Contributors = []models.Contributor{{ContributorID: "weaver", Role: models.RoleEditor}}
+		_, err = repo.Update(translated.BookID, *translated)
+		assert.NoError(t, err, dsn)
+		assert.Empty(t, titles(models.BookFilter{ContributorID: "weaver", Role: models.RoleTranslator}), dsn)
+		assert.Equal(t, []string{"Translated"}, titles(models.BookFilter{ContributorID: "weaver"}), dsn)
+	}
+}
+
+func TestContributorsOverHTTP(t *testing.T) {
+	svc, r := setupRecords(t, service.DeleteCascade)
+	pratchett := createRecord(t, r, "/authors", models.Author{Name: "Terry Pratchett"})
+	gaiman := createRecord(t, r, "/authors", models.Author{Name: "Neil Gaiman"})
+
+	// Roles are normalized and the first author becomes the primary one.
+	rr := serveJSON(r, "POST", "/books", models.Book{Title: "Good Omens", Contributors: []models.Contributor{
+		{ContributorID: pratchett.AuthorID, Role: "Author"},
+		{ContributorID: gaiman.AuthorID, Role: " co-author "},
+	}})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var book models.Book
+	json.Unmarshal(rr.Body.Bytes(), &book)
+	assert.Equal(t, pratchett.AuthorID, book.AuthorID)
+	assert.Equal(t, models.RoleCoAuthor, book.Contributors[1].Role)
+
+	rr = serveJSON(r, "GET", "/books?contributorId="+gaiman.AuthorID+"&role=co-author", nil)
+	var page struct {
+		Books      []models.Book `json:"books"`
+		TotalCount int           `json:"total_count"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	assert.Equal(t, 1, page.TotalCount)
+
+	rr = serveJSON(r, "GET", "/authors/"+gaiman.AuthorID+"/books", nil)
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	assert.Equal(t, 1, page.TotalCount)
+
+
+	rr = serveJSON(r, "POST", "/books", models.Book{Title: "Bad role", Contributors: []models.Contributor{
+		{ContributorID: gaiman.AuthorID, Role: "ghostwriter"},
+	}})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = serveJSON(r, "POST", "/books", models.Book{Title: "Unknown", Contributors: []models.Contributor{
+		{ContributorID: "nobody", Role: models.RoleEditor},
+	}})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+
+	// Cascading drops a co-author's credit but keeps the book.
+	assert.Equal(t, http.StatusNoContent, serveJSON(r, "DELETE", "/authors/"+gaiman.AuthorID, nil).Code)
+	kept, err := svc.GetByID(book.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Contributor{{ContributorID: pratchett.AuthorID, Role: models.RoleAuthor}}, kept.Contributors)
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenBuiltinDrivers(t *testing.T) {
+	dir := t.TempDir()
+
+	dsns := []string{
+		"file://" + filepath.Join(dir, "file", "books.json"),
+		"journal://" + filepath.Join(dir, "journal", "books.json") + "?compactAfter=10",
+		"memory://",
+		"memory://" + filepath.Join(dir, "memory", "books.json"),
+		"json-dir://" + filepath.Join(dir, "dir"),
+		"sharded://" + filepath.Join(dir, "sharded") + "?shards=8",
+	}
+
+	for _, dsn := range dsns {
+		repo, err := repository.Open(dsn)
+		if !assert.NoError(t, err, dsn) {
+			continue
+		}
+
+		created, err := repo.Create(models.Book{Title: "Driver test"})
+		assert.NoError(t, err, dsn)
+
+		book, err := repo.GetByID(created.BookID)
+		assert.NoError(t, err, dsn)
+		assert.Equal(t, "Driver test", book.Title, dsn)
+	}
+}
+
+func TestOpenJSONDirReloads(t *testing.T) {
+	dsn := "json-dir://" + t.TempDir()
+
+	repo, err := repository.Open(dsn)
+	assert.NoError(t, err)
+
+	first, _ := repo.Create(models.Book{Title: "First"})
+	second, _ := repo.Create(models.Book{Title: "Second"})
+	_, err = repo.Delete(first.BookID, 0)
+	assert.NoError(t, err)
+
+	reopened, err := repository.Open(dsn)
+	assert.NoError(t, err)
+
+	books, err := reopened.GetAll(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+	assert.Equal(t, second.BookID, books[0].BookID)
+}
+
+func TestOpenUnknownScheme(t *testing.T) {
+	_, err := repository.Open("nosuch://somewhere")
+	assert.Error(t, err)
+
+	_, err = repository.Open("no-scheme")
+	assert.Error(t, err)
+}
+
+// registerCustomDriver registers the test-custom scheme once per test
+// binary, however many times the test runs.
+var registerCustomDriver sync.Once
+
+func TestRegisterCustomDriver(t *testing.T) {
+	open := repository.DriverFunc(func(dsn string) (repository.BookRepository, error) {
+		return repository.NewMemoryRepository(nil)
+	})
+	registerCustomDriver.Do(func() { repository.Register("test-custom", open) })
+
+	assert.Contains(t, repository.Drivers(), "test-custom")
+	assert.Panics(t, func() { repository.Register("test-custom", open) })
+
+	repo, err := repository.Open("test-custom://anything")
+	assert.NoError(t, err)
+
+	count, err := repo.Count()
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}
@@ -0,0 +1,200 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	oldKey = "k1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	newKey = "k2:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func keyIDOf(t *testing.T, filename string) string {
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	var doc struct {
+		KeyID string `json:"keyId"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &doc))
+	return doc.KeyID
+}
+
+func TestFileRepositoryEncryptsAtRest(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	plain, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+	_, err = plain.Create(models.Book{Title: "Secret Garden", ISBN: "978-0-00-000001-1", Price: 42.5})
+	assert.NoError(t, err)
+	_, err = plain.Create(models.Book{Title: "Second", ISBN: "978-0-00-000002-8"})
+	assert.NoError(t, err)
+
+
+	keyring, err := repository.ParseKeyring(oldKey)
+	assert.NoError(t, err)
+	repo, err := repository.NewFileRepository(filename, repository.WithKeyring(keyring))
+	assert.NoError(t, err)
+
+	for _, name := range []string{filename, filename + ".bak"} {
+		data, err := os.ReadFile(name)
+		assert.NoError(t, err)
+		assert.False(t, bytes.Contains(data, []byte("Secret Garden")), name)
+		assert.False(t, bytes.Contains(data, []byte("42.5")), name)
+		assert.Equal(t, "k1", keyIDOf(t, name))
+	}
+
+	count, err := repo.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+
+	// Rotation: the old key still reads, the next write uses the new one.
+	rotated, err := repository.ParseKeyring(newKey + "," + oldKey)
+	assert.NoError(t, err)
+	repo, err = repository.NewFileRepository(filename, repository.WithKeyring(rotated))
+	assert.NoError(t, err)
+
+	book, err := repo.GetByISBN("9780000000011")
+	assert.NoError(t, err)
+	assert.Equal(t, "Secret Garden", book.Title)
+	assert.Equal(t, "k1", keyIDOf(t, filename))
+
+	book.Price = 50
+	_, err = repo.Update(book.BookID, *book)
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", keyIDOf(t, filename))
+}
+
+func TestFileRepositoryMissingKey(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "books.json")
+
+	keyring, err := repository.ParseKeyring(oldKey)
+	assert.NoError(t, err)
+	repo, err := repository.NewFileRepository(filename, repository.WithKeyring(keyring))
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{Title: "Locked"})
+	assert.NoError(t, err)
+
+
+	_, err = repository.NewFileRepository(filename)
+	assert.True(t, errors.Is(err, repository.ErrDecryption))
+
+	other, err := repository.ParseKeyring(newKey)
+	assert.NoError(t, err)
+	_, err = repository.NewFileRepository(filename, repository.WithKeyring(other))
+	assert.True(t, errors.Is(err, repository.ErrDecryption))
+
+	// The encrypted file is intact, not mistaken for corruption.
+	matches, _ := filepath.Glob(filepath.Join(dir, "*corrupt*"))
+	assert.Empty(t, matches)
+
+	repo, err = repository.NewFileRepository(filename, repository.WithKeyring(keyring))
+	assert.NoError(t, err)
+	count, err := repo.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestSnapshotsInheritKeyring(t *testing.T) {
+	dir := t.TempDir()
+
+	keyring, err := repository.ParseKeyring(oldKey)
+	assert.NoError(t, err)
+	repo, err := repository.NewFileRepository(filepath.Join(dir, "books.json"), repository.WithKeyring(keyring))
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{Title: "Snapshotted Secret"})
+	assert.NoError(t, err)
+
+	snapshots, err := repository.NewSnapshotManager(repo, filepath.Join(dir, "snapshots"), 0)
+	assert.NoError(t, err)
+	manifest, err := snapshots.Create()
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dir, "snapshots", manifest.ID, "books.json"))
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(data, []byte("Snapshotted Secret")))
+
+	books, _, err := snapshots.Load(manifest.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+}
+
+func TestHistoryLedgerAndReservationsEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	historyFile := filepath.Join(dir, "history.jsonl")
+	ledgerFile := filepath.Join(dir, "stock.jsonl")
+	reservationsFile := filepath.Join(dir, "reservations.json")
+
+	// Records written before a key was configured are encrypted on open.
+	plainHistory, err := repository.NewFileRevisionRepository(historyFile)
+	assert.NoError(t, err)
+	_, err = plainHistory.Append(models.Revision{BookID: "b1", Action: "create", After: &models.Book{BookID: "b1", Title: "Plain Secret"}})
+	assert.NoError(t, err)
+
+	keyring, err := repository.ParseKeyring(oldKey)
+	assert.NoError(t, err)
+	repo, err := repository.NewFileRepository(filepath.Join(dir, "books.json"), repository.WithKeyring(keyring))
+	assert.NoError(t, err)
+	encrypt := repository.WithKeyring(repository.EncryptionKeyring(repository.NewCachedRepository(repo, 0, 0)))
+
+	history, err := repository.NewFileRevisionRepository(historyFile, encrypt)
+	assert.NoError(t, err)
+	_, err = history.Append(models.Revision{BookID: "b1", Action: "update", After: &models.Book{BookID: "b1", Title: "Revised Secret"}})
+	assert.NoError(t, err)
+
+	ledger, err := repository.NewFileStockLedger(ledgerFile, encrypt)
+	assert.NoError(t, err)
+	_, err = ledger.Append(models.StockMovement{BookID: "b1", Type: models.MovementReceipt, Quantity: 3, Reference: "Secret PO"})
+	assert.NoError(t, err)
+	assert.NoError(t, ledger.Close())
+
+	reservations, err := repository.NewFileReservationRepository(reservationsFile, encrypt)
+	assert.NoError(t, err)
+	_, err = reservations.Create(models.Reservation{BookID: "b1", Quantity: 1, Reference: "Secret cart"})
+	assert.NoError(t, err)
+
+	for _, name := range []string{historyFile, ledgerFile, reservationsFile} {
+		data, err := os.ReadFile(name)
+		assert.NoError(t, err)
+		assert.False(t, bytes.Contains(data, []byte("Secret")), name)
+	}
+
+
+	reopened, err := repository.NewFileRevisionRepository(historyFile, encrypt)
+	assert.NoError(t, err)
+	revisions, err := reopened.List("b1")
+	assert.NoError(t, err)
+	if assert.Len(t, revisions, 2) {
+		assert.Equal(t, "Plain Secret", revisions[0].After.Title)
+		assert.Equal(t, "Revised Secret", revisions[1].After.Title)
+	}
+
+	reopenedLedger, err := repository.NewFileStockLedger(ledgerFile, encrypt)
+	assert.NoError(t, err)
+	defer reopenedLedger.Close()
+	movements, err := reopenedLedger.List("b1")
+	assert.NoError(t, err)
+	assert.Len(t, movements, 1)
+
+	held, err := repository.NewFileReservationRepository(reservationsFile, encrypt)
+	assert.NoError(t, err)
+	kept, err := held.List("b1")
+	assert.NoError(t, err)
+	assert.Len(t, kept, 1)
+
+	// Without the key, the history cannot be read rather than looking empty.
+	_, err = repository.NewFileRevisionRepository(historyFile)
+	assert.True(t, errors.Is(err, repository.ErrDecryption))
+}
@@ -0,0 +1,110 @@
+//go:build unix
+
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"crud-in-go-lang/internal/controller"
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileRepositoriesShareFileWithoutLosingWrites(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	// Two repositories stand in for two processes: they share nothing but
+	// the file.
+	first, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+	second, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i, repo := range []*repository.FileRepository{first, second} {
+		wg.Add(1)
+		go func(i int, repo *repository.FileRepository) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, err := repo.Create(models.Book{Title: fmt.Sprintf("Writer %d book %d", i, j)})
+				assert.NoError(t, err)
+			}
+		}(i, repo)
+	}
+	wg.Wait()
+
+	for _, repo := range []*repository.FileRepository{first, second} {
+		count, err := repo.Count()
+		assert.NoError(t, err)
+		assert.Equal(t, 40, count)
+	}
+}
+
+func TestFileRepositorySeesExternalEdits(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	repo, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{Title: "Original"})
+	assert.NoError(t, err)
+
+	count, err := repo.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+
+	edited := `{"formatVersion": 2, "books": [
+	  {"bookId": "a", "title": "Edited A", "version": 1},
+	  {"bookId": "b", "title": "Edited B", "version": 1}
+	]}`
+	assert.NoError(t, os.WriteFile(filename, []byte(edited), 0644))
+
+	book, err := repo.GetByID("b")
+	assert.NoError(t, err)
+	assert.Equal(t, "Edited B", book.Title)
+}
+
+func TestFileRepositoryLockTimeout(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	repo, err := repository.NewFileRepository(filename, repository.WithLockTimeout(50*time.Millisecond))
+	assert.NoError(t, err)
+	book, err := repo.Create(models.Book{Title: "Stored"})
+	assert.NoError(t, err)
+
+
+	// Hold the lock the way another process would.
+	lock, err := os.OpenFile(filename+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	assert.NoError(t, err)
+	defer lock.Close()
+	assert.NoError(t, syscall.Flock(int(lock.Fd()), syscall.LOCK_EX))
+
+	_, err = repo.Create(models.Book{Title: "Blocked"})
+	assert.True(t, errors.Is(err, repository.ErrLockTimeout))
+	_, err = repo.Count()
+	assert.True(t, errors.Is(err, repository.ErrLockTimeout))
+
+	// A read that timed out is not a missing book.
+	r := mux.NewRouter()
+	controller.NewBookController(service.NewBookService(repo)).RegisterRoutes(r)
+	rr := serveJSON(r, "GET", "/books/"+book.BookID, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, rr.Body.String())
+
+
+	assert.NoError(t, syscall.Flock(int(lock.Fd()), syscall.LOCK_UN))
+
+	_, err = repo.Create(models.Book{Title: "Unblocked"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileRepositoryKeepsBackupGeneration(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	repo, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+
+	_, err = repo.Create(models.Book{Title: "First"})
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{Title: "Second"})
+	assert.NoError(t, err)
+
+
+	backup, err := os.ReadFile(filename + ".bak")
+	assert.NoError(t, err)
+	assert.Contains(t, string(backup), "First")
+	assert.NotContains(t, string(backup), "Second")
+}
+
+func TestFileRepositoryRecoversFromCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "books.json")
+
+	repo, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+
+	_, err = repo.Create(models.Book{Title: "Survivor"})
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{Title: "Lost"})
+	assert.NoError(t, err)
+
+
+	assert.NoError(t, os.WriteFile(filename, []byte(`[{"bookId": "trunc`), 0644))
+	assert.NoError(t, os.WriteFile(filename+".tmp-123", []byte(`[`), 0644))
+
+	repo, err = repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+
+	books, err := repo.GetAll(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+	assert.Equal(t, "Survivor", books[0].Title)
+
+	_, err = os.Stat(filename + ".tmp-123")
+	assert.True(t, os.IsNotExist(err))
+
+	corrupt, _ := filepath.Glob(filename + ".corrupt-*")
+	assert.Equal(t, 1, len(corrupt))
+}
+
+func TestFileRepositoryFailsWithoutValidBackup(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+	assert.NoError(t, os.WriteFile(filename, []byte(`not json`), 0644))
+
+	_, err := repository.NewFileRepository(filename)
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const legacyBooks = `[
+  {"bookId": "old", "title": "Unversioned"},
+  {"bookId": "new", "title": "Versioned", "version": 4}
+]`
+
+func TestMigrateFileDryRun(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+	assert.NoError(t, os.WriteFile(filename, []byte(legacyBooks), 0644))
+
+	report, err := repository.MigrateFile(filename, true)
+	assert.NoError(t, err)
+	assert.True(t, report.Needed())
+	assert.Equal(t, 1, report.FromVersion)
+	assert.Equal(t, repository.CurrentFormatVersion, report.ToVersion)
+	assert.Equal(t, []string{"old"}, report.Changed)
+
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, legacyBooks, string(data))
+}
+
+func TestFileRepositoryMigratesOnOpen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+	assert.NoError(t, os.WriteFile(filename, []byte(legacyBooks), 0644))
+
+	repo, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+
+	old, err := repo.GetByID("old")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, old.Version)
+
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	var document struct {
+		FormatVersion int           `json:"formatVersion"`
+		Books         []models.Book `json:"books"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &document))
+	assert.Equal(t, repository.CurrentFormatVersion, document.FormatVersion)
+	assert.Equal(t, 2, len(document.Books))
+
+	backup, err := os.ReadFile(filename + ".bak")
+	assert.NoError(t, err)
+	assert.Equal(t, legacyBooks, string(backup))
+
+	report, err := repository.MigrateFile(filename, false)
+	assert.NoError(t, err)
+	assert.False(t, report.Needed())
+}
+
+func TestFileRepositoryRejectsNewerFormat(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+	assert.NoError(t, os.WriteFile(filename, []byte(`{"formatVersion": 99, "books": []}`), 0644))
+
+	_, err := repository.MigrateFile(filename, true)
+	assert.ErrorIs(t, err, repository.ErrUnsupportedFormat)
+
+	_, err = repository.NewFileRepository(filename)
+	assert.ErrorIs(t, err, repository.ErrUnsupportedFormat)
+
+	corrupt, _ := filepath.Glob(filename + ".corrupt-*")
+	assert.Empty(t, corrupt)
+}
@@ -0,0 +1,120 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookHistoryAndDiff(t *testing.T) {
+	_, _, ctrl, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	ctrl.RegisterRoutes(router)
+
+	do := func(method, path, actor string, body interface{}) *httptest.ResponseRecorder {
+		var payload bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&payload).Encode(body)
+		}
+		req, _ := http.NewRequest(method, path, &payload)
+		if actor != "" {
+			req.Header.Set("X-Actor", actor)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+
+	rr := do("POST", "/books", "alice", models.Book{Title: "Draft", Price: 10})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var book models.Book
+	json.Unmarshal(rr.Body.Bytes(), &book)
+
+	book.Title = "Final"
+	book.Price = 12
+	assert.Equal(t, http.StatusOK, do("PUT", "/books/"+book.BookID, "bob", book).Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/books/"+book.BookID, "", nil).Code)
+
+
+	rr = do("GET", "/books/"+book.BookID+"/history", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var history struct {
+		Revisions []models.Revision `json:"revisions"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &history)
+	assert.Equal(t, 3, len(history.Revisions))
+	assert.Equal(t, models.ActionCreate, history.Revisions[0].Action)
+	assert.Equal(t, "alice", history.Revisions[0].Actor)
+	assert.Equal(t, "bob", history.Revisions[1].Actor)
+	assert.Equal(t, "anonymous", history.Revisions[2].Actor)
+	assert.Equal(t, models.ActionDelete, history.Revisions[2].Action)
+
+	rr = do("GET", "/books/"+book.BookID+"/history/2", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var revision models.Revision
+	json.Unmarshal(rr.Body.Bytes(), &revision)
+	assert.Equal(t, "Draft", revision.Before.Title)
+	assert.Equal(t, "Final", revision.After.Title)
+
+	assert.Equal(t, http.StatusNotFound, do("GET", "/books/"+book.BookID+"/history/9", "", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/books/"+book.BookID+"/history/x", "", nil).Code)
+
+
+	rr = do("GET", "/books/"+book.BookID+"/diff?from=1&to=2", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var diff models.RevisionDiff
+	json.Unmarshal(rr.Body.Bytes(), &diff)
+
+	changed := make(map[string]bool)
+	for _, change := range diff.Changes {
+		changed[change.Field] = true
+	}
+	assert.True(t, changed["title"])
+	assert.True(t, changed["price"])
+	assert.True(t, changed["version"])
+	assert.False(t, changed["bookId"])
+}
+
+func TestFileRevisionRepositoryReplays(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history.jsonl")
+
+	revisions, err := repository.NewFileRevisionRepository(filename)
+	assert.NoError(t, err)
+
+	for _, title := range []string{"One", "Two"} {
+		_, err := revisions.Append(models.Revision{BookID: "b1", Action: models.ActionUpdate, After: &models.Book{Title: title}})
+		assert.NoError(t, err)
+	}
+	_, err = revisions.Append(models.Revision{BookID: "b2", Action: models.ActionCreate})
+	assert.NoError(t, err)
+	assert.NoError(t, revisions.Close())
+
+
+	revisions, err = repository.NewFileRevisionRepository(filename)
+	assert.NoError(t, err)
+	defer revisions.Close()
+
+	list, err := revisions.List("b1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(list))
+
+	rev, err := revisions.Get("b1", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "Two", rev.After.Title)
+
+	next, err := revisions.Append(models.Revision{BookID: "b1", Action: models.ActionDelete})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, next.Rev)
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalRepositoryReplaysJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	repo, err := repository.NewJournalRepository(filename, 100)
+	assert.NoError(t, err)
+
+	kept, err := repo.Create(models.Book{Title: "Kept"})
+	assert.NoError(t, err)
+	removed, err := repo.Create(models.Book{Title: "Removed"})
+	assert.NoError(t, err)
+
+	kept.Title = "Kept and renamed"
+	_, err = repo.Update(kept.BookID, *kept)
+	assert.NoError(t, err)
+	_, err = repo.Delete(removed.BookID, 0)
+	assert.NoError(t, err)
+
+
+	// Simulate a crash halfway through appending a record.
+	journal, err := os.OpenFile(filename+".journal", os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	journal.Write([]byte(`{"op":"put","id":"x","book":{"bookI`))
+	journal.Close()
+
+	reopened, err := repository.NewJournalRepository(filename, 100)
+	assert.NoError(t, err)
+
+	books, err := reopened.GetAll(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+	assert.Equal(t, "Kept and renamed", books[0].Title)
+
+	_, err = reopened.Create(models.Book{Title: "After recovery"})
+	assert.NoError(t, err)
+
+	count, err := reopened.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestJournalRepositoryReplaysBatchesInOrder(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	repo, err := repository.NewJournalRepository(filename, 100)
+	assert.NoError(t, err)
+
+	var ops []models.BatchOp
+	for i := 0; i < 8; i++ {
+		ops = append(ops, models.BatchOp{Action: models.ActionCreate, Book: models.Book{BookID: fmt.Sprint(i), Title: fmt.Sprint("Book ", i)}})
+	}
+	_, err = repo.Apply(ops)
+	assert.NoError(t, err)
+
+	// Reopened without Close, as after a crash, so the journal is replayed.
+	reopened, err := repository.NewJournalRepository(filename, 100)
+	assert.NoError(t, err)
+
+	books, err := reopened.GetAll(models.PaginationParams{Limit: -1})
+	assert.NoError(t, err)
+	var ids []string
+	for _, book := range books {
+		ids = append(ids, book.BookID)
+	}
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7"}, ids)
+}
+
+func TestJournalRepositoryCompacts(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	repo, err := repository.NewJournalRepository(filename, 3)
+	assert.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		_, err := repo.Create(models.Book{Title: "Book"})
+		assert.NoError(t, err)
+	}
+
+
+	snapshot, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+	count, err := snapshot.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	assert.NoError(t, repo.Close())
+	count, err = snapshot.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	info, err := os.Stat(filename + ".journal")
+	assert.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
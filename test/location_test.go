@@ -0,0 +1,204 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func transfer(t *testing.T, r http.Handler, bookID string, transfer models.StockTransfer, status int) []models.StockMovement {
+	rr := serveJSON(r, "POST", "/books/"+bookID+"/stock/transfers", transfer)
+	assert.Equal(t, status, rr.Code, rr.Body.String())
+
+	var response struct {
+		Movements []models.StockMovement `json:"movements"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	return response.Movements
+}
+
+func TestLocationCRUD(t *testing.T) {
+	_, _, r := setupStock(t, service.BackordersRejected)
+
+	warehouse := createRecord(t, r, "/locations", models.Location{Name: "Central warehouse", Type: "Warehouse", Address: "1 Dock Rd"})
+	assert.NotEmpty(t, warehouse.LocationID)
+	assert.Equal(t, models.LocationWarehouse, warehouse.Type)
+
+	warehouse.Name = "North warehouse"
+	rr := serveJSON(r, "PUT", "/locations/"+warehouse.LocationID, warehouse)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "North warehouse")
+
+	rr = serveJSON(r, "POST", "/locations", models.Location{Name: "Kiosk", Type: "kiosk"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = serveJSON(r, "POST", "/locations", models.Location{Type: models.LocationBranch})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveJSON(r, "DELETE", "/locations/"+warehouse.LocationID, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = serveJSON(r, "GET", "/locations/"+warehouse.LocationID, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestStockByLocation(t *testing.T) {
+	svc, _, r := setupStock(t, service.BackordersRejected)
+	warehouse := createRecord(t, r, "/locations", models.Location{Name: "Warehouse", Type: models.LocationWarehouse})
+	shop := createRecord(t, r, "/locations", models.Location{Name: "High Street", Type: models.LocationBranch})
+
+	book, err := svc.Create(models.Book{Title: "Dune", Quantity: 2}, "alice")
+	assert.NoError(t, err)
+	other, err := svc.Create(models.Book{Title: "Anathem"}, "alice")
+	assert.NoError(t, err)
+
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "receipt", Quantity: 10, LocationID: warehouse.LocationID}, http.StatusCreated)
+	recordMovement(t, r, other.BookID, models.StockMovement{Type: "receipt", Quantity: 1, LocationID: warehouse.LocationID}, http.StatusCreated)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "receipt", Quantity: 1, LocationID: "nowhere"}, http.StatusUnprocessableEntity)
+
+	movements := transfer(t, r, book.BookID, models.StockTransfer{From: warehouse.LocationID, To: shop.LocationID, Quantity: 4, Reference: "TR-1"}, http.StatusCreated)
+	if assert.Len(t, movements, 2) {
+		assert.Equal(t, models.MovementTransferOut, movements[0].Type)
+		assert.Equal(t, warehouse.LocationID, movements[0].LocationID)
+		assert.Equal(t, models.MovementTransferIn, movements[1].Type)
+		assert.Equal(t, shop.LocationID, movements[1].LocationID)
+		assert.NotEmpty(t, movements[0].TransferID)
+		assert.Equal(t, movements[0].TransferID, movements[1].TransferID)
+		assert.Equal(t, 12, movements[1].Balance)
+	}
+
+	// Unassigned copies can be moved into a location as well.
+	transfer(t, r, book.BookID, models.StockTransfer{To: shop.LocationID, Quantity: 2}, http.StatusCreated)
+	transfer(t, r, book.BookID, models.StockTransfer{From: shop.LocationID, To: warehouse.LocationID, Quantity: 7}, http.StatusConflict)
+	transfer(t, r, book.BookID, models.StockTransfer{From: shop.LocationID, To: shop.LocationID, Quantity: 1}, http.StatusBadRequest)
+
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "sale", Quantity: 7, LocationID: shop.LocationID}, http.StatusConflict)
+	response := recordMovement(t, r, book.BookID, models.StockMovement{Type: "sale", Quantity: 1, LocationID: shop.LocationID}, http.StatusCreated)
+	assert.Equal(t, 11, response.Book.Quantity)
+
+	levels := stockLevels(t, r, book.BookID)
+	assert.Equal(t, 11, levels.OnHand)
+	assert.ElementsMatch(t, []models.LocationStock{
+		{LocationID: warehouse.LocationID, OnHand: 6},
+		{LocationID: shop.LocationID, OnHand: 5},
+	}, levels.Locations)
+
+	rr := serveJSON(r, "GET", "/books?locationId="+shop.LocationID, nil)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var listing struct {
+		Books      []models.Book `json:"books"`
+		TotalCount int           `json:"total_count"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &listing)
+	assert.Equal(t, 1, listing.TotalCount)
+	if assert.Len(t, listing.Books, 1) {
+		assert.Equal(t, book.BookID, listing.Books[0].BookID)
+	}
+
+	// Books come in catalog order, as in every other listing.
+	rr = serveJSON(r, "GET", "/books?locationId="+warehouse.LocationID, nil)
+	json.Unmarshal(rr.Body.Bytes(), &listing)
+	assert.Equal(t, 2, listing.TotalCount)
+	if assert.Len(t, listing.Books, 2) {
+		assert.Equal(t, []string{book.BookID, other.BookID}, []string{listing.Books[0].BookID, listing.Books[1].BookID})
+	}
+	rr = serveJSON(r, "GET", "/books?limit=1&offset=1&locationId="+warehouse.LocationID, nil)
+	json.Unmarshal(rr.Body.Bytes(), &listing)
+	if assert.Len(t, listing.Books, 1) {
+		assert.Equal(t, other.BookID, listing.Books[0].BookID)
+	}
+
+	rr = serveJSON(r, "GET", "/books?locationId=nowhere", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// A location still holding stock cannot be deleted.
+	rr = serveJSON(r, "DELETE", "/locations/"+shop.LocationID, nil)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestDeleteLocationHoldingTrashedStock(t *testing.T) {
+	svc, _, r := setupStock(t, service.BackordersRejected)
+	shop := createRecord(t, r, "/locations", models.Location{Name: "Kiosk", Type: models.LocationBranch})
+
+	book, err := svc.Create(models.Book{Title: "Withdrawn"}, "alice")
+	assert.NoError(t, err)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "receipt", Quantity: 3, LocationID: shop.LocationID}, http.StatusCreated)
+	assert.NoError(t, svc.Delete(book.BookID, 0, "alice"))
+
+	// Stock of a trashed book cannot be transferred away, so it does not
+	// keep the location alive or show up in its listing.
+	rr := serveJSON(r, "GET", "/books?locationId="+shop.LocationID, nil)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"total_count":0`)
+
+	rr = serveJSON(r, "DELETE", "/locations/"+shop.LocationID, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+}
+
+func TestReservationsAtLocations(t *testing.T) {
+	svc, _, r := setupStock(t, service.BackordersRejected)
+	warehouse := createRecord(t, r, "/locations", models.Location{Name: "Warehouse", Type: models.LocationWarehouse})
+
+	book, err := svc.Create(models.Book{Title: "Dune"}, "alice")
+	assert.NoError(t, err)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "receipt", Quantity: 5, LocationID: warehouse.LocationID}, http.StatusCreated)
+
+	rr := serveJSON(r, "POST", "/books/"+book.BookID+"/reservations", models.Reservation{Quantity: 2, LocationID: warehouse.LocationID})
+	assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var held models.Reservation
+	json.Unmarshal(rr.Body.Bytes(), &held)
+	assert.Equal(t, warehouse.LocationID, held.LocationID)
+
+	// Unassigned stock is empty, and the held copies cannot leave the
+	// warehouse or be reserved twice.
+	reserve(t, r, book.BookID, 1, http.StatusConflict)
+	rr = serveJSON(r, "POST", "/books/"+book.BookID+"/reservations", models.Reservation{Quantity: 4, LocationID: warehouse.LocationID})
+	assert.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+	rr = serveJSON(r, "POST", "/books/"+book.BookID+"/reservations", models.Reservation{Quantity: 1, LocationID: "nowhere"})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, rr.Body.String())
+	transfer(t, r, book.BookID, models.StockTransfer{From: warehouse.LocationID, Quantity: 4}, http.StatusConflict)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "sale", Quantity: 4, LocationID: warehouse.LocationID}, http.StatusConflict)
+
+	rr = serveJSON(r, "POST", "/reservations/"+held.ReservationID+"/confirm", nil)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var confirmed struct {
+		Movement models.StockMovement `json:"movement"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &confirmed)
+	assert.Equal(t, warehouse.LocationID, confirmed.Movement.LocationID)
+
+	levels := stockLevels(t, r, book.BookID)
+	assert.Equal(t, 3, levels.Available)
+	assert.Equal(t, []models.LocationStock{{LocationID: warehouse.LocationID, OnHand: 3}}, levels.Locations)
+}
+
+func TestStockLedgerIndexesLocations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "stock.jsonl")
+	ledger, err := repository.NewFileStockLedger(filename)
+	assert.NoError(t, err)
+
+	_, err = ledger.Append(
+		models.StockMovement{BookID: "b1", Type: models.MovementReceipt, Quantity: 5, LocationID: "w"},
+		models.StockMovement{BookID: "b1", Type: models.MovementTransferOut, Quantity: 5, LocationID: "w"},
+		models.StockMovement{BookID: "b1", Type: models.MovementTransferIn, Quantity: 5, LocationID: "s"},
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, ledger.Close())
+
+	reopened, err := repository.NewFileStockLedger(filename)
+	assert.NoError(t, err)
+	defer reopened.Close()
+
+	held, err := reopened.AtLocation("s")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"b1": 5}, held)
+
+	held, err = reopened.AtLocation("w")
+	assert.NoError(t, err)
+	assert.Empty(t, held)
+}
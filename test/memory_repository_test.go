@@ -0,0 +1,103 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRepositorySecondaryIndexes(t *testing.T) {
+	repo, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+
+	dune, err := repo.Create(models.Book{Title: "Dune", ISBN: "9780441013593", AuthorID: "herbert", Genre: "Science Fiction"})
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{Title: "Emma", ISBN: "9780141439587", AuthorID: "austen", Genre: "Romance"})
+	assert.NoError(t, err)
+
+	books, err := repo.FindByGenre("science fiction")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+	assert.Equal(t, dune.BookID, books[0].BookID)
+
+
+	dune.AuthorID = "frank-herbert"
+	_, err = repo.Update(dune.BookID, *dune)
+	assert.NoError(t, err)
+
+	books, err = repo.FindByAuthor("herbert")
+	assert.NoError(t, err)
+	assert.Empty(t, books)
+
+	books, err = repo.FindByAuthor("frank-herbert")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+
+
+	_, err = repo.Delete(dune.BookID, 0)
+	assert.NoError(t, err)
+	books, err = repo.FindByISBN("9780441013593")
+	assert.NoError(t, err)
+	assert.Empty(t, books)
+}
+
+func TestMemoryRepositoryPersists(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	persister, err := repository.NewJSONFilePersister(filename)
+	assert.NoError(t, err)
+	repo, err := repository.NewMemoryRepository(persister)
+	assert.NoError(t, err)
+
+	created, err := repo.Create(models.Book{Title: "Persisted", ISBN: "9780000000002"})
+	assert.NoError(t, err)
+
+
+	file, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+	book, err := file.GetByID(created.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Persisted", book.Title)
+
+	persister, err = repository.NewJSONFilePersister(filename)
+	assert.NoError(t, err)
+	reopened, err := repository.NewMemoryRepository(persister)
+	assert.NoError(t, err)
+
+	books, err := reopened.FindByISBN("9780000000002")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+}
+
+func TestDirPersisterKeepsLookalikeIDsApart(t *testing.T) {
+	dir := t.TempDir()
+
+	persister, err := repository.NewDirPersister(dir)
+	assert.NoError(t, err)
+	repo, err := repository.NewMemoryRepository(persister)
+	assert.NoError(t, err)
+
+	ids := []string{"a/b", `a\b`, "a..b", "a_b", "../escape"}
+	for _, id := range ids {
+		_, err := repo.Create(models.Book{BookID: id, Title: id})
+		assert.NoError(t, err)
+	}
+
+	persister, err = repository.NewDirPersister(dir)
+	assert.NoError(t, err)
+	reopened, err := repository.NewMemoryRepository(persister)
+	assert.NoError(t, err)
+
+	for _, id := range ids {
+		book, err := reopened.GetByID(id)
+		if assert.NoError(t, err, id) {
+			assert.Equal(t, id, book.Title)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "books", "*.json"))
+	assert.Len(t, files, len(ids))
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublisherCRUD(t *testing.T) {
+	_, r := setupRecords(t, service.DeleteRestrict)
+
+	publisher := createRecord(t, r, "/publishers", models.Publisher{
+		Name:     "Penguin Random House",
+		Country:  "US",
+		Contact:  models.Contact{Email: "info@example.com", Website: "https://example.com"},
+		Imprints: []models.Imprint{{Name: "Vintage"}, {Name: "Knopf"}},
+	})
+	assert.NotEmpty(t, publisher.PublisherID)
+	assert.Len(t, publisher.Imprints, 2)
+	assert.NotEmpty(t, publisher.Imprints[0].ImprintID)
+
+	// Existing imprints keep their IDs across updates.
+	publisher.Imprints = append(publisher.Imprints, models.Imprint{Name: "Anchor"})
+	rr := serveJSON(r, "PUT", "/publishers/"+publisher.PublisherID, publisher)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var updated models.Publisher
+	json.Unmarshal(rr.Body.Bytes(), &updated)
+	assert.Len(t, updated.Imprints, 3)
+	assert.Equal(t, publisher.Imprints[0].ImprintID, updated.Imprints[0].ImprintID)
+
+	rr = serveJSON(r, "GET", "/publishers/"+publisher.PublisherID, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveJSON(r, "DELETE", "/publishers/"+publisher.PublisherID, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(r, "GET", "/publishers/"+publisher.PublisherID, nil).Code)
+}
+
+func TestPublisherValidation(t *testing.T) {
+	_, r := setupRecords(t, service.DeleteRestrict)
+
+	assert.Equal(t, http.StatusBadRequest, serveJSON(r, "POST", "/publishers", models.Publisher{}).Code)
+	assert.Equal(t, http.StatusBadRequest, serveJSON(r, "POST", "/publishers",
+		models.Publisher{Name: "X", Contact: models.Contact{Email: "not an email"}}).Code)
+	assert.Equal(t, http.StatusBadRequest, serveJSON(r, "POST", "/publishers",
+		models.Publisher{Name: "X", Imprints: []models.Imprint{{Name: "Vintage"}, {Name: "vintage"}}}).Code)
+}
+
+func TestPublisherBooksAndReferences(t *testing.T) {
+	svc, r := setupRecords(t, service.DeleteRestrict)
+	publisher := createRecord(t, r, "/publishers", models.Publisher{Name: "Ace"})
+
+	rr := serveJSON(r, "POST", "/books", models.Book{Title: "Dune", PublisherID: "nobody"})
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	for _, title := range []string{"Dune", "Dune Messiah", "Children of Dune"} {
+		_, err := svc.Create(models.Book{Title: title, PublisherID: publisher.PublisherID}, "alice")
+		assert.NoError(t, err)
+	}
+
+	rr = serveJSON(r, "GET", "/publishers/"+publisher.PublisherID+"/books?limit=2&offset=2", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var page struct {
+		Books      []models.Book `json:"books"`
+		TotalCount int           `json:"total_count"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	assert.Len(t, page.Books, 1)
+	assert.Equal(t, 3, page.TotalCount)
+
+	assert.Equal(t, http.StatusConflict, serveJSON(r, "DELETE", "/publishers/"+publisher.PublisherID, nil).Code)
+
+
+	svc, r = setupRecords(t, service.DeleteCascade)
+	publisher = createRecord(t, r, "/publishers", models.Publisher{Name: "Ace"})
+	_, err := svc.Create(models.Book{Title: "Dune", PublisherID: publisher.PublisherID}, "alice")
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusNoContent, serveJSON(r, "DELETE", "/publishers/"+publisher.PublisherID, nil).Code)
+	count, _ := svc.Count()
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,223 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crud-in-go-lang/internal/controller"
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/replication"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/router"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startLeader(t *testing.T, logSize int) (*service.BookService, *repository.ChangeLog, *httptest.Server) {
+	repo, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+
+	changes := repository.NewChangeLog(repo, logSize)
+	svc := service.NewBookService(changes)
+	r := router.SetupRouter(controller.NewBookController(svc), controller.NewAdminController(svc),
+		controller.NewReplicationController(changes, nil))
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return svc, changes, server
+}
+
+func TestFollowerReplicatesLeader(t *testing.T) {
+	leader, _, server := startLeader(t, 0)
+
+	kept, err := leader.Create(models.Book{Title: "Kept", ISBN: "978-0-00-000001-1"}, "alice")
+	assert.NoError(t, err)
+
+	local, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+	follower := replication.NewFollower(server.URL, local, 0)
+	assert.NoError(t, follower.Sync())
+
+	book, err := local.GetByID(kept.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Kept", book.Title)
+
+
+	kept.Title = "Kept, revised"
+	_, err = leader.Update(kept.BookID, *kept, "alice")
+	assert.NoError(t, err)
+	gone, err := leader.Create(models.Book{Title: "Gone"}, "alice")
+	assert.NoError(t, err)
+	assert.NoError(t, leader.Delete(gone.BookID, 0, "alice"))
+	assert.NoError(t, leader.Purge(gone.BookID, "alice"))
+	trashed, err := leader.Create(models.Book{Title: "Trashed"}, "alice")
+	assert.NoError(t, err)
+	assert.NoError(t, leader.Delete(trashed.BookID, 0, "alice"))
+
+	assert.NoError(t, follower.Sync())
+
+	book, err = local.GetByID(kept.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Kept, revised", book.Title)
+	assert.Equal(t, 2, book.Version)
+
+	_, err = local.GetByID(gone.BookID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	trash, err := local.GetDeleted(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(trash))
+
+	status := follower.Status()
+	assert.Equal(t, "follower", status.Role)
+	assert.Equal(t, status.LeaderSeq, status.AppliedSeq)
+	assert.Equal(t, int64(0), status.Behind)
+	assert.Empty(t, status.LastError)
+}
+
+func TestFollowerCopiesCatalogWhenLogIsTruncated(t *testing.T) {
+	leader, changes, server := startLeader(t, 2)
+
+	local, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+	follower := replication.NewFollower(server.URL, local, 0)
+	assert.NoError(t, follower.Sync())
+
+	for _, title := range []string{"One", "Two", "Three", "Four", "Five", "Six"} {
+		_, err := leader.Create(models.Book{Title: title}, "alice")
+		assert.NoError(t, err)
+	}
+
+	_, err = changes.Changes(0, 0)
+	assert.ErrorIs(t, err, repository.ErrChangesTruncated)
+
+	assert.NoError(t, follower.Sync())
+	count, err := local.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+	assert.Equal(t, int64(6), follower.Status().AppliedSeq)
+}
+
+func TestFollowerRedirectsWritesAndReportsLag(t *testing.T) {
+	_, _, leaderServer := startLeader(t, 0)
+
+	local, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+	follower := replication.NewFollower(leaderServer.URL, local, 0)
+	assert.NoError(t, follower.Sync())
+
+	svc := service.NewBookService(local)
+	stock := service.NewStockService(repository.NewMemoryStockLedger(), svc, service.BackordersRejected)
+	r := router.SetupRouter(controller.NewBookController(svc), controller.NewAdminController(svc),
+		controller.NewReplicationController(nil, follower),
+		controller.NewAuthorController(service.NewAuthorService(repository.NewMemoryAuthorRepository(), svc, service.DeleteRestrict)),
+		controller.NewStockController(stock))
+
+
+	body, _ := json.Marshal(models.Book{Title: "Misdirected"})
+	req, _ := http.NewRequest("POST", "/books", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	assert.Equal(t, leaderServer.URL+"/books", rr.Header().Get("Location"))
+
+	req, _ = http.NewRequest("GET", "/books", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Data that is not replicated is read from the leader.
+	for _, path := range []string{"/authors", "/reservations/r1", "/books/b1/stock/movements", "/books/b1/history", "/books?locationId=l1"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusTemporaryRedirect, rr.Code, path)
+		assert.Equal(t, leaderServer.URL+path, rr.Header().Get("Location"))
+	}
+
+
+	req, _ = http.NewRequest("GET", "/health", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var health struct {
+		Status      string             `json:"status"`
+		Replication replication.Status `json:"replication"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &health))
+	assert.Equal(t, "ok", health.Status)
+	assert.Equal(t, "follower", health.Replication.Role)
+	assert.Equal(t, leaderServer.URL, health.Replication.Leader)
+	assert.True(t, health.Replication.LagSeconds >= 0)
+
+
+	leaderServer.Close()
+	assert.Error(t, follower.Sync())
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &health))
+	assert.Equal(t, "degraded", health.Status)
+	assert.NotEmpty(t, health.Replication.LastError)
+}
+
+func TestChangeLogFollowsWatchedFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+	repo, err := repository.NewFileRepository(filename, repository.WithWatchInterval(10*time.Millisecond))
+	assert.NoError(t, err)
+	defer repo.Close()
+
+	changes := repository.NewChangeLog(repository.NewCachedRepository(repo, 0, 0), 0)
+
+	created, err := changes.Create(models.Book{Title: "Written"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), changes.LastSeq())
+
+	// A hand edit reaches the log through the repository's announcements.
+	edited := `{"formatVersion": 2, "books": [{"bookId": "` + created.BookID + `", "title": "Edited", "version": 2}]}`
+	assert.NoError(t, os.WriteFile(filename, []byte(edited), 0644))
+	assert.Eventually(t, func() bool { return changes.LastSeq() == 2 }, time.Second, 5*time.Millisecond)
+
+	feed, err := changes.Changes(1, 0)
+	assert.NoError(t, err)
+	require.Len(t, feed.Changes, 1)
+	assert.Equal(t, "Edited", feed.Changes[0].Book.Title)
+}
+
+func TestApplyChangesKeepsReplicatedState(t *testing.T) {
+	repo, err := repository.NewFileRepository(filepath.Join(t.TempDir(), "books.json"))
+	assert.NoError(t, err)
+
+	kept, err := repo.Create(models.Book{Title: "Kept"})
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{BookID: "gone", Title: "Gone"})
+	assert.NoError(t, err)
+
+	deletedAt := time.Now().UTC()
+	err = repository.ApplyChanges(repo, []models.Change{
+		{Seq: 1, BookID: kept.BookID, Book: &models.Book{BookID: kept.BookID, Title: "Kept, revised", Version: 7}},
+		{Seq: 2, BookID: "trashed", Book: &models.Book{BookID: "trashed", Title: "Trashed", Version: 3, DeletedAt: &deletedAt}},
+		{Seq: 3, BookID: "gone"},
+	})
+	assert.NoError(t, err)
+
+	book, err := repo.GetByID(kept.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Kept, revised", book.Title)
+	assert.Equal(t, 7, book.Version)
+
+	_, err = repo.GetByID("gone")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	trash, err := repo.GetDeleted(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	require.Len(t, trash, 1)
+	assert.Equal(t, 3, trash[0].Version)
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testClock is a clock that only moves when told to.
+type testClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func reserve(t *testing.T, r http.Handler, bookID string, quantity int, status int) models.Reservation {
+	rr := serveJSON(r, "POST", "/books/"+bookID+"/reservations", map[string]interface{}{"quantity": quantity, "reference": "cart-1"})
+	assert.Equal(t, status, rr.Code, rr.Body.String())
+
+	var reservation models.Reservation
+	json.Unmarshal(rr.Body.Bytes(), &reservation)
+	return reservation
+}
+
+func TestReservationsHoldAvailableStock(t *testing.T) {
+	svc, _, r := setupStock(t, service.BackordersRejected)
+	book, _ := svc.Create(models.Book{Title: "Held", Quantity: 5}, "alice")
+
+	first := reserve(t, r, book.BookID, 3, http.StatusCreated)
+	assert.Equal(t, models.ReservationActive, first.Status)
+	assert.Equal(t, models.StockLevels{OnHand: 5, Reserved: 3, Available: 2}, stockLevels(t, r, book.BookID))
+	assert.Contains(t, serveJSON(r, "GET", "/books/"+book.BookID, nil).Body.String(), `"title":"Held"`)
+
+	// Neither reservations nor sales can take the held copies.
+	reserve(t, r, book.BookID, 3, http.StatusConflict)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "sale", Quantity: 3}, http.StatusConflict)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "sale", Quantity: 2}, http.StatusCreated)
+
+	rr := serveJSON(r, "GET", "/books/"+book.BookID+"/reservations", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), first.ReservationID)
+
+	rr = serveJSON(r, "POST", "/reservations/"+first.ReservationID+"/confirm", nil)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var confirmed struct {
+		Reservation models.Reservation   `json:"reservation"`
+		Movement    models.StockMovement `json:"movement"`
+		Book        models.Book          `json:"book"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &confirmed)
+	assert.Equal(t, models.ReservationConfirmed, confirmed.Reservation.Status)
+	assert.Equal(t, models.MovementSale, confirmed.Movement.Type)
+	assert.Equal(t, first.ReservationID, confirmed.Movement.Reference)
+	assert.Equal(t, 0, confirmed.Book.Quantity)
+	assert.Equal(t, models.StockLevels{}, stockLevels(t, r, book.BookID))
+
+	rr = serveJSON(r, "POST", "/reservations/"+first.ReservationID+"/confirm", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	reserve(t, r, book.BookID, 0, http.StatusBadRequest)
+}
+
+func TestReleaseReservation(t *testing.T) {
+	svc, _, r := setupStock(t, service.BackordersRejected)
+	book, _ := svc.Create(models.Book{Title: "Held", Quantity: 4}, "alice")
+
+	reservation := reserve(t, r, book.BookID, 4, http.StatusCreated)
+	assert.Equal(t, 0, stockLevels(t, r, book.BookID).Available)
+
+	rr := serveJSON(r, "POST", "/reservations/"+reservation.ReservationID+"/release", nil)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), models.ReservationReleased)
+	assert.Equal(t, models.StockLevels{OnHand: 4, Reserved: 0, Available: 4}, stockLevels(t, r, book.BookID))
+
+	rr = serveJSON(r, "GET", "/reservations/"+reservation.ReservationID, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestReservationsExpire(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "reservations.json")
+	reservations, err := repository.NewFileReservationRepository(filename)
+	assert.NoError(t, err)
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	svc, stock, r := setupStock(t, service.BackordersRejected, service.WithReservations(reservations), service.WithReservationTTL(time.Minute), service.WithClock(clock.Now))
+	book, _ := svc.Create(models.Book{Title: "Held", Quantity: 2}, "alice")
+
+	expiring := reserve(t, r, book.BookID, 2, http.StatusCreated)
+	assert.Equal(t, 0, stockLevels(t, r, book.BookID).Available)
+
+	reopened, err := repository.NewFileReservationRepository(filename)
+	assert.NoError(t, err)
+	kept, err := reopened.List(book.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(kept))
+
+	clock.Advance(time.Minute)
+
+	// Expired reservations stop holding copies before the reaper runs.
+	assert.Equal(t, 2, stockLevels(t, r, book.BookID).Available)
+	rr := serveJSON(r, "POST", "/reservations/"+expiring.ReservationID+"/confirm", nil)
+	assert.Equal(t, http.StatusGone, rr.Code, rr.Body.String())
+
+	reserve(t, r, book.BookID, 1, http.StatusCreated)
+	clock.Advance(time.Minute)
+	stock.StartReaper(10 * time.Millisecond)
+	defer stock.StopReaper()
+
+	assert.Eventually(t, func() bool {
+		left, err := reservations.List("")
+		return err == nil && len(left) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, stockLevels(t, r, book.BookID).Available)
+}
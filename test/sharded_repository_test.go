@@ -0,0 +1,346 @@
+package test
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedRepositoryCRUD(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := repository.NewShardedRepository(dir, 16)
+	assert.NoError(t, err)
+
+	var ids []string
+	for i := 0; i < 40; i++ {
+		genre := "Fiction"
+		if i%4 == 0 {
+			genre = "History"
+		}
+		book, err := repo.Create(models.Book{
+			Title: fmt.Sprintf("Book %02d", i),
+			ISBN:  fmt.Sprintf("SHARD-%d", i),
+			Genre: genre,
+		})
+		assert.NoError(t, err)
+		ids = append(ids, book.BookID)
+	}
+
+
+	// Pages follow creation order even though the books are spread out.
+	page, err := repo.GetAll(models.PaginationParams{Limit: 5, Offset: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(page))
+	assert.Equal(t, "Book 10", page[0].Title)
+	assert.Equal(t, "Book 14", page[4].Title)
+
+	history, err := repo.GetAll(models.PaginationParams{Limit: -1, Filter: models.BookFilter{Genre: "history"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(history))
+	assert.Equal(t, "Book 00", history[0].Title)
+
+	found, err := repo.Search("book 3")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(found))
+	assert.Equal(t, "Book 30", found[0].Title)
+
+	book, err := repo.GetByISBN("shard-7")
+	assert.NoError(t, err)
+	assert.Equal(t, ids[7], book.BookID)
+
+	_, err = repo.Create(models.Book{Title: "Duplicate", ISBN: "SHARD-7"})
+	var conflict *repository.ConflictError
+	assert.ErrorAs(t, err, &conflict)
+	assert.Equal(t, ids[7], conflict.BookID)
+
+
+	book.Title = "Book 07, revised"
+	updated, err := repo.Update(book.BookID, *book)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, updated.Version)
+
+	_, err = repo.Delete(ids[0], 0)
+	assert.NoError(t, err)
+	_, err = repo.Delete(ids[1], 0)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Purge(ids[1]))
+	_, err = repo.GetByID(ids[0])
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	count, err := repo.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 38, count)
+
+
+	// Everything survives a restart, with or without a clean Close.
+	for _, closeFirst := range []bool{false, true} {
+		if closeFirst {
+			assert.NoError(t, repo.Close())
+		}
+		repo, err = repository.NewShardedRepository(dir, 0)
+		assert.NoError(t, err)
+
+		count, err = repo.Count()
+		assert.NoError(t, err)
+		assert.Equal(t, 38, count)
+
+		trash, err := repo.GetDeleted(models.PaginationParams{Limit: 10})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(trash))
+		assert.Equal(t, ids[0], trash[0].BookID)
+
+		book, err = repo.GetByID(ids[7])
+		assert.NoError(t, err)
+		assert.Equal(t, "Book 07, revised", book.Title)
+
+		_, err = repo.Create(models.Book{Title: "Still a duplicate", ISBN: "SHARD-7"})
+		assert.ErrorAs(t, err, &conflict)
+	}
+
+	_, err = repository.NewShardedRepository(dir, 32)
+	assert.Error(t, err)
+}
+
+func TestShardedRepositoryUpdateTouchesOneShard(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := repository.NewShardedRepository(dir, 8)
+	assert.NoError(t, err)
+
+	var last *models.Book
+	for i := 0; i < 20; i++ {
+		last, err = repo.Create(models.Book{Title: fmt.Sprintf("Book %d", i)})
+		assert.NoError(t, err)
+	}
+
+	before := modTimes(t, dir)
+	time.Sleep(10 * time.Millisecond)
+
+	last.Price = 9.5
+	_, err = repo.Update(last.BookID, *last)
+	assert.NoError(t, err)
+
+	after := modTimes(t, dir)
+	changed := 0
+	for name, modTime := range after {
+		if !modTime.Equal(before[name]) {
+			changed++
+		}
+	}
+	assert.Equal(t, 1, changed)
+}
+
+func modTimes(t *testing.T, dir string) map[string]time.Time {
+	times := make(map[string]time.Time)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			times[path] = info.ModTime()
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	return times
+}
+
+func TestShardedRepositoryBatchAndReplace(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := repository.NewShardedRepository(dir, 8)
+	assert.NoError(t, err)
+
+	kept, err := repo.Create(models.Book{Title: "Kept", ISBN: "KEPT"})
+	assert.NoError(t, err)
+
+
+	// The second create conflicts with the first, so neither is stored.
+	_, err = repo.Apply([]models.BatchOp{
+		{Action: models.ActionCreate, Book: models.Book{Title: "A", ISBN: "SAME"}},
+		{Action: models.ActionCreate, Book: models.Book{Title: "B", ISBN: "SAME"}},
+	})
+	assert.Error(t, err)
+
+	count, err := repo.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	results, err := repo.Apply([]models.BatchOp{
+		{Action: models.ActionCreate, Book: models.Book{Title: "A", ISBN: "SAME"}},
+		{Action: models.ActionCreate, Book: models.Book{Title: "B", ISBN: "OTHER"}},
+		{Action: models.ActionDelete, BookID: kept.BookID},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(results))
+	_, err = os.Stat(filepath.Join(dir, "pending.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	books, err := repo.GetAll(models.PaginationParams{Limit: -1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "B"}, []string{books[0].Title, books[1].Title})
+
+
+	snapshot, err := repo.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(snapshot))
+
+	assert.NoError(t, repo.ReplaceAll(snapshot[:1]))
+	count, err = repo.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	repo, err = repository.NewShardedRepository(dir, 0)
+	assert.NoError(t, err)
+	snapshot, err = repo.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(snapshot))
+	assert.Equal(t, "Kept", snapshot[0].Title)
+
+	_, err = repo.Create(models.Book{Title: "Reuses an ISBN replaced away", ISBN: "SAME"})
+	assert.NoError(t, err)
+}
+
+func TestShardedRepositoryFinishesFailedCommitFirst(t *testing.T) {
+	dir := t.TempDir()
+	pending := filepath.Join(dir, "pending.json")
+
+	repo, err := repository.NewShardedRepository(dir, 4)
+	require.NoError(t, err)
+	first, err := repo.Create(models.Book{Title: "First"})
+	require.NoError(t, err)
+
+	// With the manifest journal closed under it, a batch fails after its
+	// shards are written, leaving them half committed.
+	require.NoError(t, repo.Close())
+
+	var ops []models.BatchOp
+	titles := []string{"First"}
+	for i := 0; i < 6; i++ {
+		book := models.Book{BookID: fmt.Sprintf("b%d", i), Title: fmt.Sprintf("Book %d", i), ISBN: fmt.Sprintf("ISBN-%d", i)}
+		ops = append(ops, models.BatchOp{Action: models.ActionCreate, Book: book})
+		titles = append(titles, book.Title)
+	}
+	_, err = repo.Apply(ops)
+	require.Error(t, err)
+	batch, err := os.ReadFile(pending)
+	require.NoError(t, err)
+
+
+	// Later writes neither build on those shards nor replace pending.json
+	// while the batch cannot be finished.
+	first.Title = "First, revised"
+	_, err = repo.Update(first.BookID, *first)
+	assert.ErrorContains(t, err, "unfinished commit")
+	_, err = repo.Apply([]models.BatchOp{
+		{Action: models.ActionCreate, Book: models.Book{BookID: "other", Title: "Other"}},
+		{Action: models.ActionUpdate, BookID: first.BookID, ExpectedVersion: first.Version, Book: *first},
+	})
+	assert.ErrorContains(t, err, "unfinished commit")
+	after, err := os.ReadFile(pending)
+	require.NoError(t, err)
+	assert.Equal(t, batch, after)
+
+
+	// Opening the catalog again finishes the batch.
+	reopened, err := repository.NewShardedRepository(dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, titles, shardedTitles(t, reopened))
+	_, err = os.Stat(pending)
+	assert.True(t, os.IsNotExist(err))
+
+	var conflict *repository.ConflictError
+	_, err = reopened.Create(models.Book{Title: "Copy", ISBN: "ISBN-3"})
+	assert.ErrorAs(t, err, &conflict)
+}
+
+func TestShardedRepositoryReplaceAllIsStaged(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := repository.NewShardedRepository(dir, 4)
+	require.NoError(t, err)
+	_, err = repo.Create(models.Book{Title: "Old", ISBN: "OLD"})
+	require.NoError(t, err)
+
+
+	// A replace that fails part way leaves the live catalog alone.
+	err = repo.ReplaceAll([]models.Book{{BookID: "n1", Title: "New"}, {BookID: "n2", Title: "Broken", Price: math.NaN()}})
+	require.Error(t, err)
+	assert.Equal(t, []string{"Old"}, shardedTitles(t, repo))
+
+	reopened, err := repository.NewShardedRepository(dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Old"}, shardedTitles(t, reopened))
+	_, err = os.Stat(filepath.Join(dir, "replace"))
+	assert.True(t, os.IsNotExist(err))
+
+
+	// A replace staged in full but cut short before the swap is finished
+	// when the catalog is opened, without replaying the old journal.
+	source := t.TempDir()
+	staged, err := repository.NewShardedRepository(source, 4)
+	require.NoError(t, err)
+	replacement := []models.Book{{BookID: "n1", Title: "New"}, {BookID: "n2", Title: "Newer"}}
+	require.NoError(t, staged.ReplaceAll(replacement))
+	_, err = os.Stat(filepath.Join(source, "pending.json"))
+	assert.True(t, os.IsNotExist(err))
+	require.NoError(t, staged.Close())
+
+	copyTree(t, filepath.Join(source, "shards"), filepath.Join(dir, "replace", "shards"))
+	copyTree(t, filepath.Join(source, "manifest.json"), filepath.Join(dir, "replace", "manifest.json"))
+
+	reopened, err = repository.NewShardedRepository(dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"New", "Newer"}, shardedTitles(t, reopened))
+	_, err = reopened.Create(models.Book{Title: "Reuses the replaced ISBN", ISBN: "OLD"})
+	assert.NoError(t, err)
+	for _, name := range []string{"replace", "shards.old"} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+}
+
+func shardedTitles(t *testing.T, repo *repository.ShardedRepository) []string {
+	books, err := repo.GetAll(models.PaginationParams{Limit: -1})
+	require.NoError(t, err)
+
+	var titles []string
+	for _, book := range books {
+		titles = append(titles, book.Title)
+	}
+	return titles
+}
+
+// copyTree copies the file or directory at from to to.
+func copyTree(t *testing.T, from, to string) {
+	err := filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+	require.NoError(t, err)
+}
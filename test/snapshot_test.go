@@ -0,0 +1,165 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/controller"
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotCreateRestoreAndRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := repository.NewJournalRepository(filepath.Join(dir, "books.json"), 100)
+	assert.NoError(t, err)
+	snapshots, err := repository.NewSnapshotManager(repo, filepath.Join(dir, "snapshots"), 2)
+	assert.NoError(t, err)
+
+	kept, err := repo.Create(models.Book{Title: "Kept", Quantity: 3})
+	assert.NoError(t, err)
+	trashed, err := repo.Create(models.Book{Title: "Trashed"})
+	assert.NoError(t, err)
+	_, err = repo.Delete(trashed.BookID, 0)
+	assert.NoError(t, err)
+
+	first, err := snapshots.Create()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, first.Books)
+	assert.Len(t, first.SHA256, 64)
+
+
+	kept.Quantity = 0
+	_, err = repo.Update(kept.BookID, *kept)
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{Title: "Added later"})
+	assert.NoError(t, err)
+
+	_, err = snapshots.Restore(first.ID)
+	assert.NoError(t, err)
+
+	books, err := repo.GetAll(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+	assert.Equal(t, 3, books[0].Quantity)
+	trash, err := repo.GetDeleted(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(trash))
+
+
+	_, err = snapshots.Create()
+	assert.NoError(t, err)
+	latest, err := snapshots.Create()
+	assert.NoError(t, err)
+
+	list, err := snapshots.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, latest.ID, list[0].ID)
+
+	_, err = snapshots.Restore(first.ID)
+	assert.ErrorIs(t, err, repository.ErrSnapshotNotFound)
+	_, err = snapshots.Restore("../books.json")
+	assert.ErrorIs(t, err, repository.ErrSnapshotNotFound)
+
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "snapshots", latest.ID, "books.json"), []byte("[]"), 0644))
+	_, err = snapshots.Restore(latest.ID)
+	assert.ErrorIs(t, err, repository.ErrSnapshotCorrupt)
+}
+
+func TestSnapshotAdminEndpoints(t *testing.T) {
+	repo, err := repository.NewFileRepository(filepath.Join(t.TempDir(), "books.json"))
+	assert.NoError(t, err)
+	snapshots, err := repository.NewSnapshotManager(repo, filepath.Join(t.TempDir(), "snapshots"), 0)
+	assert.NoError(t, err)
+
+	svc := service.NewBookService(repo, service.WithSnapshots(snapshots))
+	router := mux.NewRouter()
+	controller.NewAdminController(svc).RegisterRoutes(router)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("X-Actor", "ops")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	book, err := svc.Create(models.Book{Title: "Before"}, "")
+	assert.NoError(t, err)
+
+	rr := do("POST", "/admin/snapshots")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var manifest repository.SnapshotManifest
+	json.Unmarshal(rr.Body.Bytes(), &manifest)
+
+	book.Title = "After"
+	_, err = svc.Update(book.BookID, *book, "")
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, do("POST", "/admin/snapshots/"+manifest.ID+"/restore").Code)
+	restored, err := svc.GetByID(book.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Before", restored.Title)
+
+	history, err := svc.GetHistory(book.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, models.ActionRestoreSnapshot, history[2].Action)
+	assert.Equal(t, "ops", history[2].Actor)
+
+	rr = do("GET", "/admin/snapshots")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), manifest.ID)
+
+	assert.Equal(t, http.StatusNotFound, do("POST", "/admin/snapshots/missing/restore").Code)
+
+
+	disabled := mux.NewRouter()
+	controller.NewAdminController(service.NewBookService(repo)).RegisterRoutes(disabled)
+	rr = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/snapshots", nil)
+	disabled.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+func TestSnapshotRestoreRecordsStockAdjustment(t *testing.T) {
+	repo, err := repository.NewFileRepository(filepath.Join(t.TempDir(), "books.json"))
+	assert.NoError(t, err)
+	snapshots, err := repository.NewSnapshotManager(repo, filepath.Join(t.TempDir(), "snapshots"), 0)
+	assert.NoError(t, err)
+
+	ledger := repository.NewMemoryStockLedger()
+	svc := service.NewBookService(repo, service.WithSnapshots(snapshots), service.WithStockLedger(ledger))
+	stock := service.NewStockService(ledger, svc, service.BackordersRejected)
+
+	book, err := svc.Create(models.Book{Title: "Dune", Quantity: 4}, "")
+	assert.NoError(t, err)
+	manifest, err := svc.CreateSnapshot()
+	assert.NoError(t, err)
+
+	_, _, err = stock.Record(book.BookID, models.StockMovement{Type: models.MovementSale, Quantity: 3}, "")
+	assert.NoError(t, err)
+
+	_, err = svc.RestoreSnapshot(manifest.ID, "ops")
+	assert.NoError(t, err)
+
+	movements, err := ledger.List(book.BookID)
+	assert.NoError(t, err)
+	if assert.Len(t, movements, 3) {
+		assert.Equal(t, models.MovementAdjustment, movements[2].Type)
+		assert.Equal(t, 3, movements[2].Quantity)
+		assert.Equal(t, 4, movements[2].Balance)
+		assert.Equal(t, "ops", movements[2].Actor)
+	}
+}
@@ -0,0 +1,432 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSQL is a database/sql driver that records every statement it is sent
+// and answers from rules, so the SQL a repository writes can be checked
+// without a database server.
+type fakeSQL struct {
+	mutex      sync.Mutex
+	rules      []*fakeRule
+	statements []fakeStatement
+}
+
+// fakeRule answers the statements containing match. A rule with times set
+// answers that many statements and is then used up.
+type fakeRule struct {
+	match    string
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+	times    int
+}
+
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+}
+
+var (
+	fakeSQLOnce      sync.Once
+	fakeSQLDatabases sync.Map
+	fakeSQLNext      int
+	fakeSQLMutex     sync.Mutex
+)
+
+type fakeSQLDriver struct{}
+
+func (fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	db, ok := fakeSQLDatabases.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("no fake database %q", name)
+	}
+	return &fakeSQLConn{db: db.(*fakeSQL)}, nil
+}
+
+// openFakeSQL returns a fresh fake database answering rules and a SQL
+// repository opened on it, with the statements of opening it cleared.
+func openFakeSQL(t *testing.T, rules ...*fakeRule) (*fakeSQL, *repository.SQLRepository) {
+	db := &fakeSQL{}
+	db.answer(rules...)
+	repo := openOnFakeSQL(t, db)
+
+	db.reset()
+	return db, repo
+}
+
+// openOnFakeSQL opens a SQL repository on db.
+func openOnFakeSQL(t *testing.T, db *fakeSQL) *repository.SQLRepository {
+	fakeSQLOnce.Do(func() { sql.Register("fakesql", fakeSQLDriver{}) })
+
+	fakeSQLMutex.Lock()
+	fakeSQLNext++
+	name := fmt.Sprintf("db%d", fakeSQLNext)
+	fakeSQLMutex.Unlock()
+	fakeSQLDatabases.Store(name, db)
+
+	repo, err := repository.OpenSQLRepository("fakesql", name)
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// migrated is the rule of a database whose schema is up to date.
+func migrated() *fakeRule {
+	return &fakeRule{match: "FROM schema_migrations", columns: []string{"version"}, rows: [][]driver.Value{{int64(6)}}}
+}
+
+func (db *fakeSQL) answer(rules ...*fakeRule) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.rules = append(rules, db.rules...)
+}
+
+func (db *fakeSQL) reset() {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.statements = nil
+}
+
+// log returns the statements sent so far, with whitespace collapsed.
+func (db *fakeSQL) log() []fakeStatement {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return append([]fakeStatement(nil), db.statements...)
+}
+
+// find returns the first statement containing match.
+func (db *fakeSQL) find(t *testing.T, match string) fakeStatement {
+	for _, statement := range db.log() {
+		if strings.Contains(statement.query, match) {
+			return statement
+		}
+	}
+	t.Fatalf("no statement contains %q", match)
+	return fakeStatement{}
+}
+
+func (db *fakeSQL) run(query string, args []driver.NamedValue) *fakeRule {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	db.statements = append(db.statements, fakeStatement{query: query, args: values})
+
+	for i, rule := range db.rules {
+		if !strings.Contains(query, rule.match) {
+			continue
+		}
+		if rule.times > 0 {
+			rule.times--
+			if rule.times == 0 {
+				db.rules = append(db.rules[:i:i], db.rules[i+1:]...)
+			}
+		}
+		return rule
+	}
+	return &fakeRule{affected: 1}
+}
+
+type fakeSQLConn struct {
+	db *fakeSQL
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fakesql does not prepare statements")
+}
+
+func (c *fakeSQLConn) Close() error { return nil }
+
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	c.db.run("BEGIN", nil)
+	return fakeSQLTx{db: c.db}, nil
+}
+
+func (c *fakeSQLConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rule := c.db.run(query, args)
+	if rule.err != nil {
+		return nil, rule.err
+	}
+	return driver.RowsAffected(rule.affected), nil
+}
+
+func (c *fakeSQLConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rule := c.db.run(query, args)
+	if rule.err != nil {
+		return nil, rule.err
+	}
+	return &fakeSQLRows{columns: rule.columns, rows: rule.rows}, nil
+}
+
+type fakeSQLTx struct {
+	db *fakeSQL
+}
+
+func (tx fakeSQLTx) Commit() error {
+	tx.db.run("COMMIT", nil)
+	return nil
+}
+
+func (tx fakeSQLTx) Rollback() error {
+	tx.db.run("ROLLBACK", nil)
+	return nil
+}
+
+type fakeSQLRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return r.columns }
+
+func (r *fakeSQLRows) Close() error { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// bookRow lists book in the column order the repository selects.
+func bookRow(book models.Book) []driver.Value {
+	var deletedAt, contributors driver.Value
+	if book.DeletedAt != nil {
+		deletedAt = book.DeletedAt.UTC().Format("2006-01-02T15:04:05.000000000Z")
+	}
+	if len(book.Contributors) > 0 {
+		var parts []string
+		for _, c := range book.Contributors {
+			parts = append(parts, fmt.Sprintf(`{"contributorId":%q,"role":%q}`, c.ContributorID, c.Role))
+		}
+		contributors = "[" + strings.Join(parts, ",") + "]"
+	}
+
+	return []driver.Value{book.BookID, book.AuthorID, book.PublisherID, book.Title, book.PublicationDate, book.ISBN,
+		int64(book.Pages), book.Genre, book.Description, book.Price, int64(book.Quantity), int64(book.Version), deletedAt, contributors}
+}
+
+var bookRowColumns = strings.Split("book_id,author_id,publisher_id,title,publication_date,isbn,pages,genre,description,price,quantity,version,deleted_at,contributors", ",")
+
+func TestSQLRepositoryMigratesOnce(t *testing.T) {
+	fresh := &fakeSQL{}
+	fresh.answer(&fakeRule{match: "FROM schema_migrations", columns: []string{"version"}, rows: [][]driver.Value{{int64(0)}}})
+	openOnFakeSQL(t, fresh)
+
+	var applied []driver.Value
+	for _, statement := range fresh.log() {
+		if strings.HasPrefix(statement.query, "INSERT INTO schema_migrations") {
+			applied = append(applied, statement.args[0])
+		}
+	}
+	assert.Equal(t, []driver.Value{int64(1), int64(2), int64(3), int64(4), int64(5), int64(6)}, applied)
+	fresh.find(t, "CREATE TABLE IF NOT EXISTS schema_migrations")
+	fresh.find(t, "INSERT INTO book_seq (last_seq) VALUES (0)")
+	fresh.find(t, "CREATE UNIQUE INDEX books_seq_idx ON books (seq)")
+	fresh.find(t, "SELECT book_id, isbn FROM books WHERE isbn <> '' ORDER BY seq")
+
+
+	// A database at version 4 only runs the later migrations, backfilling
+	// the ISBN keys of the books it already holds.
+	old := &fakeSQL{}
+	old.answer(
+		&fakeRule{match: "FROM schema_migrations", columns: []string{"version"}, rows: [][]driver.Value{{int64(4)}}},
+		&fakeRule{match: "SELECT book_id, isbn FROM books", columns: []string{"book_id", "isbn"}, rows: [][]driver.Value{{"b1", "0-306-40615-2"}, {"b2", "978-0-306-40615-7"}}},
+	)
+	openOnFakeSQL(t, old)
+
+	for _, statement := range old.log() {
+		assert.NotContains(t, statement.query, "CREATE TABLE books")
+		assert.NotContains(t, statement.query, "ADD COLUMN deleted_at")
+	}
+	key := repository.NormalizeISBN("0-306-40615-2")
+	backfill := old.find(t, "UPDATE books SET isbn_key = ?")
+	assert.Equal(t, []driver.Value{key, key, "b1"}, backfill.args)
+	old.find(t, "CREATE UNIQUE INDEX books_isbn_unique_key ON books (isbn_unique)")
+
+	// Only the first holder of a key stored before ISBNs were unique goes
+	// into the unique index.
+	var duplicate fakeStatement
+	for _, statement := range old.log() {
+		if strings.HasPrefix(statement.query, "UPDATE books SET isbn_key") && statement.args[2] == "b2" {
+			duplicate = statement
+		}
+	}
+	assert.Equal(t, []driver.Value{key, nil, "b2"}, duplicate.args)
+	old.find(t, "ALTER TABLE books ADD COLUMN contributors")
+}
+
+func TestSQLRepositoryFiltersAndPagesInSQL(t *testing.T) {
+	deletedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	stored := models.Book{BookID: "b1", Title: "Dune", Genre: "SciFi", Price: 9.5, Quantity: 2, Version: 3,
+		Contributors: []models.Contributor{{ContributorID: "c1", Role: "translator"}}}
+	db, repo := openFakeSQL(t, migrated(),
+		&fakeRule{match: "SELECT COUNT(*) FROM books", columns: []string{"count"}, rows: [][]driver.Value{{int64(3)}}},
+		&fakeRule{match: "WHERE deleted_at IS NOT NULL", columns: bookRowColumns, rows: [][]driver.Value{bookRow(models.Book{BookID: "t1", Version: 2, DeletedAt: &deletedAt})}},
+		&fakeRule{match: "SELECT book_id", columns: bookRowColumns, rows: [][]driver.Value{bookRow(stored)}},
+	)
+
+	books, err := repo.GetAll(models.PaginationParams{Limit: 10, Offset: 20, Filter: models.BookFilter{
+		AuthorID: "a1", Genre: "SciFi", ContributorID: "c1", Role: "Translator",
+	}})
+	require.NoError(t, err)
+	require.Len(t, books, 1)
+	assert.Equal(t, stored, books[0])
+
+	query := db.find(t, "FROM books WHERE")
+	assert.Contains(t, query.query, "WHERE deleted_at IS NULL AND author_id = ? AND LOWER(genre) = ? AND "+
+		"EXISTS (SELECT 1 FROM book_contributors c WHERE c.book_id = books.book_id AND c.contributor_id = ? AND LOWER(c.role) = ?) "+
+		"ORDER BY seq LIMIT ? OFFSET ?")
+	assert.Equal(t, []driver.Value{"a1", "scifi", "c1", "translator", int64(10), int64(20)}, query.args)
+
+
+	// The primary author counts as a contributor in the author role.
+	db.reset()
+	_, err = repo.GetAll(models.PaginationParams{Limit: -1, Offset: 5, Filter: models.BookFilter{ContributorID: "c1"}})
+	require.NoError(t, err)
+	query = db.find(t, "FROM books WHERE")
+	assert.Contains(t, query.query, "(author_id = ? OR EXISTS (SELECT 1 FROM book_contributors c WHERE c.book_id = books.book_id AND c.contributor_id = ?))")
+	assert.Equal(t, []driver.Value{"c1", "c1", int64(1 << 62), int64(5)}, query.args)
+
+	db.reset()
+	_, err = repo.GetAll(models.PaginationParams{Limit: -1, Filter: models.BookFilter{Role: "Author"}})
+	require.NoError(t, err)
+	query = db.find(t, "FROM books WHERE")
+	assert.Contains(t, query.query, "(author_id <> '' OR EXISTS (SELECT 1 FROM book_contributors c WHERE c.book_id = books.book_id AND LOWER(c.role) = ?))")
+	assert.NotContains(t, query.query, "LIMIT")
+	assert.Equal(t, []driver.Value{"author"}, query.args)
+
+
+	// Counting a filter is a single COUNT query, not a read of every book.
+	db.reset()
+	count, err := repo.CountMatching(models.BookFilter{PublisherID: "p1"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	require.Len(t, db.log(), 1)
+	assert.Equal(t, "SELECT COUNT(*) FROM books WHERE deleted_at IS NULL AND publisher_id = ?", db.log()[0].query)
+	assert.Equal(t, []driver.Value{"p1"}, db.log()[0].args)
+
+	trash, err := repo.GetDeleted(models.PaginationParams{Limit: 5})
+	require.NoError(t, err)
+	require.Len(t, trash, 1)
+	assert.True(t, deletedAt.Equal(*trash[0].DeletedAt))
+}
+
+func TestSQLRepositoryReportsConflicts(t *testing.T) {
+	db, repo := openFakeSQL(t, migrated(),
+		&fakeRule{match: "SELECT version FROM books", columns: []string{"version"}, rows: [][]driver.Value{{int64(2)}}},
+	)
+
+	_, err := repo.Update("b1", models.Book{Title: "Stale", Version: 1})
+	assert.ErrorIs(t, err, repository.ErrVersionConflict)
+	assert.Contains(t, err.Error(), "at version 2, not 1")
+	assert.Equal(t, "ROLLBACK", db.log()[len(db.log())-1].query)
+
+
+	// A writer slipping in between the version check and the UPDATE is
+	// reported with the version it left behind.
+	db, repo = openFakeSQL(t, migrated(),
+		&fakeRule{match: "SELECT version FROM books", columns: []string{"version"}, rows: [][]driver.Value{{int64(3)}}, times: 1},
+		&fakeRule{match: "SELECT version FROM books", columns: []string{"version"}, rows: [][]driver.Value{{int64(5)}}},
+		&fakeRule{match: "SELECT book_id", columns: bookRowColumns, rows: [][]driver.Value{bookRow(models.Book{BookID: "b1", Version: 3})}},
+		&fakeRule{match: "UPDATE books SET deleted_at", affected: 0},
+	)
+
+	_, err = repo.Delete("b1", 0)
+	assert.ErrorIs(t, err, repository.ErrVersionConflict)
+	assert.Contains(t, err.Error(), "at version 5, not 3")
+	update := db.find(t, "UPDATE books SET deleted_at")
+	assert.Equal(t, []driver.Value{"b1", int64(3)}, update.args[1:])
+
+
+	// Unique keys are checked against the stored books.
+	_, repo = openFakeSQL(t, migrated(),
+		&fakeRule{match: "SELECT COUNT(*) FROM books WHERE book_id", columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}},
+		&fakeRule{match: "AND isbn_key = ?", columns: bookRowColumns, rows: [][]driver.Value{bookRow(models.Book{BookID: "other", ISBN: "978-0-306-40615-7"})}},
+	)
+
+	_, err = repo.Create(models.Book{Title: "Copy", ISBN: "0-306-40615-2"})
+	var conflict *repository.ConflictError
+	require.True(t, errors.As(err, &conflict), "%v", err)
+	assert.Equal(t, "other", conflict.BookID)
+	assert.Equal(t, repository.UniqueISBN.Name, conflict.Constraint)
+
+
+	// A writer that raced past the check is stopped by the unique index,
+	// and the violation is reported as a conflict with the key's owner.
+	db, repo = openFakeSQL(t, migrated(),
+		&fakeRule{match: "SELECT COUNT(*) FROM books WHERE book_id", columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}},
+		&fakeRule{match: "SELECT last_seq FROM book_seq", columns: []string{"last_seq"}, rows: [][]driver.Value{{int64(4)}}},
+		&fakeRule{match: "INSERT INTO books", err: errors.New("UNIQUE constraint failed: books.isbn_unique")},
+		&fakeRule{match: "SELECT book_id FROM books WHERE isbn_unique = ?", columns: []string{"book_id"}, rows: [][]driver.Value{{"racer"}}},
+	)
+
+	_, err = repo.Create(models.Book{Title: "Copy", ISBN: "0-306-40615-2"})
+	require.True(t, errors.As(err, &conflict), "%v", err)
+	assert.Equal(t, "racer", conflict.BookID)
+	assert.Equal(t, repository.UniqueISBN.Name, conflict.Constraint)
+	insert := db.find(t, "INSERT INTO books")
+	assert.Equal(t, repository.NormalizeISBN("0-306-40615-2"), insert.args[2])
+	log := db.log()
+	assert.Equal(t, "ROLLBACK", log[len(log)-2].query, "the owner is looked up after the rollback")
+}
+
+func TestSQLRepositoryNumbersBooksFromCounter(t *testing.T) {
+	db, repo := openFakeSQL(t, migrated(),
+		&fakeRule{match: "SELECT COUNT(*) FROM books WHERE book_id", columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}},
+		&fakeRule{match: "SELECT last_seq FROM book_seq", columns: []string{"last_seq"}, rows: [][]driver.Value{{int64(7)}}},
+	)
+
+	_, err := repo.Create(models.Book{BookID: "b1", Title: "Dune"})
+	require.NoError(t, err)
+
+	db.find(t, "UPDATE book_seq SET last_seq = last_seq + 1")
+	insert := db.find(t, "INSERT INTO books")
+	assert.Equal(t, int64(7), insert.args[0])
+}
+
+func TestSQLRepositoryAppliesReplicatedChanges(t *testing.T) {
+	db, repo := openFakeSQL(t, migrated(),
+		&fakeRule{match: "UPDATE books SET author_id", affected: 0},
+		&fakeRule{match: "SELECT last_seq FROM book_seq", columns: []string{"last_seq"}, rows: [][]driver.Value{{int64(8)}}},
+	)
+
+	err := repo.ApplyChanges([]models.Change{
+		{Seq: 1, BookID: "new", Book: &models.Book{BookID: "new", Title: "Copied", Version: 4}},
+		{Seq: 2, BookID: "gone"},
+	})
+	require.NoError(t, err)
+
+	// The new book's seq comes from the counter, not from MAX(seq).
+	db.find(t, "UPDATE book_seq SET last_seq = last_seq + 1")
+	insert := db.find(t, "INSERT INTO books")
+	assert.Equal(t, int64(8), insert.args[0])
+	assert.Equal(t, "new", insert.args[3])
+	assert.Equal(t, int64(4), insert.args[14])
+	assert.Equal(t, []driver.Value{"gone"}, db.find(t, "DELETE FROM books WHERE book_id = ?").args)
+	assert.Equal(t, "COMMIT", db.log()[len(db.log())-1].query)
+}
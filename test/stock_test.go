@@ -0,0 +1,257 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"crud-in-go-lang/internal/controller"
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+	"crud-in-go-lang/internal/router"
+	"crud-in-go-lang/internal/service"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupStock wires books, stock, reservations and locations the way the
+// server does, all in memory.
+func setupStock(t *testing.T, backorders service.BackorderPolicy, opts ...service.StockOption) (*service.BookService, *service.StockService, *mux.Router) {
+	repo, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+
+	ledger := repository.NewMemoryStockLedger()
+	locations := repository.NewMemoryLocationRepository()
+	svc := service.NewBookService(repo, service.WithStockLedger(ledger))
+	stock := service.NewStockService(ledger, svc, backorders, append([]service.StockOption{service.WithLocations(locations)}, opts...)...)
+	r := router.SetupRouter(controller.NewBookController(svc, controller.WithStockLevels(stock)), controller.NewAdminController(svc),
+		controller.NewReplicationController(repository.NewChangeLog(repo, 0), nil),
+		controller.NewStockController(stock), controller.NewLocationController(service.NewLocationService(locations, stock)))
+
+	return svc, stock, r
+}
+
+// stockLevels returns the stock block GET /books/{id} reports.
+func stockLevels(t *testing.T, r http.Handler, bookID string) models.StockLevels {
+	rr := serveJSON(r, "GET", "/books/"+bookID, nil)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var response struct {
+		Stock models.StockLevels `json:"stock"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	return response.Stock
+}
+
+type movementResponse struct {
+	Movement models.StockMovement `json:"movement"`
+	Book     models.Book          `json:"book"`
+}
+
+func recordMovement(t *testing.T, r http.Handler, bookID string, movement models.StockMovement, status int) movementResponse {
+	rr := serveJSON(r, "POST", "/books/"+bookID+"/stock/movements", movement)
+	assert.Equal(t, status, rr.Code, rr.Body.String())
+
+	var response movementResponse
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	return response
+}
+
+func TestStockMovementsDriveQuantity(t *testing.T) {
+	svc, _, r := setupStock(t, service.BackordersRejected)
+	book, err := svc.Create(models.Book{Title: "Dune", Quantity: 5}, "alice")
+	assert.NoError(t, err)
+
+	response := recordMovement(t, r, book.BookID, models.StockMovement{Type: "receipt", Quantity: 10, Reference: "PO-1"}, http.StatusCreated)
+	assert.Equal(t, 15, response.Movement.Balance)
+	assert.Equal(t, 15, response.Book.Quantity)
+
+	response = recordMovement(t, r, book.BookID, models.StockMovement{Type: "sale", Quantity: 3, Reference: "order-7"}, http.StatusCreated)
+	assert.Equal(t, 12, response.Book.Quantity)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "damage", Quantity: 1, Reason: "water damage"}, http.StatusCreated)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "return", Quantity: 1}, http.StatusCreated)
+	response = recordMovement(t, r, book.BookID, models.StockMovement{Type: "adjustment", Quantity: -2, Reason: "stock take"}, http.StatusCreated)
+	assert.Equal(t, 10, response.Book.Quantity)
+
+
+	// The stored quantity became an opening adjustment.
+	rr := serveJSON(r, "GET", "/books/"+book.BookID+"/stock/movements?limit=100", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var page struct {
+		Movements  []models.StockMovement `json:"movements"`
+		TotalCount int                    `json:"total_count"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	assert.Equal(t, 6, page.TotalCount)
+	assert.Equal(t, "opening balance", page.Movements[0].Reason)
+	assert.Equal(t, 5, page.Movements[0].Balance)
+	assert.Equal(t, "PO-1", page.Movements[1].Reference)
+	assert.Equal(t, 6, page.Movements[5].Seq)
+
+
+	// PUT can no longer overwrite the quantity.
+	current, _ := svc.GetByID(book.BookID)
+	current.Quantity = 999
+	current.Version = 0
+	updated, err := svc.Update(book.BookID, *current, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, updated.Quantity)
+
+	history, err := svc.GetHistory(book.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ActionStock, history[1].Action)
+}
+
+func TestStockMovementValidation(t *testing.T) {
+	svc, _, r := setupStock(t, service.BackordersRejected)
+	book, err := svc.Create(models.Book{Title: "Dune", Quantity: 2}, "alice")
+	assert.NoError(t, err)
+
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "theft", Quantity: 1}, http.StatusBadRequest)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "sale", Quantity: 0}, http.StatusBadRequest)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "adjustment", Quantity: 3}, http.StatusBadRequest)
+	recordMovement(t, r, "missing", models.StockMovement{Type: "receipt", Quantity: 1}, http.StatusNotFound)
+
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "sale", Quantity: 3}, http.StatusConflict)
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "damage", Quantity: 3}, http.StatusConflict)
+	unchanged, _ := svc.GetByID(book.BookID)
+	assert.Equal(t, 2, unchanged.Quantity)
+}
+
+func TestStockBackorders(t *testing.T) {
+	svc, _, r := setupStock(t, service.BackordersAllowed)
+	book, err := svc.Create(models.Book{Title: "Dune"}, "alice")
+	assert.NoError(t, err)
+
+	response := recordMovement(t, r, book.BookID, models.StockMovement{Type: "sale", Quantity: 2}, http.StatusCreated)
+	assert.Equal(t, -2, response.Book.Quantity)
+
+	// Only sales may be backordered.
+	recordMovement(t, r, book.BookID, models.StockMovement{Type: "damage", Quantity: 1}, http.StatusConflict)
+
+	response = recordMovement(t, r, book.BookID, models.StockMovement{Type: "receipt", Quantity: 1}, http.StatusCreated)
+	assert.Equal(t, -1, response.Book.Quantity)
+}
+
+func TestFileStockLedgerPersists(t *testing.T) {
+	filename := t.TempDir() + "/stock.jsonl"
+	ledger, err := repository.NewFileStockLedger(filename)
+	assert.NoError(t, err)
+
+	_, err = ledger.Append(models.StockMovement{BookID: "b1", Type: models.MovementReceipt, Quantity: 4, Balance: 4},
+		models.StockMovement{BookID: "b1", Type: models.MovementSale, Quantity: 1, Balance: 3})
+	assert.NoError(t, err)
+	assert.NoError(t, ledger.Close())
+
+	reopened, err := repository.NewFileStockLedger(filename)
+	assert.NoError(t, err)
+	movements, err := reopened.List("b1")
+	assert.NoError(t, err)
+	assert.Len(t, movements, 2)
+	assert.Equal(t, 2, movements[1].Seq)
+	assert.Equal(t, 3, movements[1].Balance)
+}
+
+// failingLedger refuses appends while fail is set.
+type failingLedger struct {
+	repository.StockLedger
+	fail bool
+}
+
+func (l *failingLedger) Append(movements ...models.StockMovement) ([]models.StockMovement, error) {
+	if l.fail {
+		return nil, errors.New("disk full")
+	}
+	return l.StockLedger.Append(movements...)
+}
+
+func TestStockMovementNotRecordedLeavesBookAlone(t *testing.T) {
+	repo, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+	ledger := &failingLedger{StockLedger: repository.NewMemoryStockLedger()}
+	svc := service.NewBookService(repo, service.WithStockLedger(ledger))
+	stock := service.NewStockService(ledger, svc, service.BackordersRejected)
+
+	book, err := svc.Create(models.Book{Title: "Dune", Quantity: 5}, "alice")
+	assert.NoError(t, err)
+	reservation, err := stock.Reserve(book.BookID, models.Reservation{Quantity: 2, Reference: "cart-1"}, "alice")
+	assert.NoError(t, err)
+
+	ledger.fail = true
+	_, _, err = stock.Record(book.BookID, models.StockMovement{Type: models.MovementSale, Quantity: 1}, "alice")
+	assert.Error(t, err)
+	_, _, _, err = stock.Confirm(reservation.ReservationID, "alice")
+	assert.Error(t, err)
+
+	current, _ := svc.GetByID(book.BookID)
+	assert.Equal(t, 5, current.Quantity)
+	movements, _ := ledger.List(book.BookID)
+	assert.Empty(t, movements)
+
+	// The failed confirmation gave the hold back.
+	ledger.fail = false
+	_, _, confirmed, err := stock.Confirm(reservation.ReservationID, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, confirmed.Quantity)
+}
+
+func TestStockReconcilesQuantityChangedOutsideLedger(t *testing.T) {
+	repo, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+	ledger := repository.NewMemoryStockLedger()
+	svc := service.NewBookService(repo, service.WithStockLedger(ledger))
+	stock := service.NewStockService(ledger, svc, service.BackordersRejected)
+
+	book, err := svc.Create(models.Book{Title: "Dune", Quantity: 5}, "alice")
+	assert.NoError(t, err)
+	_, book, err = stock.Record(book.BookID, models.StockMovement{Type: models.MovementReceipt, Quantity: 1}, "alice")
+	assert.NoError(t, err)
+
+	// A hand edit, or another process, sets the quantity directly.
+	book.Quantity = 9
+	_, err = repo.Update(book.BookID, *book)
+	assert.NoError(t, err)
+
+	levels, err := stock.Levels(book.BookID)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, levels.OnHand)
+
+	movement, updated, err := stock.Record(book.BookID, models.StockMovement{Type: models.MovementSale, Quantity: 2}, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, movement.Balance)
+	assert.Equal(t, 7, updated.Quantity)
+
+	movements, _ := ledger.List(book.BookID)
+	assert.Len(t, movements, 4)
+	assert.Equal(t, models.MovementAdjustment, movements[2].Type)
+	assert.Equal(t, 3, movements[2].Quantity)
+	assert.Equal(t, "quantity changed outside the stock ledger", movements[2].Reason)
+}
+
+func TestFileStockLedgerSharedBetweenProcesses(t *testing.T) {
+	filename := t.TempDir() + "/stock.jsonl"
+	first, err := repository.NewFileStockLedger(filename)
+	assert.NoError(t, err)
+	defer first.Close()
+	second, err := repository.NewFileStockLedger(filename)
+	assert.NoError(t, err)
+	defer second.Close()
+
+	_, err = first.Append(models.StockMovement{BookID: "b1", Type: models.MovementReceipt, Quantity: 4, Balance: 4, LocationID: "w"})
+	assert.NoError(t, err)
+	appended, err := second.Append(models.StockMovement{BookID: "b1", Type: models.MovementSale, Quantity: 1, Balance: 3, LocationID: "w"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, appended[0].Seq)
+
+	movements, err := first.List("b1")
+	assert.NoError(t, err)
+	require.Len(t, movements, 2)
+	assert.Equal(t, 3, movements[1].Balance)
+
+	held, err := first.AtLocation("w")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"b1": 3}, held)
+}
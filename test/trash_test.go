@@ -0,0 +1,73 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftDeleteRestoreAndPurge(t *testing.T) {
+	repo, _, ctrl, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	ctrl.RegisterRoutes(router)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	books := createTestBooks(t, ctrl, 3)
+	deleted := books[0]
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/books/"+deleted.BookID).Code)
+
+
+	count, err := repo.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/books/"+deleted.BookID).Code)
+
+	rr := do("GET", "/books/trash")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var trash struct {
+		Books []models.Book `json:"books"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &trash)
+	assert.Equal(t, 1, len(trash.Books))
+	assert.NotNil(t, trash.Books[0].DeletedAt)
+
+
+	rr = do("POST", fmt.Sprintf("/books/%s/restore", deleted.BookID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/books/"+deleted.BookID).Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", fmt.Sprintf("/books/%s/restore", deleted.BookID)).Code)
+
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/books/"+books[1].BookID).Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/books/trash/"+books[2].BookID).Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/books/trash/"+books[1].BookID).Code)
+
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/books/"+books[2].BookID).Code)
+	rr = do("DELETE", "/books/trash?olderThan=1h")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"purged": 0}`, rr.Body.String())
+
+	rr = do("DELETE", "/books/trash")
+	assert.JSONEq(t, `{"purged": 1}`, rr.Body.String())
+
+	trashed, err := repo.GetDeleted(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, trashed)
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	assert.Equal(t, "9780306406157", repository.NormalizeISBN("0-306-40615-2"))
+	assert.Equal(t, "9780306406157", repository.NormalizeISBN("ISBN 978-0-306-40615-7"))
+	assert.Equal(t, "9780804429573", repository.NormalizeISBN("080442957X"))
+	assert.Equal(t, "0306406153", repository.NormalizeISBN("0306406153"))
+	assert.Equal(t, "", repository.NormalizeISBN(" "))
+}
+
+func TestRepositoriesEnforceUniqueISBN(t *testing.T) {
+	dir := t.TempDir()
+
+	file, err := repository.NewFileRepository(filepath.Join(dir, "file.json"))
+	assert.NoError(t, err)
+	journal, err := repository.NewJournalRepository(filepath.Join(dir, "journal.json"), 100)
+	assert.NoError(t, err)
+	memory, err := repository.NewMemoryRepository(nil)
+	assert.NoError(t, err)
+
+	repos := map[string]repository.BookRepository{"file": file, "journal": journal, "memory": memory}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			original, err := repo.Create(models.Book{Title: "Original", ISBN: "0-306-40615-2"})
+			assert.NoError(t, err)
+
+			_, err = repo.Create(models.Book{Title: "Copy", ISBN: "978-0-306-40615-7"})
+			var conflict *repository.ConflictError
+			assert.ErrorAs(t, err, &conflict)
+			assert.Equal(t, original.BookID, conflict.BookID)
+			assert.Equal(t, "isbn", conflict.Constraint)
+
+			found, err := repo.GetByISBN("9780306406157")
+			assert.NoError(t, err)
+			assert.Equal(t, original.BookID, found.BookID)
+
+			original.Title = "Original, revised"
+			_, err = repo.Update(original.BookID, *original)
+			assert.NoError(t, err)
+
+			other, err := repo.Create(models.Book{Title: "Other", ISBN: "080442957X"})
+			assert.NoError(t, err)
+			other.ISBN = "0306406152"
+			_, err = repo.Update(other.BookID, *other)
+			assert.ErrorAs(t, err, &conflict)
+
+
+			// A trashed book keeps its ISBN until it is purged.
+			_, err = repo.Delete(original.BookID, 0)
+			assert.NoError(t, err)
+			_, err = repo.GetByISBN("0306406152")
+			assert.ErrorIs(t, err, repository.ErrNotFound)
+			_, err = repo.Create(models.Book{Title: "Copy", ISBN: "0306406152"})
+			assert.ErrorAs(t, err, &conflict)
+
+			assert.NoError(t, repo.Purge(original.BookID))
+			_, err = repo.Create(models.Book{Title: "Copy", ISBN: "0306406152"})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestUniqueTitleAuthorConstraint(t *testing.T) {
+	repo, err := repository.NewMemoryRepository(nil, repository.WithUniqueConstraints(repository.UniqueISBN, repository.UniqueTitleAuthor))
+	assert.NoError(t, err)
+
+	_, err = repo.Create(models.Book{Title: "Dune", AuthorID: "herbert"})
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{Title: "dune ", AuthorID: "herbert"})
+	var conflict *repository.ConflictError
+	assert.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "title-author", conflict.Constraint)
+
+	_, err = repo.Create(models.Book{Title: "Dune", AuthorID: "someone-else"})
+	assert.NoError(t, err)
+}
+
+func TestISBNEndpoints(t *testing.T) {
+	_, _, ctrl, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	ctrl.RegisterRoutes(router)
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var payload bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&payload).Encode(body)
+		}
+		req, _ := http.NewRequest(method, path, &payload)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("POST", "/books", models.Book{Title: "Original", ISBN: "978-0-306-40615-7"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created models.Book
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	rr = do("POST", "/books", models.Book{Title: "Copy", ISBN: "0306406152"})
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	var response map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	assert.Equal(t, created.BookID, response["conflictingBookId"])
+
+	rr = do("GET", "/books/isbn/0-306-40615-2", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var found models.Book
+	json.Unmarshal(rr.Body.Bytes(), &found)
+	assert.Equal(t, created.BookID, found.BookID)
+
+	assert.Equal(t, http.StatusNotFound, do("GET", "/books/isbn/9780000000000", nil).Code)
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crud-in-go-lang/internal/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateBookHonorsIfMatch(t *testing.T) {
+	_, _, ctrl, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	book := createTestBooks(t, ctrl, 1)[0]
+	assert.Equal(t, 1, book.Version)
+
+	update := func(ifMatch string, title string) *httptest.ResponseRecorder {
+		changed := book
+		changed.Title = title
+		jsonBook, _ := json.Marshal(changed)
+
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/books/%s", book.BookID), bytes.NewBuffer(jsonBook))
+		req.Header.Set("If-Match", ifMatch)
+		req = mux.SetURLVars(req, map[string]string{"id": book.BookID})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ctrl.Update).ServeHTTP(rr, req)
+		return rr
+	}
+
+
+	first := update(`"1"`, "First writer")
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, `"2"`, first.Header().Get("ETag"))
+
+	second := update(`"1"`, "Second writer")
+	assert.Equal(t, http.StatusPreconditionFailed, second.Code)
+
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/books/%s", book.BookID), nil)
+	req = mux.SetURLVars(req, map[string]string{"id": book.BookID})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ctrl.GetByID).ServeHTTP(rr, req)
+
+	var stored models.Book
+	json.Unmarshal(rr.Body.Bytes(), &stored)
+	assert.Equal(t, "First writer", stored.Title)
+	assert.Equal(t, 2, stored.Version)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+}
+
+func TestDeleteBookHonorsIfMatch(t *testing.T) {
+	_, _, ctrl, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	book := createTestBooks(t, ctrl, 1)[0]
+
+	deleteWith := func(ifMatch string) int {
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/books/%s", book.BookID), nil)
+		req.Header.Set("If-Match", ifMatch)
+		req = mux.SetURLVars(req, map[string]string{"id": book.BookID})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ctrl.Delete).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusPreconditionFailed, deleteWith(`"7"`))
+	assert.Equal(t, http.StatusPreconditionFailed, deleteWith(`garbage`))
+	assert.Equal(t, http.StatusNoContent, deleteWith(`W/"1"`))
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"crud-in-go-lang/internal/models"
+	"crud-in-go-lang/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventLog collects ChangeEvents delivered on the watcher goroutine.
+type eventLog struct {
+	mutex  sync.Mutex
+	events []repository.ChangeEvent
+}
+
+func (l *eventLog) add(event repository.ChangeEvent) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) list() []repository.ChangeEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]repository.ChangeEvent(nil), l.events...)
+}
+
+func TestFileRepositoryReloadsExternalEdits(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	repo, err := repository.NewFileRepository(filename, repository.WithWatchInterval(10*time.Millisecond))
+	assert.NoError(t, err)
+	defer repo.Close()
+
+	_, err = repo.Create(models.Book{BookID: "a", Title: "Before"})
+	assert.NoError(t, err)
+	_, err = repo.Create(models.Book{BookID: "gone", Title: "Removed by hand"})
+	assert.NoError(t, err)
+
+	var log eventLog
+	unsubscribe := repo.Subscribe(log.add)
+	defer unsubscribe()
+
+
+	edited := `{"formatVersion": 2, "books": [
+	  {"bookId": "a", "title": "After", "version": 1},
+	  {"bookId": "b", "title": "Added", "version": 1}
+	]}`
+	assert.NoError(t, os.WriteFile(filename, []byte(edited), 0644))
+
+	assert.Eventually(t, func() bool { return len(log.list()) == 3 }, time.Second, 5*time.Millisecond)
+
+	byID := make(map[string]repository.ChangeEvent)
+	for _, event := range log.list() {
+		byID[event.BookID] = event
+	}
+	assert.Equal(t, "Before", byID["a"].Before.Title)
+	assert.Equal(t, "After", byID["a"].After.Title)
+	assert.Nil(t, byID["b"].Before)
+	assert.Equal(t, "Added", byID["b"].After.Title)
+	assert.Nil(t, byID["gone"].After)
+
+
+	// Writes through the repository are announced too.
+	_, err = repo.Create(models.Book{BookID: "c", Title: "Created"})
+	assert.NoError(t, err)
+	events := log.list()
+	assert.Equal(t, 4, len(events))
+	assert.Equal(t, "c", events[3].BookID)
+	assert.Nil(t, events[3].Before)
+}
+
+func TestFileRepositoryRejectsInvalidEdits(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "books.json")
+
+	repo, err := repository.NewFileRepository(filename, repository.WithWatchInterval(10*time.Millisecond))
+	assert.NoError(t, err)
+	defer repo.Close()
+
+	_, err = repo.Create(models.Book{Title: "Good"})
+	assert.NoError(t, err)
+
+	var log eventLog
+	defer repo.Subscribe(log.add)()
+
+
+	assert.NoError(t, os.WriteFile(filename, []byte(`{"formatVersion": 2, "books": [{"bookId": `), 0644))
+	time.Sleep(50 * time.Millisecond)
+
+	books, err := repo.GetAll(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	require.Len(t, books, 1)
+	assert.Equal(t, "Good", books[0].Title)
+	assert.Empty(t, log.list())
+
+
+	// The next write replaces the rejected edit, which is kept aside.
+	_, err = repo.Create(models.Book{Title: "Better"})
+	assert.NoError(t, err)
+
+	count, err := repo.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	rejected, _ := filepath.Glob(filename + ".corrupt-*")
+	assert.Equal(t, 1, len(rejected))
+
+	backup, err := os.ReadFile(filename + ".bak")
+	assert.NoError(t, err)
+	assert.Contains(t, string(backup), "Good")
+}
+
+func TestFileRepositoryIgnoresTruncatedFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+
+	repo, err := repository.NewFileRepository(filename, repository.WithWatchInterval(10*time.Millisecond))
+	assert.NoError(t, err)
+	defer repo.Close()
+
+	_, err = repo.Create(models.Book{Title: "Good"})
+	assert.NoError(t, err)
+
+	// An editor that has truncated the file but not written it yet.
+	assert.NoError(t, os.WriteFile(filename, nil, 0644))
+	time.Sleep(50 * time.Millisecond)
+
+	books, err := repo.GetAll(models.PaginationParams{Limit: 10})
+	assert.NoError(t, err)
+	require.Len(t, books, 1)
+
+	_, err = repo.Create(models.Book{Title: "Better"})
+	assert.NoError(t, err)
+
+	reopened, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+	count, err := reopened.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	backup, err := os.ReadFile(filename + ".bak")
+	assert.NoError(t, err)
+	assert.Contains(t, string(backup), "Good")
+}
+
+func TestFileRepositoryInitializesEmptyFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "books.json")
+	assert.NoError(t, os.WriteFile(filename, nil, 0644))
+
+	repo, err := repository.NewFileRepository(filename)
+	assert.NoError(t, err)
+
+	count, err := repo.Count()
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}